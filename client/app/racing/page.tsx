@@ -291,6 +291,7 @@ export default function RacingPage() {
         const isReady = raceState?.yourProgress?.ready || false;
         const readyCount = raceState?.readyCount || 0;
         const totalPlayers = raceState?.totalPlayers || 0;
+        const startsIn = Math.ceil(raceState?.timeRemaining || 0);
 
         console.log("Lobby state:", { isReady, readyCount, totalPlayers, yourProgress: raceState?.yourProgress });
 
@@ -299,6 +300,11 @@ export default function RacingPage() {
                 <div className="text-center space-y-6">
                     <h1 className="text-4xl font-bold">⏳ Lobby</h1>
                     <p className="text-xl">Click Ready when you're set!</p>
+                    {startsIn > 0 && (
+                        <p className="text-2xl font-bold text-yellow-300">
+                            Race starts in {startsIn}s
+                        </p>
+                    )}
 
                     <button
                         onClick={() => {
@@ -452,7 +458,9 @@ export default function RacingPage() {
                                         </div>
                                         <div className="text-right">
                                             <div className="text-xl font-bold">
-                                                {result.finishTime.toFixed(2)}s
+                                                {result.dnf
+                                                    ? `DNF (${Math.round(result.progress * 100)}%)`
+                                                    : `${result.finishTime.toFixed(2)}s`}
                                             </div>
                                             <div className="text-lg">
                                                 {result.mouthActionsPerMinute.toFixed(1)} MAPM
@@ -6,6 +6,7 @@ import type {
     ClientMessage,
     ServerMessage,
     WelcomePayload,
+    MetaTokenPayload,
     GameStatePayload,
     FishModel,
 } from "@/types/game";
@@ -14,6 +15,7 @@ export class GameConnection {
     private ws: WebSocket | null = null; // Primary: position updates
     private metaWs: WebSocket | null = null; // Secondary: metadata
     private clientId: string | null = null;
+    private metaToken: string | null = null; // One-time secret for pairing the metadata socket
     private inputSeq: number = 0;
     private inputInterval: number | null = null;
     private lastGameState: GameStatePayload | null = null;
@@ -80,6 +82,14 @@ export class GameConnection {
                     this.onStateUpdate(msg.payload as GameStatePayload);
                     break;
 
+                case "metaToken":
+                    // Arrives before the welcome, and again if the metadata socket drops
+                    this.metaToken = (msg.payload as MetaTokenPayload).token;
+                    if (this.clientId && !this.metaWs) {
+                        this.connectMetaSocket(serverUrl, this.clientId);
+                    }
+                    break;
+
                 case "leaderboard":
                     // Handled by metadata socket now
                     break;
@@ -102,11 +112,18 @@ export class GameConnection {
     }
 
     private connectMetaSocket(primaryUrl: string, clientId: string): void {
+        if (!this.metaToken) {
+            return; // Metadata is sent on the primary socket until we can pair
+        }
+
         // Convert primary URL to metadata URL
-        const metaUrl = primaryUrl.replace('/ws', '/ws/meta') + `?id=${clientId}`;
+        const metaUrl = primaryUrl.replace('/ws', '/ws/meta') +
+            `?id=${encodeURIComponent(clientId)}&token=${encodeURIComponent(this.metaToken)}`;
+        this.metaToken = null; // Tokens are single use
         
-        console.log("Connecting to metadata socket:", metaUrl);
-        this.metaWs = new WebSocket(metaUrl);
+        console.log("Connecting to metadata socket");
+        const metaWs = new WebSocket(metaUrl);
+        this.metaWs = metaWs;
         this.metaWs.binaryType = 'arraybuffer';
 
         this.metaWs.onopen = () => {
@@ -121,6 +138,9 @@ export class GameConnection {
 
         this.metaWs.onclose = () => {
             console.log("Metadata WebSocket closed");
+            if (this.metaWs === metaWs) {
+                this.metaWs = null; // The server sends a new metaToken to re-pair
+            }
         };
 
         this.metaWs.onerror = (err) => {
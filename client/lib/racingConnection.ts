@@ -12,6 +12,8 @@ export interface RacingClientMessage {
     fishState?: {
         mouthCycles: number;
     };
+    ghost?: 'best' | 'record'; // join: race a ghost on your own
+    raceId?: string; // spectate
 }
 
 export interface RacingServerMessage {
@@ -25,6 +27,20 @@ export interface RaceWelcomePayload {
     name: string;
     model: string;
     raceState: string;
+    ghost?: {
+        kind: 'best' | 'record';
+        name: string;
+        model: string;
+        finishTime: number;
+    };
+}
+
+export interface RaceFormat {
+    type: 'distance' | 'timeTrial' | 'elimination';
+    cycles?: number; // distance: cycles to finish
+    duration?: number; // timeTrial: seconds
+    lapCycles?: number; // elimination: cycles per lap
+    items?: boolean; // distance: power-ups and obstacles
 }
 
 export interface RacePlayerState {
@@ -32,18 +48,39 @@ export interface RacePlayerState {
     name: string;
     model: string;
     distance: number;
-    progress: number; // 0.0 to 1.0
+    progress: number; // 0.0 to 1.0, relative to the leader in a time trial
+    cycles: number;
     finished: boolean;
     ready: boolean;
+    dnf?: boolean;
+    eliminated?: boolean;
+    ghost?: boolean; // a replayed run, not a connected player
+    item?: 'bubble' | 'bite'; // held item
+    boosted?: boolean; // bubble cycles left
+    slowed?: boolean; // bitten
+    blocked?: boolean; // stuck at a jellyfish until the mouth is held open
+}
+
+export interface ItemUsedPayload {
+    playerId: string;
+    item: 'bubble' | 'bite';
+    targetId?: string; // bites
 }
 
 export interface RaceStatePayload {
     raceState: string;
     timeRemaining?: number;
+    format: RaceFormat;
+    lap?: number; // elimination: current lap
+    totalLaps?: number;
+    pickups?: number[]; // items races: item pickups, as progress
+    jellyfish?: number[]; // items races: jellyfish positions, as progress
     players: RacePlayerState[];
     yourProgress: RacePlayerState;
     readyCount: number;
     totalPlayers: number;
+    spectators?: number; // clients watching
+    spectator?: boolean; // you're watching; yourProgress is empty
 }
 
 export interface RaceResult {
@@ -53,9 +90,50 @@ export interface RaceResult {
     finishTime: number;
     mouthActionsPerMinute: number;
     rank: number;
+    progress: number; // 0.0 to 1.0, 1.0 for finishers
+    dnf?: boolean; // Did not finish: disconnected, stalled or out of time
+    format: RaceFormat['type'];
+    cycles: number; // time trial score
+    eliminatedLap?: number; // elimination: lap knocked out on, absent for the winner
+    rating?: number; // skill rating after a rated race
+    ratingChange?: number;
+    splits?: number[]; // seconds to each 10% of progress; none in time trials
+    peakRate: number; // best cycles per minute over 5 seconds
+    averageRate: number; // cycles per minute
+    longestPause: number; // seconds without a cycle
+    consistency: number; // 0-100, how evenly cycles were spread
+}
+
+// Full results of a past race, from GET /racing/results/{raceId}
+export interface RaceRecord {
+    raceId: string;
+    format: RaceFormat;
+    startedAt: string;
+    endedAt: string;
+    results: RaceResult[];
+}
+
+export interface QueuedPayload {
+    rating: number;
+    waiting: number; // players in the queue, including you
+}
+
+// A live race you can watch, from listRaces
+export interface RaceListing {
+    raceId: string;
+    raceState: 'countdown' | 'racing';
+    format: RaceFormat;
+    players: string[]; // racer names
+    elapsed: number; // seconds since the start; 0 during the countdown
+    spectators: number;
+}
+
+export interface RaceListPayload {
+    races: RaceListing[];
 }
 
 export interface RaceResultsPayload {
+    format: RaceFormat;
     results: RaceResult[];
 }
 
@@ -155,6 +233,11 @@ export class RacingConnection {
         });
     }
 
+    // Use the held power-up
+    sendUseItem(): void {
+        this.send({ type: "useItem" });
+    }
+
     // Send fish state update with cycle count
     sendStateUpdate(mouthCycles: number): void {
         console.log("sendStateUpdate called with mouthCycles:", mouthCycles);
@@ -76,7 +76,11 @@ export interface WelcomePayload {
     worldHeight: number;
 }
 
+export interface MetaTokenPayload {
+    token: string;
+}
+
 export interface ServerMessage {
-    type: "welcome" | "state" | "pong" | "leaderboard";
-    payload?: WelcomePayload | GameStatePayload | LeaderboardEntry[];
+    type: "welcome" | "state" | "pong" | "leaderboard" | "metaToken";
+    payload?: WelcomePayload | GameStatePayload | LeaderboardEntry[] | MetaTokenPayload;
 }
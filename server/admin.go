@@ -0,0 +1,384 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// AdminTokenEnv is the environment variable holding the admin API bearer token.
+// The admin API is disabled when it is unset.
+const AdminTokenEnv = "ADMIN_TOKEN"
+
+// Moderation holds server-wide bans and muted names
+type Moderation struct {
+	BannedIPs  map[string]bool
+	MutedNames map[string]bool // Lower-cased
+	mu         sync.RWMutex
+}
+
+// moderation is shared by the game, racing and admin handlers
+var moderation = NewModeration()
+
+// NewModeration creates an empty moderation list
+func NewModeration() *Moderation {
+	return &Moderation{
+		BannedIPs:  make(map[string]bool),
+		MutedNames: make(map[string]bool),
+	}
+}
+
+// IsBanned reports whether connections from ip are refused
+func (m *Moderation) IsBanned(ip string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.BannedIPs[ip]
+}
+
+// Ban refuses future connections from ip
+func (m *Moderation) Ban(ip string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.BannedIPs[ip] = true
+}
+
+// Unban allows connections from ip again
+func (m *Moderation) Unban(ip string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.BannedIPs, ip)
+}
+
+// Mute stops name from being displayed
+func (m *Moderation) Mute(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.MutedNames[strings.ToLower(name)] = true
+}
+
+// IsMuted reports whether name has been muted
+func (m *Moderation) IsMuted(name string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.MutedNames[strings.ToLower(name)]
+}
+
+// ClientIP returns the address a request came from. The Fly-Client-IP header set by
+// Fly.io's proxy is used only when the connection comes from a trusted proxy, since a
+// client could otherwise pick a new address per connection to dodge bans.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if remote := net.ParseIP(host); remote != nil && trustedProxies.Trusts(remote) {
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("Fly-Client-IP"))); ip != nil {
+			return ip.String()
+		}
+	}
+	return host
+}
+
+// AdminRoom describes a room (the ocean or a race) in the admin API
+type AdminRoom struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"` // "ocean" or "race"
+	State      string `json:"state,omitempty"`
+	Code       string `json:"code,omitempty"` // Private lobby code
+	Players    int    `json:"players"`
+	Spectators int    `json:"spectators,omitempty"`
+}
+
+// AdminPlayer describes a connected player in the admin API
+type AdminPlayer struct {
+	ID            string  `json:"id"`
+	Name          string  `json:"name"`
+	Model         string  `json:"model"`
+	IP            string  `json:"ip"`
+	Room          string  `json:"room"`
+	Score         int     `json:"score,omitempty"`
+	Size          float64 `json:"size,omitempty"`
+	Alive         bool    `json:"alive,omitempty"`
+	PowerupActive bool    `json:"powerupActive,omitempty"`
+	Progress      float64 `json:"progress,omitempty"`
+	MouthCycles   int     `json:"mouthCycles,omitempty"`
+	Finished      bool    `json:"finished,omitempty"`
+}
+
+// AdminRequest is the JSON body accepted by the admin POST endpoints
+type AdminRequest struct {
+	ID      string  `json:"id,omitempty"`
+	IP      string  `json:"ip,omitempty"`
+	Name    string  `json:"name,omitempty"`
+	RaceID  string  `json:"raceId,omitempty"`
+	Kind    string  `json:"kind,omitempty"` // "food" or "powerup"
+	X       float64 `json:"x,omitempty"`
+	Y       float64 `json:"y,omitempty"`
+	Message string  `json:"message,omitempty"`
+}
+
+// AnnouncementPayload is a server-wide message shown to every client
+type AnnouncementPayload struct {
+	Message string `json:"message"`
+}
+
+// HandleAdmin serves the authenticated /admin API
+func HandleAdmin(world *World, racingWorld *RacingWorld) http.HandlerFunc {
+	token := os.Getenv(AdminTokenEnv)
+	if token == "" {
+		log.Printf("%s not set - admin API disabled", AdminTokenEnv)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		route := strings.TrimPrefix(r.URL.Path, "/admin")
+		if r.Method == http.MethodGet {
+			switch route {
+			case "/rooms":
+				writeJSON(w, listRooms(world, racingWorld))
+			case "/players":
+				writeJSON(w, listPlayers(world, racingWorld))
+			default:
+				http.NotFound(w, r)
+			}
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req AdminRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+
+		switch route {
+		case "/kick":
+			if !kickClient(world, racingWorld, req.ID, "kicked") {
+				http.Error(w, "Player not found", http.StatusNotFound)
+				return
+			}
+			log.Printf("Admin kicked %s", req.ID)
+
+		case "/ban":
+			ip := req.IP
+			if ip == "" {
+				ip = findClientIP(world, racingWorld, req.ID)
+			}
+			if ip == "" {
+				http.Error(w, "Player not found", http.StatusNotFound)
+				return
+			}
+			moderation.Ban(ip)
+			kicked := kickIP(world, racingWorld, ip)
+			log.Printf("Admin banned %s (%d connections closed)", ip, kicked)
+
+		case "/unban":
+			if req.IP == "" {
+				http.Error(w, "Missing ip", http.StatusBadRequest)
+				return
+			}
+			moderation.Unban(req.IP)
+			log.Printf("Admin unbanned %s", req.IP)
+
+		case "/mute":
+			if req.Name == "" {
+				http.Error(w, "Missing name", http.StatusBadRequest)
+				return
+			}
+			moderation.Mute(req.Name)
+			renamed := world.RenameMuted() + racingWorld.RenameMuted()
+			log.Printf("Admin muted name %q (%d players renamed)", req.Name, renamed)
+
+		case "/race/end":
+			race := racingWorld.GetRace(req.RaceID)
+			if race == nil {
+				http.Error(w, "Race not found", http.StatusNotFound)
+				return
+			}
+			if !race.ForceEnd() {
+				http.Error(w, "Race is not running", http.StatusConflict)
+				return
+			}
+			log.Printf("Admin force-ended race %s", req.RaceID)
+
+		case "/spawn":
+			position := Vec2{X: Clamp(req.X, 0, WorldWidth), Y: Clamp(req.Y, 0, WorldHeight)}
+			switch req.Kind {
+			case "food":
+				world.SpawnFoodAt(position)
+			case "powerup":
+				world.SpawnPowerupAt(position)
+			default:
+				http.Error(w, "Unknown kind (expected food or powerup)", http.StatusBadRequest)
+				return
+			}
+			log.Printf("Admin spawned %s at (%.0f, %.0f)", req.Kind, position.X, position.Y)
+
+		case "/announce":
+			if req.Message == "" {
+				http.Error(w, "Missing message", http.StatusBadRequest)
+				return
+			}
+			world.BroadcastAnnouncement(req.Message)
+			racingWorld.BroadcastAnnouncement(req.Message)
+			log.Printf("Admin announcement: %s", req.Message)
+
+		default:
+			http.NotFound(w, r)
+			return
+		}
+
+		writeJSON(w, map[string]bool{"ok": true})
+	}
+}
+
+// listRooms returns the ocean and every race
+func listRooms(world *World, racingWorld *RacingWorld) []AdminRoom {
+	world.mu.RLock()
+	rooms := []AdminRoom{{
+		ID:         "ocean",
+		Type:       "ocean",
+		Players:    len(world.Players),
+		Spectators: len(world.Spectators),
+	}}
+	world.mu.RUnlock()
+
+	for _, race := range racingWorld.ListRaces() {
+		snap := race.Snapshot()
+		rooms = append(rooms, AdminRoom{
+			ID:      snap.ID,
+			Type:    "race",
+			State:   snap.State.String(),
+			Players: len(snap.Players),
+			Code:    snap.Code,
+		})
+	}
+
+	return rooms
+}
+
+// listPlayers returns every ocean player and racer with their stats
+func listPlayers(world *World, racingWorld *RacingWorld) []AdminPlayer {
+	players := make([]AdminPlayer, 0)
+
+	world.mu.RLock()
+	for _, p := range world.Players {
+		entry := AdminPlayer{
+			ID:            p.ID,
+			Name:          p.Name,
+			Model:         p.Model,
+			Room:          "ocean",
+			Score:         p.Score,
+			Size:          p.Size,
+			Alive:         p.Alive,
+			PowerupActive: p.PowerupActive,
+		}
+		if p.Client != nil {
+			entry.IP = p.Client.IP
+		}
+		players = append(players, entry)
+	}
+	world.mu.RUnlock()
+
+	for _, race := range racingWorld.ListRaces() {
+		for _, p := range race.Snapshot().Players {
+			entry := AdminPlayer{
+				ID:          p.ID,
+				Name:        p.Name,
+				Model:       p.Model,
+				Room:        race.ID,
+				Progress:    p.Progress,
+				MouthCycles: p.MouthCycles,
+				Finished:    p.Finished,
+			}
+			if p.Client != nil {
+				entry.IP = p.Client.IP
+			}
+			players = append(players, entry)
+		}
+	}
+
+	return players
+}
+
+// findClientIP returns the IP of an ocean or racing client by ID
+func findClientIP(world *World, racingWorld *RacingWorld, id string) string {
+	if client := world.FindClient(id); client != nil {
+		return client.IP
+	}
+	if client := racingWorld.FindClient(id); client != nil {
+		return client.IP
+	}
+	return ""
+}
+
+// kickClient closes an ocean or racing client's connection by ID
+func kickClient(world *World, racingWorld *RacingWorld, id, reason string) bool {
+	if client := world.FindClient(id); client != nil {
+		closeWithCode(client.Conn, websocket.ClosePolicyViolation, reason)
+		return true
+	}
+	if client := racingWorld.FindClient(id); client != nil {
+		closeWithCode(client.Conn, websocket.ClosePolicyViolation, reason)
+		return true
+	}
+	return false
+}
+
+// kickIP closes every ocean and racing connection from ip, joined or not, and
+// returns how many were closed
+func kickIP(world *World, racingWorld *RacingWorld, ip string) int {
+	var conns []*websocket.Conn
+	for _, client := range world.ClientsFrom(ip) {
+		conns = append(conns, client.Conn)
+	}
+	for _, client := range racingWorld.ClientsFrom(ip) {
+		conns = append(conns, client.Conn)
+	}
+
+	for _, conn := range conns {
+		closeWithCode(conn, websocket.ClosePolicyViolation, "banned")
+	}
+	return len(conns)
+}
+
+// closeWithCode sends a close frame with the given code and reason and closes the
+// connection. The read pump then sees the error and runs the normal disconnect cleanup.
+func closeWithCode(conn *websocket.Conn, code int, reason string) {
+	if conn == nil {
+		return
+	}
+	msg := websocket.FormatCloseMessage(code, reason)
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	conn.Close()
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing admin response: %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKickFindsEveryConnection(t *testing.T) {
+	world := NewWorld()
+	racingWorld := NewRacingWorld()
+	race, _ := startedRace(racingWorld, time.Second, "Nemo")
+
+	// An ocean client that hasn't joined, a racing client that hasn't joined and a
+	// race spectator, all from one address
+	ocean := NewClient(NewClientID(), nil, world)
+	ocean.IP = "203.0.113.7"
+	world.AddClient(ocean)
+
+	lobbyClient := newTestRacer(racingWorld)
+	lobbyClient.IP = "203.0.113.7"
+	racingWorld.AddClient(lobbyClient)
+
+	spectator := newTestRacer(racingWorld)
+	spectator.IP = "203.0.113.7"
+	racingWorld.AddClient(spectator)
+	spectator.Spectate(race.ID)
+
+	for _, id := range []string{ocean.ID, lobbyClient.ID, spectator.ID} {
+		if findClientIP(world, racingWorld, id) != "203.0.113.7" {
+			t.Errorf("client %s should be found by ID", id)
+		}
+		if !kickClient(world, racingWorld, id, "kicked") {
+			t.Errorf("client %s should be kickable", id)
+		}
+	}
+	if kicked := kickIP(world, racingWorld, "203.0.113.7"); kicked != 3 {
+		t.Errorf("IP ban should close all 3 connections, closed %d", kicked)
+	}
+
+	// Closed connections are forgotten
+	world.Disconnect(ocean)
+	racingWorld.RemoveClient(lobbyClient)
+	if world.FindClient(ocean.ID) != nil || racingWorld.FindClient(lobbyClient.ID) != nil {
+		t.Error("disconnected clients should be forgotten")
+	}
+}
+
+func TestMuteRenamesRacers(t *testing.T) {
+	defer func(saved *Moderation) { moderation = saved }(moderation)
+	moderation = NewModeration()
+
+	racingWorld := NewRacingWorld()
+	race, clients := startedRace(racingWorld, time.Second, "Nemo", "Dory")
+
+	moderation.Mute("nemo")
+	if renamed := racingWorld.RenameMuted(); renamed != 1 {
+		t.Fatalf("one racer should be renamed, got %d", renamed)
+	}
+	names := map[string]string{}
+	for _, player := range race.Snapshot().Players {
+		names[player.ID] = player.Name
+	}
+	if names[clients[0].ID] != DefaultPlayerName || names[clients[1].ID] != "Dory" {
+		t.Errorf("only the muted racer should be renamed: %v", names)
+	}
+}
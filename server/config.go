@@ -36,11 +36,24 @@ const (
 	SizeMultiplier = 1.0 // need to be this much bigger to eat another fish (1.0 = same size allowed)
 	VelocityLerp   = 0.1 // smoothing factor for velocity changes
 
+	// Spawning
+	SpawnMargin         = 100.0 // keep spawns away from the hard border
+	SpawnCandidates     = 12    // candidate points sampled per spawn
+	SpawnSafeDistance   = 800.0 // a candidate this far from any larger fish is taken immediately
+	SpawnProtectionTime = 3.0   // seconds a fresh spawn can neither eat nor be eaten
+
 	// Network
-	InputQueueSize   = 10000
-	WriteChannelSize = 256
-	PingInterval     = 2000 // milliseconds
-	MaxPlayerNameLen = 20
+	WriteChannelSize    = 256
+	PingInterval        = 2000 // milliseconds
+	MaxPlayerNameLen    = 20
+	DefaultPlayerName   = "Fish"
+	MaxSpectators       = 100
+	MaxMessageSize      = 1024 // bytes; larger client messages close the connection
+	MaxConnectionsPerIP = 10   // per endpoint, on /ws and /ws/racing
+
+	// Shutdown
+	ShutdownNoticeTime   = 10 // seconds clients are warned before the server goes away
+	ShutdownRaceDeadline = 90 // seconds running races get to finish before they're force-ended
 
 	// Collision
 	BounceStrength = 150.0 // Push force when bodies collide
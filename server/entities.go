@@ -24,12 +24,15 @@ type Player struct {
 	// Input state - persists between updates
 	InputDirection Vec2
 	InputBoost     bool
+	PendingInput   *PlayerInput // Latest unprocessed input, guarded by mu
 	Client         *Client
 	mu             sync.RWMutex
 	// Powerup state
 	PowerupActive   bool
 	PowerupDuration float64
 	BaseSize        float64 // For pufferfish size powerup
+	// Spawn protection - can neither eat nor be eaten while > 0
+	SpawnProtection float64
 }
 
 // NewPlayer creates a new player at a random position
@@ -41,7 +44,7 @@ func NewPlayer(id, name, model string, client *Client) *Player {
 		ID:             id,
 		Name:           name,
 		Model:          model,
-		Position:       Vec2{X: RandomFloat(100, WorldWidth-100), Y: RandomFloat(100, WorldHeight-100)},
+		Position:       RandomSpawnPoint(),
 		Velocity:       Vec2{X: 0, Y: 0},
 		Size:           InitialPlayerSize,
 		Score:          0,
@@ -62,9 +65,14 @@ func (p *Player) GetBounds() Rect {
 	}
 }
 
-// Respawn resets player to initial state at a random position
-func (p *Player) Respawn() {
-	p.Position = Vec2{X: RandomFloat(100, WorldWidth-100), Y: RandomFloat(100, WorldHeight-100)}
+// RandomSpawnPoint returns a uniformly random point away from the world border
+func RandomSpawnPoint() Vec2 {
+	return Vec2{X: RandomFloat(SpawnMargin, WorldWidth-SpawnMargin), Y: RandomFloat(SpawnMargin, WorldHeight-SpawnMargin)}
+}
+
+// Respawn resets player to initial state at the given position
+func (p *Player) Respawn(position Vec2) {
+	p.Position = position
 	p.Velocity = Vec2{X: 0, Y: 0}
 	p.Size = InitialPlayerSize
 	p.Rotation = 0
@@ -73,6 +81,12 @@ func (p *Player) Respawn() {
 	p.KilledBy = ""
 	p.InputDirection = Vec2{X: 0, Y: 0}
 	p.InputBoost = false
+	p.SpawnProtection = SpawnProtectionTime
+}
+
+// IsSpawnProtected reports whether the player is still inside its spawn protection window
+func (p *Player) IsSpawnProtected() bool {
+	return p.SpawnProtection > 0
 }
 
 // GetHitboxConfig returns the hitbox configuration for this player's model
@@ -188,3 +202,22 @@ type PlayerInput struct {
 	Seq       uint32
 	Timestamp time.Time
 }
+
+// SetInput stores input as the player's pending input, replacing any the game loop
+// hasn't processed yet. Only the latest input matters, so a flooding client can
+// never hold more than one slot.
+func (p *Player) SetInput(input PlayerInput) {
+	p.mu.Lock()
+	p.PendingInput = &input
+	p.mu.Unlock()
+}
+
+// TakeInput returns and clears the pending input, or nil if there is none
+func (p *Player) TakeInput() *PlayerInput {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	input := p.PendingInput
+	p.PendingInput = nil
+	return input
+}
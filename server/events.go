@@ -0,0 +1,142 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// GameEventType identifies what happened in a GameEvent
+type GameEventType byte
+
+// Event types (values are sent as-is in the binary event message)
+const (
+	EventPlayerAte        GameEventType = 1 // Player ate Target
+	EventPowerupCollected GameEventType = 2 // Player picked up a powerup
+	EventPowerupExpired   GameEventType = 3 // Player's powerup ran out
+	EventPlayerJoined     GameEventType = 4 // Player entered the ocean
+	EventPlayerLeft       GameEventType = 5 // Player disconnected
+	EventNewLeader        GameEventType = 6 // Player took the top leaderboard spot
+)
+
+// EventQueueSize is how many events can be pending before new ones are dropped
+const EventQueueSize = 1024
+
+// String returns the event type name used in JSON and logs
+func (t GameEventType) String() string {
+	switch t {
+	case EventPlayerAte:
+		return "playerAte"
+	case EventPowerupCollected:
+		return "powerupCollected"
+	case EventPowerupExpired:
+		return "powerupExpired"
+	case EventPlayerJoined:
+		return "joined"
+	case EventPlayerLeft:
+		return "left"
+	case EventNewLeader:
+		return "newLeader"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText makes event types readable in JSON
+func (t GameEventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// GameEvent describes something that happened in the world
+type GameEvent struct {
+	Type       GameEventType `json:"type"`
+	PlayerID   string        `json:"playerId"`
+	PlayerName string        `json:"playerName"`
+	TargetID   string        `json:"targetId,omitempty"` // Eaten player for EventPlayerAte
+	TargetName string        `json:"targetName,omitempty"`
+	Score      int           `json:"score"` // Player's score when the event happened
+	Time       time.Time     `json:"time"`
+}
+
+// EventHandler receives published events
+type EventHandler func(GameEvent)
+
+// EventBus decouples gameplay from side effects (kill feed, stats, metrics).
+// Events are published from inside the game tick without blocking and are
+// delivered to subscribers on a separate goroutine, outside the world lock.
+type EventBus struct {
+	events   chan GameEvent
+	handlers []EventHandler
+	closed   bool          // No more events are accepted
+	done     chan struct{} // Closed when Run has delivered the last event
+	mu       sync.RWMutex
+}
+
+// NewEventBus creates an event bus
+func NewEventBus() *EventBus {
+	return &EventBus{
+		events: make(chan GameEvent, EventQueueSize),
+		done:   make(chan struct{}),
+	}
+}
+
+// Subscribe registers a handler for every future event
+func (b *EventBus) Subscribe(handler EventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
+
+// Publish queues an event for delivery (non-blocking)
+func (b *EventBus) Publish(event GameEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.closed {
+		return // Shutting down
+	}
+
+	select {
+	case b.events <- event:
+	default:
+		// Queue full, drop event
+		log.Printf("Event queue full, dropping %s event for %s", event.Type, event.PlayerID)
+	}
+}
+
+// Run delivers queued events to subscribers until the bus is closed
+func (b *EventBus) Run() {
+	defer close(b.done)
+
+	for event := range b.events {
+		b.mu.RLock()
+		handlers := b.handlers
+		b.mu.RUnlock()
+
+		for _, handler := range handlers {
+			handler(event)
+		}
+	}
+}
+
+// Close stops accepting events and waits up to timeout for the queued ones to be
+// delivered, so subscribers (stats, metrics) see everything before exit. Returns
+// false on timeout, or if Run was never started.
+func (b *EventBus) Close(timeout time.Duration) bool {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.events)
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-b.done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
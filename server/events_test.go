@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventBusCloseFlushesQueuedEvents(t *testing.T) {
+	bus := NewEventBus()
+	delivered := make(chan GameEvent, 10)
+	bus.Subscribe(func(event GameEvent) {
+		time.Sleep(10 * time.Millisecond) // A slow stats writer
+		delivered <- event
+	})
+
+	for i := 0; i < 5; i++ {
+		bus.Publish(GameEvent{Type: EventPlayerJoined, PlayerID: "p"})
+	}
+	go bus.Run()
+
+	if !bus.Close(time.Second) {
+		t.Fatal("Close timed out")
+	}
+	if len(delivered) != 5 {
+		t.Fatalf("delivered %d events before Close returned, want 5", len(delivered))
+	}
+
+	// Publishing after close is dropped rather than panicking
+	bus.Publish(GameEvent{Type: EventPlayerLeft, PlayerID: "p"})
+	if !bus.Close(time.Second) {
+		t.Fatal("second Close should return immediately")
+	}
+}
+
+func TestEventBusCloseWithoutRunTimesOut(t *testing.T) {
+	if NewEventBus().Close(10 * time.Millisecond) {
+		t.Fatal("Close should report the events weren't delivered")
+	}
+}
+
+func TestEventBusPublishDoesNotBlockWhenFull(t *testing.T) {
+	bus := NewEventBus() // Run isn't started, so nothing drains the queue
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < EventQueueSize+10; i++ {
+			bus.Publish(GameEvent{Type: EventPlayerJoined, PlayerID: "p"})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a full queue")
+	}
+	if len(bus.events) != EventQueueSize {
+		t.Errorf("queued %d events, want %d", len(bus.events), EventQueueSize)
+	}
+}
+
+func TestWorldEventsArriveInOrder(t *testing.T) {
+	world := NewWorld()
+	delivered := make(chan GameEvent, 10)
+	world.Events.Subscribe(world.BroadcastEvent) // As Start does
+	world.Events.Subscribe(func(event GameEvent) { delivered <- event })
+	go world.Events.Run()
+	defer world.Events.Close(time.Second)
+
+	shark := addFish(world, "shark", Vec2{X: 1000, Y: 1000}, 180)
+	shark.Client = NewClient(shark.ID, nil, world)
+	shark.Client.ProtocolVersion = ProtocolV2 // v1 has no binary event message
+	minnow := addFish(world, "minnow", Vec2{X: 1000, Y: 1000}, InitialPlayerSize)
+
+	world.EatPlayer(shark, minnow)
+	world.UpdateLeader()
+
+	for _, want := range []GameEventType{EventPlayerAte, EventNewLeader} {
+		select {
+		case event := <-delivered:
+			if event.Type != want || event.PlayerID != shark.ID {
+				t.Fatalf("got %s event for %s, want %s for %s", event.Type, event.PlayerID, want, shark.ID)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no %s event", want)
+		}
+	}
+
+	// BroadcastEvent passed both on to the players, in the same order
+	for _, want := range []GameEventType{EventPlayerAte, EventNewLeader} {
+		select {
+		case out := <-shark.Client.Send:
+			msg, _, err := DecodeBinaryMessage(out.Data, ProtocolV2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event, ok := msg.Payload.(GameEvent); !ok || event.Type != want {
+				t.Fatalf("got %+v, want a %s event", msg, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("client got no %s event", want)
+		}
+	}
+}
+
+func TestUpdateLeaderOnlyOnChange(t *testing.T) {
+	world := NewWorld()
+	world.Events = NewEventBus() // Not running, so published events stay queued
+	nemo := addFish(world, "nemo", Vec2{}, InitialPlayerSize)
+	dory := addFish(world, "dory", Vec2{}, InitialPlayerSize)
+
+	steps := []struct {
+		name       string
+		nemo, dory int
+		leader     string // Expected new leader event, or "" for none
+	}{
+		{"no one has scored", 0, 0, ""},
+		{"first score", 10, 0, "nemo"},
+		{"leader scores again", 20, 5, ""},
+		{"tie keeps the leader", 20, 20, ""},
+		{"overtaken", 20, 30, "dory"},
+		{"same standings", 20, 30, ""},
+	}
+	for _, step := range steps {
+		nemo.Score, dory.Score = step.nemo, step.dory
+		world.UpdateLeader()
+
+		got := ""
+		select {
+		case event := <-world.Events.events:
+			if event.Type != EventNewLeader {
+				t.Fatalf("%s: unexpected %s event", step.name, event.Type)
+			}
+			got = event.PlayerID
+		default:
+		}
+		if got != step.leader {
+			t.Errorf("%s: new leader event for %q, want %q", step.name, got, step.leader)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Framed batches
+//
+// Clients that negotiate FramedSubprotocol receive every WebSocket frame as a batch:
+//
+//	[BatchMagic][record count uint8] then per record: [record kind][length uvarint][payload]
+//
+// so messages can be split without knowing their layouts. RecordBinary payloads
+// are binary messages (starting with a MsgType* byte); RecordJSON payloads are JSON
+// ServerMessages for types without a binary encoding.
+// Legacy clients get binary messages concatenated as before, and JSON fallbacks in
+// their own text frames, never glued onto binary data.
+const FramedSubprotocol = "fishy-framed-v1"
+
+// BatchMagic starts every framed batch (distinct from every MsgType* value and '{')
+const BatchMagic byte = 0xFB
+
+// Record kinds
+const (
+	RecordBinary byte = 0
+	RecordJSON   byte = 1
+)
+
+// MaxBatchRecords is the most records a batch header can describe
+const MaxBatchRecords = 255
+
+// Record is one message inside a framed batch
+type Record struct {
+	Kind    byte
+	Payload []byte
+}
+
+// EncodeBatch frames messages into a single batch
+func EncodeBatch(messages []OutgoingMessage) ([]byte, error) {
+	if len(messages) > MaxBatchRecords {
+		return nil, fmt.Errorf("batch of %d messages exceeds %d records", len(messages), MaxBatchRecords)
+	}
+
+	size := 2
+	for _, msg := range messages {
+		size += 1 + binary.MaxVarintLen32 + len(msg.Data)
+	}
+
+	buf := make([]byte, 0, size)
+	buf = append(buf, BatchMagic, byte(len(messages)))
+	for _, msg := range messages {
+		kind := RecordBinary
+		if msg.JSON {
+			kind = RecordJSON
+		}
+		buf = append(buf, kind)
+		buf = binary.AppendUvarint(buf, uint64(len(msg.Data)))
+		buf = append(buf, msg.Data...)
+	}
+
+	return buf, nil
+}
+
+// Batch decoding errors
+var (
+	ErrNotBatch       = errors.New("missing batch header")
+	ErrTruncatedBatch = errors.New("batch truncated")
+)
+
+// DecodeBatch splits a framed batch back into its records. The header count must
+// match the records present exactly and no bytes may follow the last record.
+func DecodeBatch(data []byte) ([]Record, error) {
+	if len(data) < 2 || data[0] != BatchMagic {
+		return nil, ErrNotBatch
+	}
+
+	count := int(data[1])
+	records := make([]Record, 0, count)
+	offset := 2
+
+	for i := 0; i < count; i++ {
+		if offset >= len(data) {
+			return nil, ErrTruncatedBatch
+		}
+		kind := data[offset]
+		offset++
+		if kind != RecordBinary && kind != RecordJSON {
+			return nil, fmt.Errorf("record %d has unknown kind %d", i, kind)
+		}
+
+		length, n := binary.Uvarint(data[offset:])
+		if n <= 0 {
+			return nil, fmt.Errorf("record %d has invalid length", i)
+		}
+		offset += n
+		if length > uint64(len(data)-offset) {
+			return nil, ErrTruncatedBatch
+		}
+
+		end := offset + int(length)
+		records = append(records, Record{Kind: kind, Payload: data[offset:end]})
+		offset = end
+	}
+
+	if offset != len(data) {
+		return nil, fmt.Errorf("batch has %d trailing bytes", len(data)-offset)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestBatchRoundTrip(t *testing.T) {
+	welcome, _ := EncodeBinaryMessage(ServerMessage{Type: "welcome", Payload: WelcomePayload{ID: "abc", Name: "Bob", Model: "shark", WorldWidth: WorldWidth, WorldHeight: WorldHeight}})
+	pong, _ := EncodeBinaryMessage(ServerMessage{Type: "pong"})
+	jsonMsg, _ := json.Marshal(ServerMessage{Type: "custom", Payload: map[string]int{"n": 1}})
+	big := bytes.Repeat([]byte{MsgTypeState}, 70000) // Needs a multi-byte length
+
+	messages := []OutgoingMessage{
+		{Data: welcome},
+		{Data: jsonMsg, JSON: true},
+		{Data: pong},
+		{Data: big},
+	}
+
+	data, err := EncodeBatch(messages)
+	if err != nil {
+		t.Fatalf("EncodeBatch: %v", err)
+	}
+
+	records, err := DecodeBatch(data)
+	if err != nil {
+		t.Fatalf("DecodeBatch: %v", err)
+	}
+	if len(records) != len(messages) {
+		t.Fatalf("got %d records, want %d", len(records), len(messages))
+	}
+	for i, msg := range messages {
+		wantKind := RecordBinary
+		if msg.JSON {
+			wantKind = RecordJSON
+		}
+		if records[i].Kind != wantKind {
+			t.Errorf("record %d kind = %d, want %d", i, records[i].Kind, wantKind)
+		}
+		if !bytes.Equal(records[i].Payload, msg.Data) {
+			t.Errorf("record %d payload mismatch", i)
+		}
+	}
+}
+
+func TestDecodeBatchRejectsMalformed(t *testing.T) {
+	valid, _ := EncodeBatch([]OutgoingMessage{{Data: []byte{MsgTypePong}}, {Data: []byte(`{"type":"x"}`), JSON: true}})
+
+	tests := map[string][]byte{
+		"empty":           {},
+		"no magic":        append([]byte{MsgTypePong}, valid[1:]...),
+		"truncated":       valid[:len(valid)-1],
+		"trailing bytes":  append(append([]byte{}, valid...), 0),
+		"count too high":  append([]byte{BatchMagic, 3}, valid[2:]...),
+		"unknown kind":    {BatchMagic, 1, 7, 1, MsgTypePong},
+		"length overflow": {BatchMagic, 1, RecordBinary, 0xFF, 0xFF, 0xFF, 0xFF, 0x0F},
+	}
+
+	for name, data := range tests {
+		if _, err := DecodeBatch(data); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestEncodeBatchTooManyRecords(t *testing.T) {
+	messages := make([]OutgoingMessage, MaxBatchRecords+1)
+	if _, err := EncodeBatch(messages); err == nil {
+		t.Fatal("expected error for oversized batch")
+	}
+}
+
+// writeBatchFrames writes a batch through a real WebSocket and returns the frames received
+func writeBatchFrames(t *testing.T, batch []OutgoingMessage, framed bool) []wsFrame {
+	t.Helper()
+
+	done := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		done <- writeBatch(conn, batch, framed)
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	if err := <-done; err != nil {
+		t.Fatalf("writeBatch: %v", err)
+	}
+
+	var frames []wsFrame
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return frames
+		}
+		frames = append(frames, wsFrame{messageType, data})
+	}
+}
+
+type wsFrame struct {
+	Type int
+	Data []byte
+}
+
+func TestWriteBatchLegacyKeepsJSONSeparate(t *testing.T) {
+	jsonMsg := []byte(`{"type":"custom"}`)
+	batch := []OutgoingMessage{
+		{Data: []byte{MsgTypePong}},
+		{Data: jsonMsg, JSON: true},
+		{Data: []byte{MsgTypePong}},
+	}
+
+	frames := writeBatchFrames(t, batch, false)
+	if len(frames) != 2 {
+		t.Fatalf("got %d frames, want 2", len(frames))
+	}
+	if frames[0].Type != websocket.TextMessage || !bytes.Equal(frames[0].Data, jsonMsg) {
+		t.Errorf("first frame should be the JSON text frame, got type %d %q", frames[0].Type, frames[0].Data)
+	}
+	if frames[1].Type != websocket.BinaryMessage || !bytes.Equal(frames[1].Data, []byte{MsgTypePong, MsgTypePong}) {
+		t.Errorf("second frame should hold both binary messages, got type %d %v", frames[1].Type, frames[1].Data)
+	}
+}
+
+func TestWriteBatchFramed(t *testing.T) {
+	batch := []OutgoingMessage{
+		{Data: []byte{MsgTypePong}},
+		{Data: []byte(`{"type":"custom"}`), JSON: true},
+	}
+
+	frames := writeBatchFrames(t, batch, true)
+	if len(frames) != 1 || frames[0].Type != websocket.BinaryMessage {
+		t.Fatalf("expected a single binary frame, got %d frames", len(frames))
+	}
+
+	records, err := DecodeBatch(frames[0].Data)
+	if err != nil {
+		t.Fatalf("DecodeBatch: %v", err)
+	}
+	if len(records) != 2 || records[0].Kind != RecordBinary || records[1].Kind != RecordJSON {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// ErrDuplicateID is returned when an ID is already in use
+var ErrDuplicateID = errors.New("ID already in use")
+
+// NewClientID returns a collision-free ID for a game or racing client. Player IDs
+// are the owning client's ID.
+func NewClientID() string {
+	return uuid.NewString()
+}
+
+// NewRaceID returns a collision-free ID for a race
+func NewRaceID() string {
+	return uuid.NewString()
+}
+
+// lobbyCodeAlphabet leaves out 0/O and 1/I/L so codes are easy to read out
+const lobbyCodeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+// NewLobbyCode returns a short random code for sharing a private lobby. Codes aren't
+// guaranteed unique; the caller checks for collisions.
+func NewLobbyCode() string {
+	b := make([]byte, LobbyCodeLength)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	for i := range b {
+		b[i] = lobbyCodeAlphabet[int(b[i])%len(lobbyCodeAlphabet)]
+	}
+	return string(b)
+}
+
+// HandleAllocator hands out short uint16 handles for string IDs, reusing the
+// handles of released IDs. Handle 0 is never assigned. Not safe for concurrent
+// use; the owner guards it.
+type HandleAllocator struct {
+	handles map[string]uint16
+	free    []uint16
+	next    uint16
+}
+
+// NewHandleAllocator creates an empty allocator
+func NewHandleAllocator() *HandleAllocator {
+	return &HandleAllocator{
+		handles: make(map[string]uint16),
+		next:    1,
+	}
+}
+
+// Get returns the handle for id, assigning one if needed. Returns 0 if all 65535
+// handles are in use.
+func (a *HandleAllocator) Get(id string) uint16 {
+	if handle, ok := a.handles[id]; ok {
+		return handle
+	}
+
+	var handle uint16
+	if n := len(a.free); n > 0 {
+		handle = a.free[n-1]
+		a.free = a.free[:n-1]
+	} else if a.next != 0 {
+		handle = a.next
+		a.next++ // Wraps to 0 once the last handle is taken
+	} else {
+		return 0
+	}
+
+	a.handles[id] = handle
+	return handle
+}
+
+// Release frees id's handle for reuse
+func (a *HandleAllocator) Release(id string) {
+	if handle, ok := a.handles[id]; ok {
+		delete(a.handles, id)
+		a.free = append(a.free, handle)
+	}
+}
+
+// Len returns the number of handles in use
+func (a *HandleAllocator) Len() int {
+	return len(a.handles)
+}
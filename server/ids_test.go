@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestNewClientIDUnique(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 10000; i++ {
+		id := NewClientID()
+		if seen[id] {
+			t.Fatalf("duplicate ID %s after %d IDs", id, i)
+		}
+		seen[id] = true
+	}
+}
+
+func TestHandleAllocator(t *testing.T) {
+	a := NewHandleAllocator()
+
+	first := a.Get("a")
+	if first == 0 {
+		t.Fatal("handle 0 must never be assigned")
+	}
+	if a.Get("a") != first {
+		t.Fatal("the same ID should keep its handle")
+	}
+	second := a.Get("b")
+	if second == first {
+		t.Fatal("different IDs must get different handles")
+	}
+
+	a.Release("a")
+	if reused := a.Get("c"); reused != first {
+		t.Errorf("released handle %d should be reused, got %d", first, reused)
+	}
+	if a.Len() != 2 {
+		t.Errorf("Len = %d, want 2", a.Len())
+	}
+}
+
+func TestHandleAllocatorExhaustion(t *testing.T) {
+	a := NewHandleAllocator()
+	ids := make([]string, 65535)
+	for i := range ids {
+		ids[i] = NewClientID()
+		if a.Get(ids[i]) == 0 {
+			t.Fatalf("ran out of handles after %d", i)
+		}
+	}
+
+	if a.Get("one too many") != 0 {
+		t.Fatal("expected 0 once every handle is in use")
+	}
+
+	a.Release(ids[100])
+	if a.Get("one too many") == 0 {
+		t.Fatal("a released handle should be available again")
+	}
+}
+
+func TestAddPlayerRejectsDuplicateID(t *testing.T) {
+	world := NewWorld()
+	id := NewClientID()
+
+	if err := world.AddPlayer(NewPlayer(id, "Alice", "shark", nil)); err != nil {
+		t.Fatalf("first AddPlayer: %v", err)
+	}
+	original := world.Players[id]
+
+	err := world.AddPlayer(NewPlayer(id, "Mallory", "blobfish", nil))
+	if !errors.Is(err, ErrDuplicateID) {
+		t.Fatalf("second AddPlayer: got %v, want ErrDuplicateID", err)
+	}
+	if world.Players[id] != original || len(world.Players) != 1 {
+		t.Fatal("the existing player must not be overwritten")
+	}
+}
+
+func TestFailedJoinKeepsSpectating(t *testing.T) {
+	world := NewWorld()
+	client := NewClient(NewClientID(), nil, world)
+	world.SetSpectatorView(client, "", Vec2{X: WorldWidth / 2, Y: WorldHeight / 2})
+
+	// Another fish already holds the client's ID
+	world.AddPlayer(NewPlayer(client.ID, "Alice", "shark", nil))
+	client.HandleJoin(ClientMessage{Type: "join", Name: "Bob", Model: "shark"})
+
+	if client.Player != nil || world.Spectators[client.ID] == nil {
+		t.Fatal("a failed join must leave the client spectating")
+	}
+
+	var reply struct {
+		Type    string           `json:"type"`
+		Payload JoinErrorPayload `json:"payload"`
+	}
+	for len(client.Send) > 0 {
+		if out := <-client.Send; out.JSON {
+			json.Unmarshal(out.Data, &reply)
+		}
+	}
+	if reply.Type != "joinError" || reply.Payload.Reason == "" {
+		t.Errorf("the client should be told why its join failed, got %+v", reply)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Environment variables backing the listener flags. Flags take precedence.
+const (
+	ListenAddrEnv     = "LISTEN_ADDR"     // e.g. ":8080"
+	AllowedOriginsEnv = "ALLOWED_ORIGINS" // comma-separated, e.g. "https://fishy.example.com"
+	TLSCertFileEnv    = "TLS_CERT_FILE"
+	TLSKeyFileEnv     = "TLS_KEY_FILE"
+	AutocertDirEnv    = "AUTOCERT_DIR"    // Let's Encrypt certificate cache directory
+	AutocertHostsEnv  = "AUTOCERT_HOSTS"  // comma-separated hostnames to request certificates for
+	TrustedProxiesEnv = "TRUSTED_PROXIES" // comma-separated proxy IPs or CIDRs, e.g. "172.16.0.0/12"
+)
+
+// DefaultListenAddr is used when neither -addr nor LISTEN_ADDR is set
+const DefaultListenAddr = ":8080"
+
+// ServerConfig holds the listener settings
+type ServerConfig struct {
+	Addr           string
+	AllowedOrigins []string
+	TLSCertFile    string
+	TLSKeyFile     string
+	AutocertDir    string
+	AutocertHosts  []string
+	TrustedProxies []string
+}
+
+// LoadServerConfig reads the listener settings from args, falling back to the environment
+func LoadServerConfig(args []string) (ServerConfig, error) {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	addr := fs.String("addr", envOr(ListenAddrEnv, DefaultListenAddr), "listen address")
+	origins := fs.String("origins", os.Getenv(AllowedOriginsEnv), "comma-separated allowed WebSocket origins (* allows any)")
+	certFile := fs.String("tls-cert", os.Getenv(TLSCertFileEnv), "TLS certificate file")
+	keyFile := fs.String("tls-key", os.Getenv(TLSKeyFileEnv), "TLS private key file")
+	autocertDir := fs.String("autocert-dir", os.Getenv(AutocertDirEnv), "cache directory for Let's Encrypt certificates")
+	autocertHosts := fs.String("autocert-hosts", os.Getenv(AutocertHostsEnv), "comma-separated hostnames for Let's Encrypt")
+	proxies := fs.String("trusted-proxies", os.Getenv(TrustedProxiesEnv), "comma-separated proxy IPs or CIDRs whose Fly-Client-IP header is believed")
+	if err := fs.Parse(args); err != nil {
+		return ServerConfig{}, err
+	}
+
+	cfg := ServerConfig{
+		Addr:           *addr,
+		AllowedOrigins: splitList(*origins),
+		TLSCertFile:    *certFile,
+		TLSKeyFile:     *keyFile,
+		AutocertDir:    *autocertDir,
+		AutocertHosts:  splitList(*autocertHosts),
+		TrustedProxies: splitList(*proxies),
+	}
+
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return cfg, errors.New("-tls-cert and -tls-key must be set together")
+	}
+	if cfg.TLSCertFile != "" && cfg.AutocertDir != "" {
+		return cfg, errors.New("use either -tls-cert/-tls-key or -autocert-dir, not both")
+	}
+	if cfg.AutocertDir != "" && len(cfg.AutocertHosts) == 0 {
+		return cfg, errors.New("-autocert-dir needs -autocert-hosts")
+	}
+	if _, err := NewProxyPolicy(cfg.TrustedProxies); err != nil {
+		return cfg, err
+	}
+	if os.Getenv("FLY_APP_NAME") != "" && len(cfg.TrustedProxies) == 0 {
+		log.Printf("WARNING: running on Fly.io without %s - every player will share the proxy's IP for bans and connection limits", TrustedProxiesEnv)
+	}
+	return cfg, nil
+}
+
+// TLSEnabled reports whether the server terminates TLS itself
+func (c ServerConfig) TLSEnabled() bool {
+	return c.TLSCertFile != "" || c.AutocertDir != ""
+}
+
+// ListenAndServe serves plain HTTP or TLS, depending on the config. Certificates from
+// autocert are obtained with the TLS-ALPN challenge, so the server must be reachable
+// on port 443.
+func (c ServerConfig) ListenAndServe(server *http.Server) error {
+	switch {
+	case c.TLSCertFile != "":
+		return server.ListenAndServeTLS(c.TLSCertFile, c.TLSKeyFile)
+	case c.AutocertDir != "":
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(c.AutocertDir),
+			HostPolicy: autocert.HostWhitelist(c.AutocertHosts...),
+		}
+		server.TLSConfig = manager.TLSConfig()
+		server.TLSConfig.MinVersion = tls.VersionTLS12
+		return server.ListenAndServeTLS("", "")
+	default:
+		return server.ListenAndServe()
+	}
+}
+
+// OriginPolicy decides which browser origins may open WebSockets
+type OriginPolicy struct {
+	allowAny bool
+	allowed  map[string]bool
+}
+
+// NewOriginPolicy allows the given origins ("scheme://host[:port]"). "*" allows any
+// origin. With no origins only same-host and localhost pages may connect.
+func NewOriginPolicy(origins []string) *OriginPolicy {
+	p := &OriginPolicy{allowed: make(map[string]bool)}
+	for _, origin := range origins {
+		if origin == "*" {
+			p.allowAny = true
+			continue
+		}
+		p.allowed[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+	return p
+}
+
+// Check implements websocket.Upgrader.CheckOrigin, logging why a request was refused
+func (p *OriginPolicy) Check(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || p.allowAny {
+		return true // Non-browser clients don't send an Origin
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		log.Printf("Refusing WebSocket upgrade on %s from %s: malformed origin %q", r.URL.Path, ClientIP(r), origin)
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) || p.allowed[strings.ToLower(origin)] {
+		return true
+	}
+	if len(p.allowed) == 0 && (u.Hostname() == "localhost" || u.Hostname() == "127.0.0.1") {
+		return true // Local development with no list configured
+	}
+
+	log.Printf("Refusing WebSocket upgrade on %s from %s: origin %q is not in %s", r.URL.Path, ClientIP(r), origin, AllowedOriginsEnv)
+	return false
+}
+
+// allowedOrigins is consulted by the upgrader; main replaces it with the configured list
+var allowedOrigins = NewOriginPolicy(nil)
+
+// ProxyPolicy decides whose Fly-Client-IP header ClientIP believes. Anyone can send
+// the header, so it only counts on connections from the proxy in front of us.
+type ProxyPolicy struct {
+	nets []*net.IPNet
+}
+
+// NewProxyPolicy trusts the given proxy IPs and CIDRs. With none, the header is ignored.
+func NewProxyPolicy(proxies []string) (*ProxyPolicy, error) {
+	p := &ProxyPolicy{}
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("-trusted-proxies: %q is not an IP or CIDR", proxy)
+			}
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			p.nets = append(p.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("-trusted-proxies: %q is not an IP or CIDR", proxy)
+		}
+		p.nets = append(p.nets, ipNet)
+	}
+	return p, nil
+}
+
+// Trusts reports whether ip is one of the trusted proxies
+func (p *ProxyPolicy) Trusts(ip net.IP) bool {
+	for _, ipNet := range p.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedProxies is consulted by ClientIP; main replaces it with the configured list
+var trustedProxies, _ = NewProxyPolicy(nil)
+
+// envOr returns the environment variable key, or fallback if it's unset
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// splitList splits a comma-separated list, dropping blanks
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginPolicy(t *testing.T) {
+	policy := NewOriginPolicy([]string{"https://fishy.example.com/", "https://Other.Example.com"})
+
+	cases := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true}, // Non-browser client
+		{"https://fishy.example.com", true},
+		{"https://other.example.com", true},
+		{"http://game.test:8080", true}, // Same host as the request
+		{"https://evil.example.com", false},
+		{"http://fishy.example.com", false}, // Scheme matters
+		{"http://localhost:3000", false},    // Only allowed when no list is configured
+		{"not a url", false},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "http://game.test:8080/ws", nil)
+		if c.origin != "" {
+			r.Header.Set("Origin", c.origin)
+		}
+		if got := policy.Check(r); got != c.want {
+			t.Errorf("origin %q: got %v, want %v", c.origin, got, c.want)
+		}
+	}
+}
+
+func TestOriginPolicyDefaults(t *testing.T) {
+	check := func(policy *OriginPolicy, origin string) bool {
+		r := httptest.NewRequest("GET", "http://game.test/ws", nil)
+		r.Header.Set("Origin", origin)
+		return policy.Check(r)
+	}
+
+	unset := NewOriginPolicy(nil)
+	if !check(unset, "http://localhost:3000") || !check(unset, "http://127.0.0.1:5173") {
+		t.Error("localhost pages should connect when no list is configured")
+	}
+	if check(unset, "https://evil.example.com") {
+		t.Error("other origins must be refused when no list is configured")
+	}
+
+	if !check(NewOriginPolicy([]string{"*"}), "https://anywhere.example.com") {
+		t.Error("* should allow any origin")
+	}
+}
+
+func TestLoadServerConfig(t *testing.T) {
+	t.Setenv(ListenAddrEnv, ":9000")
+	t.Setenv(AllowedOriginsEnv, "https://a.example.com, https://b.example.com")
+
+	cfg, err := LoadServerConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":9000" || len(cfg.AllowedOrigins) != 2 || cfg.TLSEnabled() {
+		t.Errorf("config from env: %+v", cfg)
+	}
+
+	cfg, err = LoadServerConfig([]string{"-addr", ":443", "-autocert-dir", "/tmp/certs", "-autocert-hosts", "fishy.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":443" || !cfg.TLSEnabled() {
+		t.Errorf("flags should override env: %+v", cfg)
+	}
+
+	invalid := [][]string{
+		{"-tls-cert", "cert.pem"},
+		{"-tls-cert", "cert.pem", "-tls-key", "key.pem", "-autocert-dir", "/tmp/certs", "-autocert-hosts", "a"},
+		{"-autocert-dir", "/tmp/certs"},
+		{"-trusted-proxies", "proxy.internal"},
+	}
+	for _, args := range invalid {
+		if _, err := LoadServerConfig(args); err == nil {
+			t.Errorf("%v should be rejected", args)
+		}
+	}
+}
+
+func TestClientIPIgnoresSpoofedHeader(t *testing.T) {
+	request := func(remoteAddr, header string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("Fly-Client-IP", header)
+		return r
+	}
+
+	defer func(saved *ProxyPolicy) { trustedProxies = saved }(trustedProxies)
+	trustedProxies, _ = NewProxyPolicy(nil)
+	if ip := ClientIP(request("203.0.113.7:5000", "198.51.100.1")); ip != "203.0.113.7" {
+		t.Errorf("with no trusted proxies the header must be ignored, got %s", ip)
+	}
+
+	var err error
+	trustedProxies, err = NewProxyPolicy([]string{"172.16.0.0/12", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip := ClientIP(request("203.0.113.7:5000", "198.51.100.1")); ip != "203.0.113.7" {
+		t.Errorf("a direct client must not choose its own address, got %s", ip)
+	}
+	if ip := ClientIP(request("172.19.0.2:5000", "198.51.100.1")); ip != "198.51.100.1" {
+		t.Errorf("the proxy's header should be believed, got %s", ip)
+	}
+	if ip := ClientIP(request("[::1]:5000", "2001:db8::1")); ip != "2001:db8::1" {
+		t.Errorf("a single trusted proxy IP should work too, got %s", ip)
+	}
+	if ip := ClientIP(request("172.19.0.2:5000", "not-an-ip")); ip != "172.19.0.2" {
+		t.Errorf("a malformed header should fall back to the proxy address, got %s", ip)
+	}
+}
@@ -1,37 +1,87 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
+	cfg, err := LoadServerConfig(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	allowedOrigins = NewOriginPolicy(cfg.AllowedOrigins)
+	if len(cfg.AllowedOrigins) == 0 {
+		log.Printf("%s not set - only same-host and localhost pages may connect", AllowedOriginsEnv)
+	}
+	trustedProxies, _ = NewProxyPolicy(cfg.TrustedProxies) // Validated by LoadServerConfig
+	if len(cfg.TrustedProxies) == 0 {
+		log.Printf("%s not set - Fly-Client-IP is ignored and clients are identified by their connection address", TrustedProxiesEnv)
+	}
+
+	// Optional custom name blocklist
+	if path := os.Getenv(NameBlocklistEnv); path != "" {
+		if err := nameFilter.LoadFile(path); err != nil {
+			log.Fatalf("Failed to load name blocklist %s: %v", path, err)
+		}
+		log.Printf("Loaded name blocklist from %s", path)
+	}
+
+	// Cancelled on SIGTERM/SIGINT to begin draining
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	// Game loops stop when loopCtx is cancelled, at the end of draining
+	loopCtx, stopLoops := context.WithCancel(context.Background())
+	defer stopLoops()
+
 	// Create the game world
 	world := NewWorld()
 
 	// Start the game loop
-	world.Start()
+	world.Start(loopCtx)
 
 	// Create the racing world
 	racingWorld := NewRacingWorld()
+	racingWorld.Start(loopCtx)
 
 	// Setup HTTP routes
 	http.HandleFunc("/ws", HandleWebSocket(world))        // Primary: position updates
 	http.HandleFunc("/ws/meta", HandleMetaWebSocket(world)) // Secondary: metadata
 	http.HandleFunc("/ws/racing", HandleRacingWebSocket(racingWorld)) // Racing game
+	http.HandleFunc("/admin/", HandleAdmin(world, racingWorld))        // Moderation API
+	http.HandleFunc("/racing/results/", HandleRaceResults(racingWorld)) // Past race results
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("Fishy Business Server Running"))
 	})
 
 	// Start the server
-	port := ":8080"
-	log.Printf("Starting server on %s", port)
+	scheme := "ws"
+	if cfg.TLSEnabled() {
+		scheme = "wss"
+	}
+	log.Printf("Starting server on %s", cfg.Addr)
 	log.Printf("WebSocket endpoints:")
-	log.Printf("  - Primary:  ws://localhost%s/ws", port)
-	log.Printf("  - Metadata: ws://localhost%s/ws/meta", port)
-	log.Printf("  - Racing:   ws://localhost%s/ws/racing", port)
+	log.Printf("  - Primary:  %s://localhost%s/ws", scheme, cfg.Addr)
+	log.Printf("  - Metadata: %s://localhost%s/ws/meta", scheme, cfg.Addr)
+	log.Printf("  - Racing:   %s://localhost%s/ws/racing", scheme, cfg.Addr)
+
+	server := &http.Server{Addr: cfg.Addr}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- cfg.ListenAndServe(server)
+	}()
 
-	if err := http.ListenAndServe(port, nil); err != nil {
+	select {
+	case err := <-serverErr:
 		log.Fatal("Server error:", err)
+	case <-ctx.Done():
+		stop() // A second signal kills the process immediately
+		log.Printf("Shutdown signal received, draining")
+		GracefulShutdown(server, world, racingWorld, stopLoops)
 	}
 }
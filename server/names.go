@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// NameBlocklistEnv points at a file of blocked words (one per line, # comments)
+// that replaces the built-in list. A word prefixed with * is blocked anywhere in a
+// name, even inside other words; the rest only match a whole word.
+const NameBlocklistEnv = "NAME_BLOCKLIST_FILE"
+
+// ErrNameNotAllowed is returned for names that match the blocklist.
+// The message is shown to the player.
+var ErrNameNotAllowed = errors.New("That name isn't allowed, please pick another one")
+
+// DefaultBlockedWords is used when no blocklist file is configured. Most are whole
+// words only, so "Grape" and "Scunthorpe" are fine.
+var DefaultBlockedWords = []string{
+	"*fuck", "*hitler", "shit", "cunt", "bitch", "whore", "nazi", "rape",
+}
+
+// NameRejectedPayload tells the client why its name was refused
+type NameRejectedPayload struct {
+	Reason string `json:"reason"`
+}
+
+// NameFilter holds the blocked word list
+type NameFilter struct {
+	words    map[string]bool // Matched against whole words; stored as skeletons
+	anywhere []string        // Matched anywhere in the name's skeleton
+	mu       sync.RWMutex
+}
+
+// nameFilter is shared by the ocean and racing join handlers
+var nameFilter = NewNameFilter(DefaultBlockedWords)
+
+// NewNameFilter creates a filter blocking the given words
+func NewNameFilter(words []string) *NameFilter {
+	f := &NameFilter{}
+	f.SetWords(words)
+	return f
+}
+
+// SetWords replaces the blocked word list
+func (f *NameFilter) SetWords(words []string) {
+	whole := make(map[string]bool)
+	var anywhere []string
+	for _, word := range words {
+		substring := strings.HasPrefix(word, "*")
+		skeleton := NameSkeleton(strings.TrimPrefix(word, "*"))
+		switch {
+		case skeleton == "":
+		case substring:
+			anywhere = append(anywhere, skeleton)
+		default:
+			whole[skeleton] = true
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.words = whole
+	f.anywhere = anywhere
+}
+
+// LoadFile replaces the blocked word list with the words in path
+func (f *NameFilter) LoadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	f.SetWords(words)
+	return nil
+}
+
+// Allowed reports whether a name contains none of the blocked words
+func (f *NameFilter) Allowed(name string) bool {
+	words := nameWords(name)
+	skeleton := strings.Join(words, "")
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for _, word := range words {
+		if f.words[word] {
+			return false
+		}
+	}
+	for _, word := range f.anywhere {
+		if strings.Contains(skeleton, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// SanitizeName cleans up a player-supplied name: invalid UTF-8, control and
+// zero-width characters are removed, fullwidth letters are folded to ASCII,
+// whitespace is collapsed and the result is truncated to MaxPlayerNameLen runes.
+// Empty and muted names become DefaultPlayerName; blocked names are rejected.
+func SanitizeName(raw string) (string, error) {
+	raw = strings.ToValidUTF8(raw, "")
+
+	var b strings.Builder
+	pendingSpace := false
+	for _, r := range raw {
+		switch {
+		case unicode.IsSpace(r):
+			pendingSpace = b.Len() > 0
+			continue
+		case unicode.IsControl(r), unicode.Is(unicode.Cf, r), !unicode.IsPrint(r):
+			// Control, zero-width and other invisible characters
+			continue
+		}
+
+		if pendingSpace {
+			b.WriteRune(' ')
+			pendingSpace = false
+		}
+		b.WriteRune(foldWidth(r))
+	}
+
+	name := truncateRunes(b.String(), MaxPlayerNameLen)
+	name = strings.TrimSpace(name)
+
+	if name == "" || moderation.IsMuted(name) {
+		return DefaultPlayerName, nil
+	}
+	if !nameFilter.Allowed(name) {
+		return "", ErrNameNotAllowed
+	}
+
+	return name, nil
+}
+
+// UniqueName appends a number to name until taken reports false for it.
+// taken is given the candidate's skeleton so lookalike names count as duplicates.
+func UniqueName(name string, taken func(skeleton string) bool) string {
+	if !taken(NameSkeleton(name)) {
+		return name
+	}
+
+	for i := 2; ; i++ {
+		suffix := fmt.Sprintf(" %d", i)
+		base := truncateRunes(name, MaxPlayerNameLen-utf8.RuneCountInString(suffix))
+		candidate := strings.TrimSpace(base) + suffix
+		if !taken(NameSkeleton(candidate)) {
+			return candidate
+		}
+	}
+}
+
+// NameSkeleton reduces a name to a lower-case form with lookalike characters and
+// common letter substitutions folded together, for blocklist and duplicate checks
+func NameSkeleton(name string) string {
+	return strings.Join(nameWords(name), "")
+}
+
+// nameWords splits a name into its words, folded as in NameSkeleton. Anything other
+// than a letter or digit once folded separates words, so "$h1t" is one word.
+func nameWords(name string) []string {
+	var words []string
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		r = foldWidth(r)
+		if folded, ok := confusables[r]; ok {
+			r = folded
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		} else if b.Len() > 0 {
+			words = append(words, b.String())
+			b.Reset()
+		}
+	}
+	if b.Len() > 0 {
+		words = append(words, b.String())
+	}
+	return words
+}
+
+// truncateRunes cuts s to at most n runes without splitting a character
+func truncateRunes(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	count := 0
+	for i := range s {
+		if count == n {
+			return s[:i]
+		}
+		count++
+	}
+	return s
+}
+
+// foldWidth maps fullwidth ASCII variants (U+FF01-U+FF5E) to plain ASCII
+func foldWidth(r rune) rune {
+	if r >= 0xFF01 && r <= 0xFF5E {
+		return r - 0xFEE0
+	}
+	return r
+}
+
+// confusables maps lookalike characters and leetspeak to the latin letter they imitate
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o',
+	'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'і': 'i', 'ј': 'j', 'ѕ': 's',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p',
+	'τ': 't', 'υ': 'u', 'χ': 'x',
+	// Leetspeak
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '@': 'a', '$': 's', '!': 'i',
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestNameSkeletonFolds(t *testing.T) {
+	cases := map[string]string{
+		"Bob":     "bob",
+		"Ｂｏｂ":     "bob", // Fullwidth
+		"Вов":     "bob", // Cyrillic
+		"B0b":     "bob", // Leetspeak
+		"b.o b!":  "bobi",
+		"Fish 12": "fishi2",
+	}
+	for name, want := range cases {
+		if got := NameSkeleton(name); got != want {
+			t.Errorf("NameSkeleton(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	cases := map[string]string{
+		"  Nemo   the\tFish ": "Nemo the Fish",
+		"Do\u200bry":          "Dory", // Zero-width space
+		"Ｄｏｒｙ":                "Dory",
+		"\x00\x07":            DefaultPlayerName,
+		"bad\xffutf8":         "badutf8",
+		"":                    DefaultPlayerName,
+	}
+	for raw, want := range cases {
+		if got, err := SanitizeName(raw); err != nil || got != want {
+			t.Errorf("SanitizeName(%q) = %q, %v; want %q", raw, got, err, want)
+		}
+	}
+
+	long, _ := SanitizeName(strings.Repeat("é", 30))
+	if utf8.RuneCountInString(long) != MaxPlayerNameLen || !utf8.ValidString(long) {
+		t.Errorf("long names should be cut to %d whole runes, got %q", MaxPlayerNameLen, long)
+	}
+}
+
+func TestNameFilter(t *testing.T) {
+	filter := NewNameFilter(DefaultBlockedWords)
+
+	blocked := []string{"shit", "Big Shit", "$h1t", "ѕhit", "nazi-fish", "fuckfish", "f u c k", "xXhitlerXx"}
+	for _, name := range blocked {
+		if filter.Allowed(name) {
+			t.Errorf("%q should be blocked", name)
+		}
+	}
+
+	// Blocked words inside ordinary words aren't matched
+	allowed := []string{"Grape", "Bass Hitter", "Scunthorpe", "Therapist", "Cocktail", "Bob"}
+	for _, name := range allowed {
+		if !filter.Allowed(name) {
+			t.Errorf("%q should be allowed", name)
+		}
+	}
+}
+
+func TestNameFilterLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	os.WriteFile(path, []byte("# Custom list\n\nsquid\n*kraken\n"), 0o644)
+
+	filter := NewNameFilter(nil)
+	if err := filter.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if filter.Allowed("Giant Squid") || !filter.Allowed("Squidward") {
+		t.Error("squid should only match as a whole word")
+	}
+	if filter.Allowed("Krakenfish") {
+		t.Error("*kraken should match inside other words")
+	}
+	if !filter.Allowed("shit") {
+		t.Error("the file should replace the built-in list")
+	}
+}
+
+func TestUniqueName(t *testing.T) {
+	taken := map[string]bool{}
+	isTaken := func(skeleton string) bool { return taken[skeleton] }
+
+	for _, want := range []string{"Bob", "Bob 2", "Bob 3"} {
+		got := UniqueName("Bob", isTaken)
+		if got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+		taken[NameSkeleton(got)] = true
+	}
+
+	// Lookalikes count as duplicates
+	if got := UniqueName("B0B", isTaken); got != "B0B 4" {
+		t.Errorf("lookalike name: got %q, want %q", got, "B0B 4")
+	}
+
+	// The suffix still fits within the length limit
+	long := strings.Repeat("a", MaxPlayerNameLen)
+	taken[NameSkeleton(long)] = true
+	if got := UniqueName(long, isTaken); got != strings.Repeat("a", MaxPlayerNameLen-2)+" 2" {
+		t.Errorf("long name: got %q", got)
+	}
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"sync"
@@ -14,21 +18,42 @@ var upgrader = websocket.Upgrader{
 	ReadBufferSize:    2048,
 	WriteBufferSize:   8192, // Larger for batching
 	EnableCompression: true, // Enable compression like slither.io
+	Subprotocols:      []string{FramedSubprotocol, BinaryInputSubprotocol}, // In order of preference
 	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins for development
+		return allowedOrigins.Check(r) // See listen.go
 	},
 }
 
+// OutgoingMessage is an encoded message queued for a client
+type OutgoingMessage struct {
+	Data []byte
+	JSON bool // Data is a JSON fallback rather than a binary message
+}
+
 // Client represents a connected WebSocket client
 type Client struct {
 	ID          string
+	IP          string
 	Conn        *websocket.Conn // Primary: high-freq position updates
 	MetaConn    *websocket.Conn // Secondary: low-freq metadata
-	Send        chan []byte
-	MetaSend    chan []byte
+	Send        chan OutgoingMessage
+	MetaSend    chan OutgoingMessage
 	World       *World
 	Player      *Player
+	// Negotiated via hello or WebSocket subprotocols (guarded by mu)
+	ProtocolVersion uint16
+	Capabilities    uint32
+	HelloDone       bool
+	MetaFramed      bool // Metadata socket negotiated FramedSubprotocol
+	// Metadata socket pairing (guarded by mu)
+	MetaToken string        // One-time secret the meta socket must present, empty once used
+	metaDone  chan struct{} // Closed when the current meta socket goes away
+	closed    bool          // Send and MetaSend have been closed
 	SeenPlayers map[string]bool // Track which players this client has seen
+	PlayerHandles *HandleAllocator // Compact state handles sent in playerInfo (guarded by mu)
+	// Spectator viewpoint (guarded by World.mu)
+	SpectateTarget string // Player being followed, empty when free-roaming
+	Camera         Vec2   // Free-roam camera position
 	mu          sync.Mutex
 }
 
@@ -37,11 +62,126 @@ func NewClient(id string, conn *websocket.Conn, world *World) *Client {
 	return &Client{
 		ID:          id,
 		Conn:        conn,
-		Send:        make(chan []byte, WriteChannelSize),
-		MetaSend:    make(chan []byte, WriteChannelSize),
+		Send:        make(chan OutgoingMessage, WriteChannelSize),
+		MetaSend:    make(chan OutgoingMessage, WriteChannelSize),
 		World:       world,
 		SeenPlayers: make(map[string]bool),
+		PlayerHandles: NewHandleAllocator(),
+		// Until a hello arrives the client is assumed to be a legacy one
+		ProtocolVersion: ProtocolV1,
+		Capabilities:    LegacyCapabilities,
+	}
+}
+
+// Has reports whether a capability has been negotiated
+func (c *Client) Has(capability uint32) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Capabilities&capability != 0
+}
+
+// Protocol returns the negotiated protocol version
+func (c *Client) Protocol() uint16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ProtocolVersion
+}
+
+// ForgetPlayer drops everything the client knows about a player who left, so its
+// handle can be reused
+func (c *Client) ForgetPlayer(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.SeenPlayers, id)
+	c.PlayerHandles.Release(id)
+}
+
+// IssueMetaToken creates a fresh one-time pairing secret for the metadata socket
+func (c *Client) IssueMetaToken() string {
+	token := generateToken()
+	c.mu.Lock()
+	c.MetaToken = token
+	c.mu.Unlock()
+	return token
+}
+
+// ClaimMetaToken consumes the pairing secret. It fails if the token doesn't match,
+// a metadata socket is already attached or the client didn't negotiate one.
+func (c *Client) ClaimMetaToken(token string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || c.MetaConn != nil || c.MetaToken == "" || c.Capabilities&CapMetaSocket == 0 {
+		return false
 	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(c.MetaToken)) != 1 {
+		return false
+	}
+	c.MetaToken = ""
+	return true
+}
+
+// AttachMeta makes conn the client's metadata socket and starts its pumps
+func (c *Client) AttachMeta(conn *websocket.Conn) {
+	done := make(chan struct{})
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		conn.Close()
+		return
+	}
+	c.MetaConn = conn
+	c.MetaFramed = conn.Subprotocol() == FramedSubprotocol
+	c.metaDone = done
+	c.mu.Unlock()
+
+	go c.MetaWritePump(conn, done)
+	go c.MetaReadPump(conn)
+}
+
+// detachMeta drops a metadata socket that has gone away. Metadata falls back to the
+// primary socket, and a new pairing token is sent so the client can reconnect.
+func (c *Client) detachMeta(conn *websocket.Conn) {
+	c.mu.Lock()
+	if c.MetaConn != conn {
+		c.mu.Unlock()
+		return
+	}
+	c.MetaConn = nil
+	close(c.metaDone)
+	closed := c.closed
+	c.mu.Unlock()
+
+	conn.Close()
+	if !closed {
+		c.SendMessage(ServerMessage{
+			Type:    "metaToken",
+			Payload: MetaTokenPayload{Token: c.IssueMetaToken()},
+		})
+	}
+}
+
+// HasMeta reports whether a metadata socket is attached
+func (c *Client) HasMeta() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.MetaConn != nil
+}
+
+// Close closes the client's send queues, stopping both write pumps.
+// Safe to call more than once; later messages are dropped.
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.Send)
+	close(c.MetaSend)
 }
 
 // ReadPump reads messages from the WebSocket connection
@@ -49,8 +189,11 @@ func (c *Client) ReadPump() {
 	defer func() {
 		c.World.Disconnect(c)
 		c.Conn.Close()
+		gameConnLimit.Release(c.IP)
 	}()
 
+	limiter := NewMessageLimiter(GameRateLimits)
+	c.Conn.SetReadLimit(MaxMessageSize)
 	c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.Conn.SetPongHandler(func(string) error {
 		c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
@@ -58,7 +201,7 @@ func (c *Client) ReadPump() {
 	})
 
 	for {
-		_, message, err := c.Conn.ReadMessage()
+		messageType, message, err := c.Conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error: %v", err)
@@ -67,11 +210,31 @@ func (c *Client) ReadPump() {
 		}
 
 		var msg ClientMessage
-		if err := json.Unmarshal(message, &msg); err != nil {
+		if messageType == websocket.BinaryMessage {
+			if !c.Has(CapBinaryInput) {
+				log.Printf("Client %s sent binary message without negotiating binary input", c.ID)
+				continue
+			}
+			msg, err = DecodeBinaryClientMessage(message)
+			if err != nil {
+				log.Printf("Error decoding binary message from %s: %v", c.ID, err)
+				continue
+			}
+		} else if err := json.Unmarshal(message, &msg); err != nil {
+			// JSON fallback for clients without binary input
 			log.Printf("Error unmarshaling message: %v", err)
 			continue
 		}
 
+		if allowed, abusive := limiter.Allow(msg.Type, time.Now()); !allowed {
+			if abusive {
+				log.Printf("Client %s (%s) exceeded rate limits, disconnecting", c.ID, c.IP)
+				closeRateLimited(c.Conn)
+				break
+			}
+			continue
+		}
+
 		c.HandleMessage(msg)
 	}
 }
@@ -94,21 +257,9 @@ func (c *Client) WritePump() {
 			}
 
 			// Batch multiple messages together (like slither.io)
-			batched := message
-			
-			// Drain additional queued messages and append them
-			batchLoop:
-			for i := 0; i < 10; i++ { // Max 10 messages per batch
-				select {
-				case nextMsg := <-c.Send:
-					batched = append(batched, nextMsg...)
-				default:
-					break batchLoop
-				}
-			}
-
-			// Send batched binary message
-			if err := c.Conn.WriteMessage(websocket.BinaryMessage, batched); err != nil {
+			batch := drainBatch(message, c.Send, 10) // Max 10 extra messages per batch
+			c.Conn.EnableWriteCompression(c.Has(CapCompression))
+			if err := writeBatch(c.Conn, batch, c.Has(CapFramed)); err != nil {
 				return
 			}
 
@@ -121,53 +272,126 @@ func (c *Client) WritePump() {
 	}
 }
 
-// MetaWritePump sends metadata messages on the secondary WebSocket
-func (c *Client) MetaWritePump() {
-	defer func() {
-		if c.MetaConn != nil {
-			c.MetaConn.Close()
+// MetaReadPump watches the metadata socket so a closed or dead socket is noticed.
+// Clients don't send anything on it; whatever arrives is discarded.
+func (c *Client) MetaReadPump(conn *websocket.Conn) {
+	defer c.detachMeta(conn)
+
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		return nil
+	})
+
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("Meta WebSocket error for %s: %v", c.ID, err)
+			}
+			return
 		}
+	}
+}
+
+// MetaWritePump sends metadata messages on the secondary WebSocket until it is
+// detached (done) or the client disconnects (MetaSend closed)
+func (c *Client) MetaWritePump(conn *websocket.Conn, done chan struct{}) {
+	ticker := time.NewTicker(time.Duration(PingInterval) * time.Millisecond)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
 	}()
 
 	for {
 		select {
+		case <-done:
+			return
+
 		case message, ok := <-c.MetaSend:
-			if c.MetaConn == nil {
-				return
-			}
-			c.MetaConn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if !ok {
-				c.MetaConn.WriteMessage(websocket.CloseMessage, []byte{})
+				conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
 
 			// Batch metadata messages
-			batched := message
-			batchLoop:
-			for i := 0; i < 5; i++ { // Smaller batch for metadata
-				select {
-				case nextMsg := <-c.MetaSend:
-					batched = append(batched, nextMsg...)
-				default:
-					break batchLoop
-				}
+			batch := drainBatch(message, c.MetaSend, 5) // Smaller batch for metadata
+			c.mu.Lock()
+			framed := c.MetaFramed || c.Capabilities&CapFramed != 0
+			compress := c.Capabilities&CapCompression != 0
+			c.mu.Unlock()
+			conn.EnableWriteCompression(compress)
+			if err := writeBatch(conn, batch, framed); err != nil {
+				return
 			}
 
-			// Send batched message
-			if err := c.MetaConn.WriteMessage(websocket.BinaryMessage, batched); err != nil {
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
 		}
 	}
 }
 
+// drainBatch collects first plus up to max further messages already queued
+func drainBatch(first OutgoingMessage, queue chan OutgoingMessage, max int) []OutgoingMessage {
+	batch := []OutgoingMessage{first}
+	for i := 0; i < max; i++ {
+		select {
+		case next, ok := <-queue:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, next)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// writeBatch writes a batch of messages. Framed clients get a single framed batch;
+// legacy clients get binary messages concatenated into one frame and each JSON
+// fallback in its own text frame.
+func writeBatch(conn *websocket.Conn, batch []OutgoingMessage, framed bool) error {
+	if framed {
+		data, err := EncodeBatch(batch)
+		if err != nil {
+			return err
+		}
+		return conn.WriteMessage(websocket.BinaryMessage, data)
+	}
+
+	var binaryData []byte
+	for _, msg := range batch {
+		if msg.JSON {
+			if err := conn.WriteMessage(websocket.TextMessage, msg.Data); err != nil {
+				return err
+			}
+			continue
+		}
+		binaryData = append(binaryData, msg.Data...)
+	}
+
+	if len(binaryData) == 0 {
+		return nil
+	}
+	return conn.WriteMessage(websocket.BinaryMessage, binaryData)
+}
+
 // HandleMessage processes incoming client messages
 func (c *Client) HandleMessage(msg ClientMessage) {
 	switch msg.Type {
+	case "hello":
+		c.HandleHello(msg)
 	case "join":
 		c.HandleJoin(msg)
 	case "input":
 		c.HandleInput(msg)
+	case "spectate":
+		c.HandleSpectate(msg)
 	case "ping":
 		c.SendMessage(ServerMessage{Type: "pong"})
 	default:
@@ -175,14 +399,53 @@ func (c *Client) HandleMessage(msg ClientMessage) {
 	}
 }
 
+// HandleHello negotiates the protocol version and capabilities. The server answers
+// with the highest version both sides speak and the capabilities it grants, or closes
+// the connection with CloseIncompatibleProtocol if there is no common version.
+func (c *Client) HandleHello(msg ClientMessage) {
+	c.mu.Lock()
+	if c.HelloDone || c.Player != nil {
+		c.mu.Unlock()
+		log.Printf("Ignoring hello from %s after handshake", c.ID)
+		return
+	}
+
+	version := msg.Version
+	if version > CurrentProtocolVersion {
+		version = CurrentProtocolVersion
+	}
+	if version < MinProtocolVersion {
+		c.mu.Unlock()
+		reason := fmt.Sprintf("incompatible protocol version %d (server supports %d-%d)", msg.Version, MinProtocolVersion, CurrentProtocolVersion)
+		log.Printf("Client %s: %s", c.ID, reason)
+		closeWithCode(c.Conn, CloseIncompatibleProtocol, reason)
+		return
+	}
+
+	c.HelloDone = true
+	c.ProtocolVersion = version
+	c.Capabilities = msg.Capabilities & ServerCapabilities
+	capabilities := c.Capabilities
+	c.mu.Unlock()
+
+	log.Printf("Client %s negotiated protocol v%d with capabilities %#x", c.ID, version, capabilities)
+
+	c.SendMessage(ServerMessage{
+		Type:    "hello",
+		Payload: HelloPayload{Version: version, Capabilities: capabilities},
+	})
+}
+
 // HandleJoin processes a join message
 func (c *Client) HandleJoin(msg ClientMessage) {
-	name := msg.Name
-	if len(name) > MaxPlayerNameLen {
-		name = name[:MaxPlayerNameLen]
-	}
-	if name == "" {
-		name = "Fish"
+	name, err := SanitizeName(msg.Name)
+	if err != nil {
+		log.Printf("Rejected name %q from %s: %v", msg.Name, c.ID, err)
+		c.SendMessage(ServerMessage{
+			Type:    "nameRejected",
+			Payload: NameRejectedPayload{Reason: err.Error()},
+		})
+		return
 	}
 
 	model := msg.Model
@@ -191,24 +454,65 @@ func (c *Client) HandleJoin(msg ClientMessage) {
 	}
 
 	player := NewPlayer(c.ID, name, model, c)
+	if err := c.World.AddPlayer(player); err != nil { // May add a number to a duplicate name
+		log.Printf("Refusing join from %s: %v", c.ID, err)
+		c.SendMessage(ServerMessage{
+			Type:    "joinError",
+			Payload: JoinErrorPayload{Reason: err.Error()},
+		})
+		return
+	}
 	c.Player = player
-	c.World.AddPlayer(player)
+	name = player.Name
+
+	// Stop spectating now the client has a fish of its own
+	c.World.RemoveSpectator(c)
 
 	// Send welcome message with player info
-	c.SendMessage(ServerMessage{
-		Type: "welcome",
-		Payload: WelcomePayload{
-			ID:          c.ID,
-			Name:        name,
-			Model:       model,
-			WorldWidth:  WorldWidth,
-			WorldHeight: WorldHeight,
-		},
+	c.SendWelcome(WelcomePayload{
+		ID:          c.ID,
+		Name:        name,
+		Model:       model,
+		WorldWidth:  WorldWidth,
+		WorldHeight: WorldHeight,
 	})
 
 	log.Printf("Player %s (%s) joined", name, c.ID)
 }
 
+// HandleSpectate attaches the client as a spectator or changes what it is watching
+func (c *Client) HandleSpectate(msg ClientMessage) {
+	if c.Player != nil {
+		log.Printf("Client %s tried to spectate while playing", c.ID)
+		return
+	}
+
+	c.World.mu.RLock()
+	_, alreadySpectating := c.World.Spectators[c.ID]
+	c.World.mu.RUnlock()
+
+	camera := Vec2{X: msg.CamX, Y: msg.CamY}
+	if !alreadySpectating && msg.CamX == 0 && msg.CamY == 0 {
+		// No viewpoint given - start in the middle of the ocean
+		camera = Vec2{X: WorldWidth / 2, Y: WorldHeight / 2}
+	}
+
+	if !c.World.SetSpectatorView(c, msg.Target, camera) {
+		log.Printf("Spectator limit reached, refusing spectator %s", c.ID)
+		return
+	}
+
+	if !alreadySpectating {
+		// Welcome without a fish so the client learns its ID and can open the meta socket
+		c.SendWelcome(WelcomePayload{
+			ID:          c.ID,
+			WorldWidth:  WorldWidth,
+			WorldHeight: WorldHeight,
+		})
+		log.Printf("Client %s started spectating", c.ID)
+	}
+}
+
 // HandleInput processes an input message
 func (c *Client) HandleInput(msg ClientMessage) {
 	if c.Player == nil {
@@ -229,24 +533,25 @@ func (c *Client) HandleInput(msg ClientMessage) {
 		Timestamp: time.Now(),
 	}
 
-	// Try to send to input queue (non-blocking)
-	select {
-	case c.World.InputQueue <- input:
-	default:
-		// Queue full, drop input
-		log.Printf("Input queue full, dropping input from %s", c.ID)
-	}
+	// Latest wins: an input the game loop hasn't applied yet is simply replaced
+	c.Player.SetInput(input)
 }
 
 // SendMessage sends a message to the client (routes to appropriate socket)
 func (c *Client) SendMessage(msg ServerMessage) {
-	// Try binary encoding first
-	data, err := EncodeBinaryMessage(msg)
+	// Try binary encoding first, in the layout the client negotiated
+	c.mu.Lock()
+	version := c.ProtocolVersion
+	hasMeta := c.MetaConn != nil
+	c.mu.Unlock()
+
+	data, err := EncodeBinaryMessageVersion(msg, version)
 	if err != nil {
 		log.Printf("Error encoding binary message: %v", err)
 		return
 	}
 	
+	outgoing := OutgoingMessage{Data: data}
 	if data == nil {
 		// Fallback to JSON for unsupported message types
 		jsonData, err := json.Marshal(msg)
@@ -254,18 +559,26 @@ func (c *Client) SendMessage(msg ServerMessage) {
 			log.Printf("Error marshaling message: %v", err)
 			return
 		}
-		data = jsonData
+		outgoing = OutgoingMessage{Data: jsonData, JSON: true}
 	}
 	
 	// Route to appropriate socket
-	var targetChan chan []byte
+	var targetChan chan OutgoingMessage
 	switch msg.Type {
 	case "state":
 		// High-frequency position updates -> primary socket
 		targetChan = c.Send
-	case "leaderboard", "playerInfo", "welcome", "allPlayers":
+	case "playerInfo":
+		// Compact state refers to players by handle, so the info that maps a handle
+		// must arrive before the first state that uses it
+		if hasMeta && version < ProtocolV3 {
+			targetChan = c.MetaSend
+		} else {
+			targetChan = c.Send
+		}
+	case "leaderboard", "welcome", "allPlayers", "event", "announcement":
 		// Low-frequency metadata -> secondary socket (if available)
-		if c.MetaConn != nil {
+		if hasMeta {
 			targetChan = c.MetaSend
 		} else {
 			targetChan = c.Send // Fallback to primary
@@ -274,26 +587,74 @@ func (c *Client) SendMessage(msg ServerMessage) {
 		targetChan = c.Send
 	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return // Disconnected
+	}
+
 	select {
-	case targetChan <- data:
+	case targetChan <- outgoing:
 	default:
-		// Channel full, client too slow
+		// Channel full, client too slow. Closing the socket ends the read pump,
+		// which runs the normal disconnect (callers may hold the world lock).
 		log.Printf("Client %s send channel full, closing connection", c.ID)
-		c.World.Disconnect(c)
+		c.Conn.Close()
 	}
 }
 
+// SendWelcome sends the welcome message with a fresh metadata socket pairing
+// token. Clients older than ProtocolV4 get the token in a metaToken message first.
+// Clients that didn't negotiate CapMetaSocket get no token and keep everything on
+// the primary socket.
+func (c *Client) SendWelcome(payload WelcomePayload) {
+	if !c.Has(CapMetaSocket) {
+		c.SendMessage(ServerMessage{Type: "welcome", Payload: payload})
+		return
+	}
+
+	payload.MetaToken = c.IssueMetaToken()
+	if c.Protocol() < ProtocolV4 {
+		c.SendMessage(ServerMessage{
+			Type:    "metaToken",
+			Payload: MetaTokenPayload{Token: payload.MetaToken},
+		})
+	}
+	c.SendMessage(ServerMessage{Type: "welcome", Payload: payload})
+}
+
 // HandleWebSocket upgrades HTTP connection to WebSocket (primary socket)
 func HandleWebSocket(world *World) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ip := ClientIP(r)
+		if moderation.IsBanned(ip) {
+			log.Printf("Refusing connection from banned IP %s", ip)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if !gameConnLimit.Acquire(ip) {
+			log.Printf("Refusing connection from %s: %d connections already open", ip, gameConnLimit.Max)
+			http.Error(w, "Too many connections", http.StatusTooManyRequests)
+			return
+		}
+
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
-			log.Printf("WebSocket upgrade error: %v", err)
+			log.Printf("WebSocket upgrade from %s failed: %v", ip, err)
+			gameConnLimit.Release(ip)
 			return
 		}
 
-		clientID := generateClientID()
+		clientID := NewClientID()
 		client := NewClient(clientID, conn, world)
+		client.IP = ip
+		world.AddClient(client)
+		switch conn.Subprotocol() {
+		case FramedSubprotocol:
+			client.Capabilities |= CapFramed | CapBinaryInput
+		case BinaryInputSubprotocol:
+			client.Capabilities |= CapBinaryInput
+		}
 
 		// Start read and write pumps
 		go client.WritePump()
@@ -301,52 +662,45 @@ func HandleWebSocket(world *World) http.HandlerFunc {
 	}
 }
 
-// HandleMetaWebSocket upgrades HTTP connection to metadata WebSocket (secondary socket)
+// HandleMetaWebSocket upgrades HTTP connection to metadata WebSocket (secondary socket).
+// The client must present its ID and the one-time token from its welcome message.
 func HandleMetaWebSocket(world *World) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Get client ID from query parameter
 		clientID := r.URL.Query().Get("id")
-		if clientID == "" {
-			http.Error(w, "Missing client ID", http.StatusBadRequest)
+		token := r.URL.Query().Get("token")
+		if clientID == "" || token == "" {
+			http.Error(w, "Missing client ID or token", http.StatusBadRequest)
 			return
 		}
 
-		conn, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			log.Printf("Meta WebSocket upgrade error: %v", err)
+		// Find the existing client and check the pairing token before upgrading
+		client := world.FindClient(clientID)
+		if client == nil || !client.ClaimMetaToken(token) {
+			log.Printf("Refusing meta socket for client %s from %s: unknown client or bad token", clientID, ClientIP(r))
+			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
 
-		// Find existing client and attach metadata socket
-		world.mu.RLock()
-		var client *Client
-		for _, player := range world.Players {
-			if player.Client != nil && player.Client.ID == clientID {
-				client = player.Client
-				break
-			}
-		}
-		world.mu.RUnlock()
-
-		if client == nil {
-			log.Printf("Client %s not found for meta socket", clientID)
-			conn.Close()
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("Meta WebSocket upgrade from %s failed: %v", ClientIP(r), err)
+			client.SendMessage(ServerMessage{
+				Type:    "metaToken",
+				Payload: MetaTokenPayload{Token: client.IssueMetaToken()},
+			})
 			return
 		}
 
-		// Attach metadata socket
-		client.mu.Lock()
-		client.MetaConn = conn
-		client.mu.Unlock()
-
+		client.AttachMeta(conn)
 		log.Printf("Meta WebSocket connected for client %s", clientID)
-
-		// Start metadata write pump
-		go client.MetaWritePump()
 	}
 }
 
-// generateClientID generates a unique client ID
-func generateClientID() string {
-	return time.Now().Format("20060102150405") + "-" + string(rune(RandomInt(1000, 9999)))
+// generateToken returns an unguessable 128-bit hex secret
+func generateToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return hex.EncodeToString(b)
 }
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// readUntil reads frames from a legacy (v1) client socket until want returns true
+func readUntil(t *testing.T, conn *websocket.Conn, want func(ServerMessage) bool) ServerMessage {
+	t.Helper()
+	return readUntilVersion(t, conn, ProtocolV1, want)
+}
+
+// readUntilVersion is readUntil for a client that negotiated the given protocol version
+func readUntilVersion(t *testing.T, conn *websocket.Conn, version uint16, want func(ServerMessage) bool) ServerMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+
+		var messages []ServerMessage
+		if messageType == websocket.TextMessage {
+			var msg struct {
+				Type    string          `json:"type"`
+				Payload json.RawMessage `json:"payload"`
+			}
+			if err := json.Unmarshal(data, &msg); err != nil {
+				t.Fatalf("bad JSON frame %q: %v", data, err)
+			}
+			if msg.Type == "metaToken" {
+				var payload MetaTokenPayload
+				json.Unmarshal(msg.Payload, &payload)
+				messages = append(messages, ServerMessage{Type: msg.Type, Payload: payload})
+			} else {
+				messages = append(messages, ServerMessage{Type: msg.Type})
+			}
+		} else {
+			messages, err = DecodeBinaryMessages(data, version)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+		}
+
+		for _, msg := range messages {
+			if want(msg) {
+				return msg
+			}
+		}
+	}
+}
+
+func isType(msgType string) func(ServerMessage) bool {
+	return func(msg ServerMessage) bool { return msg.Type == msgType }
+}
+
+func TestMetaSocketPairing(t *testing.T) {
+	world := NewWorld()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", HandleWebSocket(world))
+	mux.HandleFunc("/ws/meta", HandleMetaWebSocket(world))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/ws", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	conn.WriteJSON(ClientMessage{Type: "join", Name: "Bob", Model: "shark"})
+
+	// Legacy clients get the token in a metaToken message ahead of the welcome
+	token := readUntil(t, conn, isType("metaToken")).Payload.(MetaTokenPayload).Token
+	welcome := readUntil(t, conn, isType("welcome")).Payload.(WelcomePayload)
+	if len(token) != 32 {
+		t.Fatalf("token %q should be 128 bits of hex", token)
+	}
+
+	dialMeta := func(id, token string) (*websocket.Conn, int) {
+		query := url.Values{"id": {id}, "token": {token}}
+		metaConn, resp, err := websocket.DefaultDialer.Dial(wsURL+"/ws/meta?"+query.Encode(), nil)
+		if err != nil {
+			if resp == nil {
+				t.Fatalf("dial meta: %v", err)
+			}
+			return nil, resp.StatusCode
+		}
+		return metaConn, http.StatusSwitchingProtocols
+	}
+
+	if _, status := dialMeta(welcome.ID, ""); status != http.StatusBadRequest {
+		t.Errorf("missing token: got status %d, want 400", status)
+	}
+	if _, status := dialMeta(welcome.ID, strings.Repeat("0", 32)); status != http.StatusForbidden {
+		t.Errorf("wrong token: got status %d, want 403", status)
+	}
+
+	metaConn, status := dialMeta(welcome.ID, token)
+	if metaConn == nil {
+		t.Fatalf("correct token: got status %d", status)
+	}
+	if !world.FindClient(welcome.ID).HasMeta() {
+		t.Fatal("meta socket should be attached")
+	}
+
+	if _, status := dialMeta(welcome.ID, token); status != http.StatusForbidden {
+		t.Errorf("reused token: got status %d, want 403", status)
+	}
+
+	// Closing the meta socket is noticed, and a new token is issued for reconnecting
+	metaConn.Close()
+	fresh := readUntil(t, conn, isType("metaToken")).Payload.(MetaTokenPayload).Token
+	if fresh == token {
+		t.Error("a new token should be issued after the meta socket closes")
+	}
+	if world.FindClient(welcome.ID).HasMeta() {
+		t.Error("closed meta socket should be detached")
+	}
+
+	metaConn, status = dialMeta(welcome.ID, fresh)
+	if metaConn == nil {
+		t.Fatalf("re-pairing with the new token: got status %d", status)
+	}
+	metaConn.Close()
+}
+
+func TestHelloNegotiation(t *testing.T) {
+	world := NewWorld()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", HandleWebSocket(world))
+	mux.HandleFunc("/ws/meta", HandleMetaWebSocket(world))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"/ws", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	// A newer client gets our version, and only capabilities we both have. Leaving
+	// out CapMetaSocket keeps everything on this socket.
+	conn.WriteJSON(ClientMessage{Type: "hello", Version: CurrentProtocolVersion + 1, Capabilities: CapCompression | CapDeltaState})
+	hello := readUntilVersion(t, conn, CurrentProtocolVersion, isType("hello")).Payload.(HelloPayload)
+	if hello.Version != CurrentProtocolVersion || hello.Capabilities != CapCompression {
+		t.Errorf("negotiated %+v, want v%d with compression only", hello, CurrentProtocolVersion)
+	}
+
+	conn.WriteJSON(ClientMessage{Type: "join", Name: "Bob", Model: "shark"})
+	welcome := readUntilVersion(t, conn, CurrentProtocolVersion, isType("welcome")).Payload.(WelcomePayload)
+	if welcome.MetaToken != "" {
+		t.Error("a client without CapMetaSocket shouldn't get a pairing token")
+	}
+
+	client := world.FindClient(welcome.ID)
+	token := client.IssueMetaToken() // As if one had leaked
+	query := url.Values{"id": {welcome.ID}, "token": {token}}
+	if _, resp, err := websocket.DefaultDialer.Dial(wsURL+"/ws/meta?"+query.Encode(), nil); err == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("/ws/meta should be refused without CapMetaSocket, got %v", err)
+	}
+
+	// A client older than we support is closed with CloseIncompatibleProtocol
+	old, _, err := websocket.DefaultDialer.Dial(wsURL+"/ws", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer old.Close()
+	old.WriteJSON(ClientMessage{Type: "hello", Version: MinProtocolVersion - 1})
+	old.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := old.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, CloseIncompatibleProtocol) {
+				t.Errorf("old client: got %v, want close code %d", err, CloseIncompatibleProtocol)
+			}
+			break
+		}
+	}
+}
+
+func TestClientCloseIsIdempotent(t *testing.T) {
+	client := NewClient("c1", nil, NewWorld())
+	client.Close()
+	client.Close()
+
+	// Sending after close is dropped rather than panicking
+	client.SendMessage(ServerMessage{Type: "pong"})
+	client.SendMessage(ServerMessage{Type: "leaderboard", Payload: []LeaderboardEntry{}})
+}
@@ -1,6 +1,12 @@
 package main
 
-import "unsafe"
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"unsafe"
+)
 
 // ClientMessage represents incoming messages from clients
 type ClientMessage struct {
@@ -11,6 +17,13 @@ type ClientMessage struct {
 	DirY  float64 `json:"dirY,omitempty"`
 	Boost bool    `json:"boost,omitempty"`
 	Seq   uint32  `json:"seq,omitempty"`
+	// Spectate: follow Target, or free-roam the camera at CamX/CamY when Target is empty
+	Target string  `json:"target,omitempty"`
+	CamX   float64 `json:"camX,omitempty"`
+	CamY   float64 `json:"camY,omitempty"`
+	// Hello: highest protocol version and capabilities the client supports
+	Version      uint16 `json:"version,omitempty"`
+	Capabilities uint32 `json:"capabilities,omitempty"`
 }
 
 // ServerMessage represents outgoing messages to clients
@@ -19,6 +32,12 @@ type ServerMessage struct {
 	Payload interface{} `json:"payload,omitempty"`
 }
 
+// HelloPayload answers a client's hello with the negotiated version and capabilities
+type HelloPayload struct {
+	Version      uint16 `json:"version"`
+	Capabilities uint32 `json:"capabilities"`
+}
+
 // WelcomePayload is sent after a player joins
 type WelcomePayload struct {
 	ID          string  `json:"id"`
@@ -26,6 +45,19 @@ type WelcomePayload struct {
 	Model       string  `json:"model"`
 	WorldWidth  float64 `json:"worldWidth"`
 	WorldHeight float64 `json:"worldHeight"`
+	MetaToken   string  `json:"metaToken,omitempty"` // One-time secret for /ws/meta
+}
+
+// MetaTokenPayload hands out a metadata socket pairing token outside the welcome
+// (to clients older than ProtocolV4, and after the meta socket drops)
+type MetaTokenPayload struct {
+	Token string `json:"token"`
+}
+
+// JoinErrorPayload tells the client its join failed for a reason other than its name.
+// Always sent as JSON.
+type JoinErrorPayload struct {
+	Reason string `json:"reason"`
 }
 
 // GameStatePayload contains the current game state for a player
@@ -55,6 +87,8 @@ type PlayerState struct {
 	RespawnIn  *float64 `json:"respawnIn,omitempty"`
 	PowerupActive bool  `json:"powerupActive,omitempty"`
 	PowerupDuration float64 `json:"powerupDuration,omitempty"`
+	SpawnProtected bool     `json:"spawnProtected,omitempty"`
+	Spectating bool         `json:"spectating,omitempty"`
 }
 
 // OtherPlayerState represents another player's state
@@ -69,6 +103,7 @@ type OtherPlayerState struct {
 	Size     float64 `json:"size"`
 	Model    string  `json:"model,omitempty"`
 	PowerupActive bool `json:"powerupActive,omitempty"`
+	Handle   uint16  `json:"handle,omitempty"` // Per-client short ID, see PlayerInfoPayload
 }
 
 // FoodState represents a food item's state
@@ -95,9 +130,10 @@ type LeaderboardEntry struct {
 
 // PlayerInfoPayload contains player metadata (sent once)
 type PlayerInfoPayload struct {
-	ID    string `json:"id"`
-	Name  string `json:"name"`
-	Model string `json:"model"`
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Model  string `json:"model"`
+	Handle uint16 `json:"handle,omitempty"` // Short ID used for this player in compact state
 }
 
 // AllPlayersPayload contains all player positions for shark vision powerup
@@ -112,30 +148,86 @@ type PlayerPosition struct {
 	Y  float64 `json:"y"`
 }
 
+// Protocol versions. Clients that never send hello are treated as ProtocolV1.
+const (
+	ProtocolV1 uint16 = 1 // Original layout: message types 1-6, state flag bits 0-3
+	ProtocolV2 uint16 = 2 // Adds event, announcement, nameRejected and hello messages and state flag bits 4-5
+	ProtocolV3 uint16 = 3 // Compact state (entity handles, quantised positions, varint IDs); playerInfo carries the handle
+	ProtocolV4 uint16 = 4 // Welcome carries the metadata socket pairing token
+
+	CurrentProtocolVersion = ProtocolV4
+	MinProtocolVersion     = ProtocolV1
+)
+
+// Capability flags exchanged in hello
+const (
+	CapBinaryInput uint32 = 1 << iota // Client sends binary input/ping/join
+	CapDeltaState                     // Delta-compressed state (not supported yet, never granted)
+	CapCompression                    // permessage-deflate on outgoing frames
+	CapMetaSocket                     // Client opens /ws/meta for low-frequency messages
+	CapFramed                         // Outgoing frames use framed batches
+)
+
+// ServerCapabilities are the capabilities this server can grant
+const ServerCapabilities = CapBinaryInput | CapCompression | CapMetaSocket | CapFramed
+
+// LegacyCapabilities are assumed for clients that never send hello
+const LegacyCapabilities = CapCompression | CapMetaSocket
+
+// CloseIncompatibleProtocol is the WebSocket close code sent when the client's
+// protocol version can't be reconciled with the server's
+const CloseIncompatibleProtocol = 4001
+
 // Binary Protocol Implementation
 // Message Types
 const (
-	MsgTypeWelcome     byte = 1
-	MsgTypeState       byte = 2
-	MsgTypePong        byte = 3
-	MsgTypeLeaderboard byte = 4
-	MsgTypePlayerInfo  byte = 5 // Send player name/model once
-	MsgTypeAllPlayers  byte = 6 // Send all player positions for shark vision
+	MsgTypeWelcome      byte = 1
+	MsgTypeState        byte = 2
+	MsgTypePong         byte = 3
+	MsgTypeLeaderboard  byte = 4
+	MsgTypePlayerInfo   byte = 5 // Send player name/model once
+	MsgTypeAllPlayers   byte = 6 // Send all player positions for shark vision
+	MsgTypeEvent        byte = 7 // Gameplay event for the kill feed
+	MsgTypeAnnouncement byte = 8 // Server announcement from the admin API
+	MsgTypeNameRejected byte = 9 // Join refused because of the chosen name
+	MsgTypeHello        byte = 10 // Negotiated protocol version and capabilities
 )
 
-// EncodeBinaryMessage encodes a server message into binary format
+// EncodeBinaryMessage encodes a server message into binary format for the current protocol version
 func EncodeBinaryMessage(msg ServerMessage) ([]byte, error) {
+	return EncodeBinaryMessageVersion(msg, CurrentProtocolVersion)
+}
+
+// EncodeBinaryMessageVersion encodes a server message in the layout of the given
+// protocol version. It returns nil for messages the version has no binary encoding
+// for, which are then sent as JSON.
+func EncodeBinaryMessageVersion(msg ServerMessage, version uint16) ([]byte, error) {
+	if version < ProtocolV2 {
+		switch msg.Type {
+		case "event", "announcement", "nameRejected", "hello":
+			return nil, nil
+		}
+	}
+
 	switch msg.Type {
 	case "welcome":
-		return encodeWelcome(msg.Payload.(WelcomePayload))
+		return encodeWelcome(msg.Payload.(WelcomePayload), version)
 	case "state":
-		return encodeGameState(msg.Payload.(GameStatePayload))
+		return encodeGameState(msg.Payload.(GameStatePayload), version)
 	case "leaderboard":
 		return encodeLeaderboard(msg.Payload.([]LeaderboardEntry))
 	case "playerInfo":
-		return encodePlayerInfo(msg.Payload.(PlayerInfoPayload))
+		return encodePlayerInfo(msg.Payload.(PlayerInfoPayload), version)
 	case "allPlayers":
 		return encodeAllPlayers(msg.Payload.(AllPlayersPayload))
+	case "event":
+		return encodeEvent(msg.Payload.(GameEvent))
+	case "announcement":
+		return encodeAnnouncement(msg.Payload.(AnnouncementPayload))
+	case "nameRejected":
+		return encodeNameRejected(msg.Payload.(NameRejectedPayload))
+	case "hello":
+		return encodeHello(msg.Payload.(HelloPayload))
 	case "pong":
 		return []byte{MsgTypePong}, nil
 	default:
@@ -143,7 +235,7 @@ func EncodeBinaryMessage(msg ServerMessage) ([]byte, error) {
 	}
 }
 
-func encodeWelcome(payload WelcomePayload) ([]byte, error) {
+func encodeWelcome(payload WelcomePayload, version uint16) ([]byte, error) {
 	capacity := 1 + 2 + len(payload.ID) + 2 + len(payload.Name) + 2 + len(payload.Model) + 16
 	buf := make([]byte, 0, capacity)
 	
@@ -161,11 +253,19 @@ func encodeWelcome(payload WelcomePayload) ([]byte, error) {
 	buf = append(buf, make([]byte, 16)...)
 	putFloat64(buf[oldLen:], payload.WorldWidth)
 	putFloat64(buf[oldLen+8:], payload.WorldHeight)
+
+	if version >= ProtocolV4 {
+		buf = appendString(buf, payload.MetaToken)
+	}
 	
 	return buf, nil
 }
 
-func encodeGameState(state GameStatePayload) ([]byte, error) {
+func encodeGameState(state GameStatePayload, version uint16) ([]byte, error) {
+	if version >= ProtocolV3 {
+		return encodeCompactGameState(state), nil
+	}
+
 	// Estimate size (no leaderboard - sent separately)
 	capacity := 1 + 64 + len(state.Others)*32 + len(state.Food)*20 + len(state.Powerups)*20
 	buf := make([]byte, 0, capacity)
@@ -173,7 +273,7 @@ func encodeGameState(state GameStatePayload) ([]byte, error) {
 	buf = append(buf, MsgTypeState)
 	
 	// Encode player state (no ID/name/model)
-	buf = encodePlayerState(buf, state.You)
+	buf = encodePlayerState(buf, state.You, version)
 	
 	// Encode others count + data
 	buf = append(buf, byte(len(state.Others)>>8), byte(len(state.Others)))
@@ -198,8 +298,9 @@ func encodeGameState(state GameStatePayload) ([]byte, error) {
 	return buf, nil
 }
 
-func encodePlayerState(buf []byte, player PlayerState) []byte {
-	// Flags byte: bit 0 = alive, bit 1 = has killedBy, bit 2 = has respawnIn, bit 3 = powerupActive
+func encodePlayerState(buf []byte, player PlayerState, version uint16) []byte {
+	// Flags byte: bit 0 = alive, bit 1 = has killedBy, bit 2 = has respawnIn, bit 3 = powerupActive,
+	// bit 4 = spawnProtected, bit 5 = spectating (flags only, no extra payload)
 	flags := byte(0)
 	if player.Alive {
 		flags |= 1
@@ -213,6 +314,15 @@ func encodePlayerState(buf []byte, player PlayerState) []byte {
 	if player.PowerupActive {
 		flags |= 8
 	}
+	if player.SpawnProtected {
+		flags |= 16
+	}
+	if player.Spectating {
+		flags |= 32
+	}
+	if version < ProtocolV2 {
+		flags &= 0x0F // V1 only defines bits 0-3
+	}
 	buf = append(buf, flags)
 	
 	// Only send dynamic data (no ID, Name, Model - those are sent once)
@@ -278,6 +388,81 @@ func encodePowerupState(buf []byte, powerup PowerupState) []byte {
 	return buf
 }
 
+// Compact state (ProtocolV3)
+//
+//	[2][you, as in v2]
+//	[others uvarint] then per player: [handle uint16][x uint16][y uint16][rotation uint8][size uint16][flags]
+//	[food uvarint] then per pellet:   [id uvarint][x uint16][y uint16][radius uint8]
+//	[powerups uvarint] then per item: [id uvarint][x uint16][y uint16][radius uint8]
+//
+// Other players are identified by the handle sent in their playerInfo, and their
+// velocity is left for the client to derive from successive positions.
+const (
+	compactSizeScale   = 16 // Sizes in 1/16 units
+	compactRadiusScale = 8  // Food and powerup radii in 1/8 units
+)
+
+// Other player flags (compact state)
+const (
+	OtherFlagPowerup byte = 1
+)
+
+func encodeCompactGameState(state GameStatePayload) []byte {
+	capacity := 1 + 64 + 3 + len(state.Others)*10 + 3 + len(state.Food)*8 + 3 + len(state.Powerups)*8
+	buf := make([]byte, 0, capacity)
+
+	buf = append(buf, MsgTypeState)
+	buf = encodePlayerState(buf, state.You, ProtocolV3)
+
+	buf = binary.AppendUvarint(buf, uint64(len(state.Others)))
+	for _, other := range state.Others {
+		flags := byte(0)
+		if other.PowerupActive {
+			flags |= OtherFlagPowerup
+		}
+		buf = append(buf, byte(other.Handle>>8), byte(other.Handle))
+		buf = appendUint16(buf, quantizePosition(other.X, WorldWidth))
+		buf = appendUint16(buf, quantizePosition(other.Y, WorldHeight))
+		buf = append(buf, quantizeAngle8(other.Rotation))
+		buf = appendUint16(buf, quantizeScaled(other.Size, compactSizeScale, math.MaxUint16))
+		buf = append(buf, flags)
+	}
+
+	buf = binary.AppendUvarint(buf, uint64(len(state.Food)))
+	for _, food := range state.Food {
+		buf = appendCompactItem(buf, food.ID, food.X, food.Y, food.R)
+	}
+
+	buf = binary.AppendUvarint(buf, uint64(len(state.Powerups)))
+	for _, powerup := range state.Powerups {
+		buf = appendCompactItem(buf, powerup.ID, powerup.X, powerup.Y, powerup.R)
+	}
+
+	return buf
+}
+
+func appendCompactItem(buf []byte, id uint64, x, y, radius float64) []byte {
+	buf = binary.AppendUvarint(buf, id)
+	buf = appendUint16(buf, quantizePosition(x, WorldWidth))
+	buf = appendUint16(buf, quantizePosition(y, WorldHeight))
+	return append(buf, byte(quantizeScaled(radius, compactRadiusScale, math.MaxUint8)))
+}
+
+// quantizePosition maps a coordinate in [0, extent] onto the full uint16 range
+func quantizePosition(v, extent float64) uint16 {
+	return uint16(math.Round(Clamp(v, 0, extent) / extent * math.MaxUint16))
+}
+
+// dequantizePosition is the inverse of quantizePosition
+func dequantizePosition(q uint16, extent float64) float64 {
+	return float64(q) / math.MaxUint16 * extent
+}
+
+// quantizeScaled stores v in 1/scale units, saturating at limit
+func quantizeScaled(v, scale float64, limit uint16) uint16 {
+	return uint16(math.Round(Clamp(v*scale, 0, float64(limit))))
+}
+
 func encodeLeaderboardEntry(buf []byte, entry LeaderboardEntry) []byte {
 	buf = appendString(buf, entry.Name)
 	buf = appendUint32(buf, uint32(entry.Score))
@@ -296,12 +481,15 @@ func encodeLeaderboard(entries []LeaderboardEntry) ([]byte, error) {
 	return buf, nil
 }
 
-func encodePlayerInfo(info PlayerInfoPayload) ([]byte, error) {
+func encodePlayerInfo(info PlayerInfoPayload, version uint16) ([]byte, error) {
 	buf := make([]byte, 0, 64)
 	buf = append(buf, MsgTypePlayerInfo)
 	buf = appendString(buf, info.ID)
 	buf = appendString(buf, info.Name)
 	buf = appendString(buf, info.Model)
+	if version >= ProtocolV3 {
+		buf = append(buf, byte(info.Handle>>8), byte(info.Handle))
+	}
 	return buf, nil
 }
 
@@ -323,7 +511,208 @@ func encodeAllPlayers(payload AllPlayersPayload) ([]byte, error) {
 	return buf, nil
 }
 
+func encodeEvent(event GameEvent) ([]byte, error) {
+	capacity := 1 + 1 + 8 + len(event.PlayerID) + len(event.PlayerName) + len(event.TargetID) + len(event.TargetName) + 4
+	buf := make([]byte, 0, capacity)
+	buf = append(buf, MsgTypeEvent)
+	buf = append(buf, byte(event.Type))
+	buf = appendString(buf, event.PlayerID)
+	buf = appendString(buf, event.PlayerName)
+	buf = appendString(buf, event.TargetID)
+	buf = appendString(buf, event.TargetName)
+	buf = appendUint32(buf, uint32(event.Score))
+	return buf, nil
+}
+
+func encodeAnnouncement(payload AnnouncementPayload) ([]byte, error) {
+	buf := make([]byte, 0, 1+2+len(payload.Message))
+	buf = append(buf, MsgTypeAnnouncement)
+	buf = appendString(buf, payload.Message)
+	return buf, nil
+}
+
+func encodeHello(payload HelloPayload) ([]byte, error) {
+	buf := make([]byte, 0, 1+2+4)
+	buf = append(buf, MsgTypeHello, byte(payload.Version>>8), byte(payload.Version))
+	return appendUint32(buf, payload.Capabilities), nil
+}
+
+func encodeNameRejected(payload NameRejectedPayload) ([]byte, error) {
+	buf := make([]byte, 0, 1+2+len(payload.Reason))
+	buf = append(buf, MsgTypeNameRejected)
+	buf = appendString(buf, payload.Reason)
+	return buf, nil
+}
+
+// Client → Server Binary Protocol
+// Clients that negotiate BinaryInputSubprotocol may send these as binary frames;
+// text frames are always decoded as JSON ClientMessages.
+const BinaryInputSubprotocol = "fishy-binary-v1"
+
+// Client message types
+const (
+	ClientMsgTypeInput byte = 1 // [type][angle uint16][flags][seq uint32]
+	ClientMsgTypePing  byte = 2 // [type]
+	ClientMsgTypeJoin  byte = 3 // [type][name string][model string]
+	ClientMsgTypeHello byte = 4 // [type][version uint16][capabilities uint32]
+)
+
+// Input flags
+const (
+	InputFlagBoost  byte = 1 // Boosting
+	InputFlagMoving byte = 2 // Direction is set (otherwise the fish stops)
+)
+
+const (
+	binaryInputLen     = 1 + 2 + 1 + 4
+	binaryHelloLen     = 1 + 2 + 4
+	maxBinaryNameBytes = MaxMessageSize // Like JSON, long names are cut to MaxPlayerNameLen runes by SanitizeName
+	maxBinaryModelLen  = 32
+)
+
+// ErrShortMessage is returned when a binary client message is truncated
+var ErrShortMessage = errors.New("binary message too short")
+
+// EncodeBinaryClientMessage encodes a client message into binary format
+func EncodeBinaryClientMessage(msg ClientMessage) ([]byte, error) {
+	switch msg.Type {
+	case "input":
+		flags := byte(0)
+		if msg.Boost {
+			flags |= InputFlagBoost
+		}
+		angle := uint16(0)
+		if msg.DirX != 0 || msg.DirY != 0 {
+			flags |= InputFlagMoving
+			angle = quantizeAngle(math.Atan2(msg.DirY, msg.DirX))
+		}
+		buf := make([]byte, 0, binaryInputLen)
+		buf = append(buf, ClientMsgTypeInput, byte(angle>>8), byte(angle), flags)
+		return appendUint32(buf, msg.Seq), nil
+	case "ping":
+		return []byte{ClientMsgTypePing}, nil
+	case "join":
+		buf := make([]byte, 0, 1+2+len(msg.Name)+2+len(msg.Model))
+		buf = append(buf, ClientMsgTypeJoin)
+		buf = appendString(buf, msg.Name)
+		return appendString(buf, msg.Model), nil
+	case "hello":
+		buf := make([]byte, 0, binaryHelloLen)
+		buf = append(buf, ClientMsgTypeHello, byte(msg.Version>>8), byte(msg.Version))
+		return appendUint32(buf, msg.Capabilities), nil
+	default:
+		return nil, fmt.Errorf("no binary encoding for client message %q", msg.Type)
+	}
+}
+
+// DecodeBinaryClientMessage decodes a binary client message. Lengths are checked
+// strictly: truncated messages, oversized strings and trailing bytes are errors.
+func DecodeBinaryClientMessage(data []byte) (ClientMessage, error) {
+	if len(data) == 0 {
+		return ClientMessage{}, ErrShortMessage
+	}
+
+	switch data[0] {
+	case ClientMsgTypeInput:
+		if len(data) != binaryInputLen {
+			return ClientMessage{}, fmt.Errorf("input message must be %d bytes, got %d", binaryInputLen, len(data))
+		}
+		angle := uint16(data[1])<<8 | uint16(data[2])
+		flags := data[3]
+		if flags&^(InputFlagBoost|InputFlagMoving) != 0 {
+			return ClientMessage{}, fmt.Errorf("unknown input flags %#x", flags)
+		}
+		msg := ClientMessage{
+			Type:  "input",
+			Boost: flags&InputFlagBoost != 0,
+			Seq:   readUint32(data[4:]),
+		}
+		if flags&InputFlagMoving != 0 {
+			radians := dequantizeAngle(angle)
+			msg.DirX = math.Cos(radians)
+			msg.DirY = math.Sin(radians)
+		}
+		return msg, nil
+
+	case ClientMsgTypePing:
+		if len(data) != 1 {
+			return ClientMessage{}, fmt.Errorf("ping message must be 1 byte, got %d", len(data))
+		}
+		return ClientMessage{Type: "ping"}, nil
+
+	case ClientMsgTypeJoin:
+		offset := 1
+		name, offset, err := readString(data, offset, maxBinaryNameBytes)
+		if err != nil {
+			return ClientMessage{}, fmt.Errorf("join name: %w", err)
+		}
+		model, offset, err := readString(data, offset, maxBinaryModelLen)
+		if err != nil {
+			return ClientMessage{}, fmt.Errorf("join model: %w", err)
+		}
+		if offset != len(data) {
+			return ClientMessage{}, fmt.Errorf("join message has %d trailing bytes", len(data)-offset)
+		}
+		return ClientMessage{Type: "join", Name: name, Model: model}, nil
+
+	case ClientMsgTypeHello:
+		if len(data) != binaryHelloLen {
+			return ClientMessage{}, fmt.Errorf("hello message must be %d bytes, got %d", binaryHelloLen, len(data))
+		}
+		return ClientMessage{
+			Type:         "hello",
+			Version:      uint16(data[1])<<8 | uint16(data[2]),
+			Capabilities: readUint32(data[3:]),
+		}, nil
+
+	default:
+		return ClientMessage{}, fmt.Errorf("unknown client message type %d", data[0])
+	}
+}
+
+// quantizeAngle maps an angle in radians onto the full uint16 range
+func quantizeAngle(radians float64) uint16 {
+	turns := radians / (2 * math.Pi)
+	turns -= math.Floor(turns) // Wrap into [0, 1)
+	return uint16(uint32(math.Round(turns * 65536))) // 65536 wraps to 0
+}
+
+// dequantizeAngle is the inverse of quantizeAngle
+func dequantizeAngle(angle uint16) float64 {
+	return float64(angle) / 65536 * 2 * math.Pi
+}
+
+// quantizeAngle8 maps an angle in radians onto a single byte (256 steps per turn)
+func quantizeAngle8(radians float64) byte {
+	return byte((uint32(quantizeAngle(radians)) + 0x80) >> 8) // 256 wraps to 0
+}
+
+// dequantizeAngle8 is the inverse of quantizeAngle8
+func dequantizeAngle8(angle byte) float64 {
+	return dequantizeAngle(uint16(angle) << 8)
+}
+
 // Helper functions
+func readUint32(buf []byte) uint32 {
+	return uint32(buf[0])<<24 | uint32(buf[1])<<16 | uint32(buf[2])<<8 | uint32(buf[3])
+}
+
+// readString reads a uint16 length-prefixed string at offset, returning the offset after it
+func readString(buf []byte, offset, maxLen int) (string, int, error) {
+	if offset+2 > len(buf) {
+		return "", offset, ErrShortMessage
+	}
+	length := int(buf[offset])<<8 | int(buf[offset+1])
+	offset += 2
+	if length > maxLen {
+		return "", offset, fmt.Errorf("string length %d exceeds %d", length, maxLen)
+	}
+	if offset+length > len(buf) {
+		return "", offset, ErrShortMessage
+	}
+	return string(buf[offset : offset+length]), offset + length, nil
+}
+
 func appendString(buf []byte, s string) []byte {
 	length := uint16(len(s))
 	buf = append(buf, byte(length>>8), byte(length))
@@ -338,6 +727,10 @@ func appendFloat32(buf []byte, f float32) []byte {
 	return append(buf, byte(bits>>24), byte(bits>>16), byte(bits>>8), byte(bits))
 }
 
+func appendUint16(buf []byte, u uint16) []byte {
+	return append(buf, byte(u>>8), byte(u))
+}
+
 func appendUint32(buf []byte, u uint32) []byte {
 	return append(buf, byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
 }
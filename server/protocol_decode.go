@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Binary Protocol Decoding
+// Mirrors the encoders in protocol.go. Used by tests, bots and tooling; the
+// browser client has its own decoder in client/lib/connection.ts.
+
+// ErrTruncatedMessage is returned when a binary message ends early
+var ErrTruncatedMessage = errors.New("binary message truncated")
+
+// DecodeBinaryMessage decodes the binary server message at the start of data in
+// the layout of the given protocol version. It returns the message and the number
+// of bytes it occupied, so concatenated (legacy, unframed) messages can be split.
+func DecodeBinaryMessage(data []byte, version uint16) (ServerMessage, int, error) {
+	r := &binaryReader{buf: data}
+	msgType := r.byte()
+	if r.err != nil {
+		return ServerMessage{}, 0, r.err
+	}
+
+	if version < ProtocolV2 && msgType > MsgTypeAllPlayers {
+		return ServerMessage{}, 0, fmt.Errorf("message type %d does not exist in protocol v%d", msgType, version)
+	}
+
+	var msg ServerMessage
+	switch msgType {
+	case MsgTypeWelcome:
+		welcome := WelcomePayload{
+			ID:          r.string(),
+			Name:        r.string(),
+			Model:       r.string(),
+			WorldWidth:  r.float64(),
+			WorldHeight: r.float64(),
+		}
+		if version >= ProtocolV4 {
+			welcome.MetaToken = r.string()
+		}
+		msg = ServerMessage{Type: "welcome", Payload: welcome}
+	case MsgTypeState:
+		msg = ServerMessage{Type: "state", Payload: decodeGameState(r, version)}
+	case MsgTypePong:
+		msg = ServerMessage{Type: "pong"}
+	case MsgTypeLeaderboard:
+		count := int(r.byte())
+		entries := make([]LeaderboardEntry, 0, count)
+		for i := 0; i < count && r.err == nil; i++ {
+			entries = append(entries, LeaderboardEntry{Name: r.string(), Score: int(r.uint32())})
+		}
+		msg = ServerMessage{Type: "leaderboard", Payload: entries}
+	case MsgTypePlayerInfo:
+		info := PlayerInfoPayload{
+			ID:    r.string(),
+			Name:  r.string(),
+			Model: r.string(),
+		}
+		if version >= ProtocolV3 {
+			info.Handle = r.uint16()
+		}
+		msg = ServerMessage{Type: "playerInfo", Payload: info}
+	case MsgTypeAllPlayers:
+		count := int(r.uint16())
+		players := make([]PlayerPosition, 0, r.capHint(count, 10))
+		for i := 0; i < count && r.err == nil; i++ {
+			players = append(players, PlayerPosition{ID: r.string(), X: r.float32(), Y: r.float32()})
+		}
+		msg = ServerMessage{Type: "allPlayers", Payload: AllPlayersPayload{Players: players}}
+	case MsgTypeEvent:
+		event := GameEvent{Type: GameEventType(r.byte())}
+		if r.err == nil && (event.Type < EventPlayerAte || event.Type > EventNewLeader) {
+			return ServerMessage{}, 0, fmt.Errorf("unknown event type %d", event.Type)
+		}
+		event.PlayerID = r.string()
+		event.PlayerName = r.string()
+		event.TargetID = r.string()
+		event.TargetName = r.string()
+		event.Score = int(r.uint32())
+		msg = ServerMessage{Type: "event", Payload: event}
+	case MsgTypeAnnouncement:
+		msg = ServerMessage{Type: "announcement", Payload: AnnouncementPayload{Message: r.string()}}
+	case MsgTypeNameRejected:
+		msg = ServerMessage{Type: "nameRejected", Payload: NameRejectedPayload{Reason: r.string()}}
+	case MsgTypeHello:
+		msg = ServerMessage{Type: "hello", Payload: HelloPayload{Version: r.uint16(), Capabilities: r.uint32()}}
+	default:
+		return ServerMessage{}, 0, fmt.Errorf("unknown message type %d", msgType)
+	}
+
+	if r.err != nil {
+		return ServerMessage{}, 0, r.err
+	}
+	return msg, r.off, nil
+}
+
+// DecodeBinaryMessages splits a frame of concatenated binary messages
+func DecodeBinaryMessages(data []byte, version uint16) ([]ServerMessage, error) {
+	var messages []ServerMessage
+	for offset := 0; offset < len(data); {
+		msg, n, err := DecodeBinaryMessage(data[offset:], version)
+		if err != nil {
+			return messages, fmt.Errorf("message at offset %d: %w", offset, err)
+		}
+		messages = append(messages, msg)
+		offset += n
+	}
+	return messages, nil
+}
+
+func decodeGameState(r *binaryReader, version uint16) GameStatePayload {
+	if version >= ProtocolV3 {
+		return decodeCompactGameState(r)
+	}
+
+	state := GameStatePayload{You: decodePlayerState(r, version)}
+
+	count := int(r.uint16())
+	state.Others = make([]OtherPlayerState, 0, r.capHint(count, 27))
+	for i := 0; i < count && r.err == nil; i++ {
+		state.Others = append(state.Others, decodeOtherPlayer(r))
+	}
+
+	count = int(r.uint16())
+	state.Food = make([]FoodState, 0, r.capHint(count, 20))
+	for i := 0; i < count && r.err == nil; i++ {
+		state.Food = append(state.Food, FoodState{ID: r.uint64(), X: r.float32(), Y: r.float32(), R: r.float32()})
+	}
+
+	count = int(r.uint16())
+	state.Powerups = make([]PowerupState, 0, r.capHint(count, 20))
+	for i := 0; i < count && r.err == nil; i++ {
+		state.Powerups = append(state.Powerups, PowerupState{ID: r.uint64(), X: r.float32(), Y: r.float32(), R: r.float32()})
+	}
+
+	return state
+}
+
+func decodeCompactGameState(r *binaryReader) GameStatePayload {
+	state := GameStatePayload{You: decodePlayerState(r, ProtocolV3)}
+
+	count := r.count()
+	state.Others = make([]OtherPlayerState, 0, r.capHint(count, 10))
+	for i := 0; i < count && r.err == nil; i++ {
+		other := OtherPlayerState{
+			Handle:   r.uint16(),
+			X:        dequantizePosition(r.uint16(), WorldWidth),
+			Y:        dequantizePosition(r.uint16(), WorldHeight),
+			Rotation: dequantizeAngle8(r.byte()),
+			Size:     float64(r.uint16()) / compactSizeScale,
+		}
+		flags := r.byte()
+		if flags&^OtherFlagPowerup != 0 {
+			r.fail(fmt.Errorf("unknown player flags %#x", flags))
+		}
+		other.PowerupActive = flags&OtherFlagPowerup != 0
+		state.Others = append(state.Others, other)
+	}
+
+	count = r.count()
+	state.Food = make([]FoodState, 0, r.capHint(count, 6))
+	for i := 0; i < count && r.err == nil; i++ {
+		id, x, y, radius := decodeCompactItem(r)
+		state.Food = append(state.Food, FoodState{ID: id, X: x, Y: y, R: radius})
+	}
+
+	count = r.count()
+	state.Powerups = make([]PowerupState, 0, r.capHint(count, 6))
+	for i := 0; i < count && r.err == nil; i++ {
+		id, x, y, radius := decodeCompactItem(r)
+		state.Powerups = append(state.Powerups, PowerupState{ID: id, X: x, Y: y, R: radius})
+	}
+
+	return state
+}
+
+func decodeCompactItem(r *binaryReader) (id uint64, x, y, radius float64) {
+	id = r.uvarint()
+	x = dequantizePosition(r.uint16(), WorldWidth)
+	y = dequantizePosition(r.uint16(), WorldHeight)
+	radius = float64(r.byte()) / compactRadiusScale
+	return id, x, y, radius
+}
+
+func decodePlayerState(r *binaryReader, version uint16) PlayerState {
+	flags := r.byte()
+	knownFlags := byte(0x3F)
+	if version < ProtocolV2 {
+		knownFlags = 0x0F
+	}
+	if r.err == nil && flags&^knownFlags != 0 {
+		r.fail(fmt.Errorf("unknown player state flags %#x", flags))
+	}
+
+	player := PlayerState{
+		Alive:          flags&1 != 0,
+		PowerupActive:  flags&8 != 0,
+		SpawnProtected: flags&16 != 0,
+		Spectating:     flags&32 != 0,
+		X:              r.float32(),
+		Y:              r.float32(),
+		VelX:           r.float32(),
+		VelY:           r.float32(),
+		Rotation:       r.float32(),
+		Size:           r.float32(),
+		Score:          int(r.uint32()),
+		Seq:            r.uint32(),
+	}
+
+	if flags&2 != 0 {
+		killedBy := r.string()
+		player.KilledBy = &killedBy
+	}
+	if flags&4 != 0 {
+		respawnIn := r.float32()
+		player.RespawnIn = &respawnIn
+	}
+	if player.PowerupActive {
+		player.PowerupDuration = r.float32()
+	}
+
+	return player
+}
+
+func decodeOtherPlayer(r *binaryReader) OtherPlayerState {
+	other := OtherPlayerState{
+		ID:       r.string(),
+		X:        r.float32(),
+		Y:        r.float32(),
+		VelX:     r.float32(),
+		VelY:     r.float32(),
+		Rotation: r.float32(),
+		Size:     r.float32(),
+	}
+
+	switch r.byte() {
+	case 0:
+	case 1:
+		other.PowerupActive = true
+	default:
+		r.fail(errors.New("invalid powerup flag"))
+	}
+
+	return other
+}
+
+// binaryReader reads big-endian values with bounds checks. The first error sticks
+// and every later read returns a zero value, so callers check err once at the end.
+type binaryReader struct {
+	buf []byte
+	off int
+	err error
+}
+
+func (r *binaryReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// take returns the next n bytes, or nil after recording ErrTruncatedMessage
+func (r *binaryReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.buf)-r.off {
+		r.err = ErrTruncatedMessage
+		return nil
+	}
+	b := r.buf[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+// capHint bounds a slice capacity by what the remaining bytes could possibly hold,
+// so a corrupt count can't trigger a huge allocation
+func (r *binaryReader) capHint(count, minSize int) int {
+	if limit := (len(r.buf) - r.off) / minSize; count > limit {
+		return limit
+	}
+	return count
+}
+
+func (r *binaryReader) byte() byte {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *binaryReader) uint16() uint16 {
+	if b := r.take(2); b != nil {
+		return uint16(b[0])<<8 | uint16(b[1])
+	}
+	return 0
+}
+
+func (r *binaryReader) uint32() uint32 {
+	if b := r.take(4); b != nil {
+		return readUint32(b)
+	}
+	return 0
+}
+
+func (r *binaryReader) uint64() uint64 {
+	if b := r.take(8); b != nil {
+		return uint64(readUint32(b))<<32 | uint64(readUint32(b[4:]))
+	}
+	return 0
+}
+
+func (r *binaryReader) float32() float64 {
+	return float64(math.Float32frombits(r.uint32()))
+}
+
+func (r *binaryReader) float64() float64 {
+	return math.Float64frombits(r.uint64())
+}
+
+func (r *binaryReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf[r.off:])
+	switch {
+	case n == 0:
+		r.err = ErrTruncatedMessage
+	case n < 0:
+		r.err = errors.New("varint overflows 64 bits")
+	default:
+		r.off += n
+	}
+	return v
+}
+
+// count reads a uvarint element count, rejecting counts no message could hold
+func (r *binaryReader) count() int {
+	n := r.uvarint()
+	if n > uint64(len(r.buf)) {
+		r.fail(fmt.Errorf("count %d exceeds message length", n))
+		return 0
+	}
+	return int(n)
+}
+
+func (r *binaryReader) string() string {
+	length := int(r.uint16())
+	return string(r.take(length))
+}
@@ -0,0 +1,613 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden protocol fixtures in testdata/protocol")
+
+// roundFloat applies the float32 precision used on the wire
+func roundFloat(f float64) float64 {
+	return float64(float32(f))
+}
+
+// wireForm returns what a message should look like after an encode/decode round
+// trip: floats at float32 precision, fields the layout doesn't carry cleared, and
+// flag bits the version doesn't define dropped
+func wireForm(msg ServerMessage, version uint16) ServerMessage {
+	switch p := msg.Payload.(type) {
+	case GameStatePayload:
+		you := p.You
+		you.ID, you.Name, you.Model = "", "", ""
+		you.X, you.Y = roundFloat(you.X), roundFloat(you.Y)
+		you.VelX, you.VelY = roundFloat(you.VelX), roundFloat(you.VelY)
+		you.Rotation, you.Size = roundFloat(you.Rotation), roundFloat(you.Size)
+		you.Score = int(uint32(you.Score))
+		if you.RespawnIn != nil {
+			respawnIn := roundFloat(*you.RespawnIn)
+			you.RespawnIn = &respawnIn
+		}
+		if you.PowerupActive {
+			you.PowerupDuration = roundFloat(you.PowerupDuration)
+		} else {
+			you.PowerupDuration = 0
+		}
+		if version < ProtocolV2 {
+			you.SpawnProtected, you.Spectating = false, false
+		}
+
+		others := make([]OtherPlayerState, 0, len(p.Others))
+		food := make([]FoodState, 0, len(p.Food))
+		powerups := make([]PowerupState, 0, len(p.Powerups))
+		if version >= ProtocolV3 {
+			// Handles instead of IDs, quantised positions, no velocity
+			for _, o := range p.Others {
+				others = append(others, OtherPlayerState{
+					Handle: o.Handle, X: compactX(o.X), Y: compactY(o.Y), Rotation: dequantizeAngle8(quantizeAngle8(o.Rotation)),
+					Size: float64(quantizeScaled(o.Size, compactSizeScale, math.MaxUint16)) / compactSizeScale, PowerupActive: o.PowerupActive,
+				})
+			}
+			for _, f := range p.Food {
+				food = append(food, FoodState{ID: f.ID, X: compactX(f.X), Y: compactY(f.Y), R: compactRadius(f.R)})
+			}
+			for _, pu := range p.Powerups {
+				powerups = append(powerups, PowerupState{ID: pu.ID, X: compactX(pu.X), Y: compactY(pu.Y), R: compactRadius(pu.R)})
+			}
+		} else {
+			for _, o := range p.Others {
+				others = append(others, OtherPlayerState{
+					ID: o.ID, X: roundFloat(o.X), Y: roundFloat(o.Y), VelX: roundFloat(o.VelX), VelY: roundFloat(o.VelY),
+					Rotation: roundFloat(o.Rotation), Size: roundFloat(o.Size), PowerupActive: o.PowerupActive,
+				})
+			}
+			for _, f := range p.Food {
+				food = append(food, FoodState{ID: f.ID, X: roundFloat(f.X), Y: roundFloat(f.Y), R: roundFloat(f.R)})
+			}
+			for _, pu := range p.Powerups {
+				powerups = append(powerups, PowerupState{ID: pu.ID, X: roundFloat(pu.X), Y: roundFloat(pu.Y), R: roundFloat(pu.R)})
+			}
+		}
+		// Leaderboard is sent separately, never inside state
+		msg.Payload = GameStatePayload{You: you, Others: others, Food: food, Powerups: powerups}
+
+	case WelcomePayload:
+		if version < ProtocolV4 {
+			p.MetaToken = ""
+		}
+		msg.Payload = p
+
+	case PlayerInfoPayload:
+		if version < ProtocolV3 {
+			p.Handle = 0
+		}
+		msg.Payload = p
+
+	case []LeaderboardEntry:
+		entries := make([]LeaderboardEntry, 0, len(p))
+		for _, e := range p {
+			entries = append(entries, LeaderboardEntry{Name: e.Name, Score: int(uint32(e.Score))})
+		}
+		msg.Payload = entries
+
+	case AllPlayersPayload:
+		players := make([]PlayerPosition, 0, len(p.Players))
+		for _, pos := range p.Players {
+			players = append(players, PlayerPosition{ID: pos.ID, X: roundFloat(pos.X), Y: roundFloat(pos.Y)})
+		}
+		msg.Payload = AllPlayersPayload{Players: players}
+
+	case GameEvent:
+		// Time is not sent
+		p.Score = int(uint32(p.Score))
+		msg.Payload = GameEvent{
+			Type: p.Type, PlayerID: p.PlayerID, PlayerName: p.PlayerName,
+			TargetID: p.TargetID, TargetName: p.TargetName, Score: p.Score,
+		}
+	}
+	return msg
+}
+
+func compactX(x float64) float64 {
+	return dequantizePosition(quantizePosition(x, WorldWidth), WorldWidth)
+}
+func compactY(y float64) float64 {
+	return dequantizePosition(quantizePosition(y, WorldHeight), WorldHeight)
+}
+func compactRadius(r float64) float64 {
+	return float64(quantizeScaled(r, compactRadiusScale, math.MaxUint8)) / compactRadiusScale
+}
+
+func stringPtr(s string) *string  { return &s }
+func floatPtr(f float64) *float64 { return &f }
+
+// sampleMessages covers every message type and every optional field
+func sampleMessages() map[string]ServerMessage {
+	return map[string]ServerMessage{
+		"welcome": {Type: "welcome", Payload: WelcomePayload{
+			ID: "20260101120000-abc", Name: "Bób", Model: "shark", WorldWidth: WorldWidth, WorldHeight: WorldHeight,
+			MetaToken: "00112233445566778899aabbccddeeff",
+		}},
+		"state": {Type: "state", Payload: GameStatePayload{
+			You: PlayerState{
+				X: 1234.5, Y: 567.25, VelX: -12.5, VelY: 3.75, Rotation: 1.5, Size: 45.5,
+				Score: 320, Alive: true, Seq: 142, PowerupActive: true, PowerupDuration: 2.5, SpawnProtected: true,
+			},
+			Others: []OtherPlayerState{
+				{ID: "p2", Handle: 1, X: 1300, Y: 600, VelX: 1, VelY: -1, Rotation: -0.5, Size: 30},
+				{ID: "p3", Handle: 2, X: 1100, Y: 500, Rotation: 3, Size: 60, PowerupActive: true},
+			},
+			Food:     []FoodState{{ID: 1001, X: 1250, Y: 580, R: 7}, {ID: 1 << 40, X: 0, Y: 4000, R: 3}},
+			Powerups: []PowerupState{{ID: 5, X: 2000, Y: 2000, R: PowerupSize}},
+		}},
+		"state_dead": {Type: "state", Payload: GameStatePayload{
+			You: PlayerState{
+				X: 10, Y: 20, Size: InitialPlayerSize, Score: 5, Seq: 9,
+				KilledBy: stringPtr("Shark Lord"), RespawnIn: floatPtr(2.5),
+			},
+			Others:   []OtherPlayerState{},
+			Food:     []FoodState{},
+			Powerups: []PowerupState{},
+		}},
+		"state_spectating": {Type: "state", Payload: GameStatePayload{
+			You:      PlayerState{X: 2000, Y: 2000, Alive: true, Spectating: true},
+			Others:   []OtherPlayerState{},
+			Food:     []FoodState{},
+			Powerups: []PowerupState{},
+		}},
+		"pong": {Type: "pong"},
+		"leaderboard": {Type: "leaderboard", Payload: []LeaderboardEntry{
+			{Name: "Alice", Score: 1500}, {Name: "Bob", Score: 20},
+		}},
+		"leaderboard_empty": {Type: "leaderboard", Payload: []LeaderboardEntry{}},
+		"playerInfo":        {Type: "playerInfo", Payload: PlayerInfoPayload{ID: "p2", Name: "Bob", Model: "blobfish", Handle: 1}},
+		"allPlayers": {Type: "allPlayers", Payload: AllPlayersPayload{Players: []PlayerPosition{
+			{ID: "p1", X: 1, Y: 2}, {ID: "p2", X: 3999.5, Y: 0.25},
+		}}},
+		"event": {Type: "event", Payload: GameEvent{
+			Type: EventPlayerAte, PlayerID: "p1", PlayerName: "Alice", TargetID: "p2", TargetName: "Bob", Score: 420,
+		}},
+		"event_leader": {Type: "event", Payload: GameEvent{Type: EventNewLeader, PlayerID: "p1", PlayerName: "Alice", Score: 900}},
+		"announcement": {Type: "announcement", Payload: AnnouncementPayload{Message: "Restart in 5 minutes 🐟"}},
+		"nameRejected": {Type: "nameRejected", Payload: NameRejectedPayload{Reason: ErrNameNotAllowed.Error()}},
+		"hello":        {Type: "hello", Payload: HelloPayload{Version: ProtocolV3, Capabilities: ServerCapabilities}},
+	}
+}
+
+// v2Only lists message types protocol v1 has no binary encoding for
+var v2Only = map[string]bool{"event": true, "announcement": true, "nameRejected": true, "hello": true}
+
+func assertRoundTrip(t *testing.T, name string, msg ServerMessage, version uint16) {
+	t.Helper()
+
+	data, err := EncodeBinaryMessageVersion(msg, version)
+	if err != nil {
+		t.Fatalf("%s: encode: %v", name, err)
+	}
+	if data == nil {
+		if version < ProtocolV2 && v2Only[msg.Type] {
+			return // Sent as JSON to v1 clients
+		}
+		t.Fatalf("%s: no binary encoding", name)
+	}
+
+	decoded, n, err := DecodeBinaryMessage(data, version)
+	if err != nil {
+		t.Fatalf("%s v%d: decode: %v", name, version, err)
+	}
+	if n != len(data) {
+		t.Errorf("%s v%d: decoded %d of %d bytes", name, version, n, len(data))
+	}
+
+	want := wireForm(msg, version)
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("%s v%d: round trip mismatch\n got: %+v\nwant: %+v", name, version, decoded, want)
+	}
+}
+
+func TestServerMessageRoundTrip(t *testing.T) {
+	for name, msg := range sampleMessages() {
+		for version := MinProtocolVersion; version <= CurrentProtocolVersion; version++ {
+			assertRoundTrip(t, name, msg, version)
+		}
+	}
+}
+
+func randomString(rng *rand.Rand) string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 _-éü🐟"
+	runes := []rune(alphabet)
+	n := rng.Intn(24)
+	out := make([]rune, n)
+	for i := range out {
+		out[i] = runes[rng.Intn(len(runes))]
+	}
+	return string(out)
+}
+
+func randomCoord(rng *rand.Rand) float64 {
+	return rng.Float64()*WorldWidth*1.2 - WorldWidth*0.1
+}
+
+func randomState(rng *rand.Rand) GameStatePayload {
+	you := PlayerState{
+		X: randomCoord(rng), Y: randomCoord(rng), VelX: rng.NormFloat64() * 200, VelY: rng.NormFloat64() * 200,
+		Rotation: rng.Float64()*2*math.Pi - math.Pi, Size: rng.Float64() * MaxPlayerSize,
+		Score: rng.Intn(1 << 20), Alive: rng.Intn(2) == 0, Seq: rng.Uint32(),
+		PowerupActive: rng.Intn(3) == 0, SpawnProtected: rng.Intn(3) == 0, Spectating: rng.Intn(5) == 0,
+	}
+	if you.PowerupActive {
+		you.PowerupDuration = rng.Float64() * PowerupDuration
+	}
+	if !you.Alive {
+		you.KilledBy = stringPtr(randomString(rng))
+		you.RespawnIn = floatPtr(rng.Float64() * RespawnDelay)
+	}
+
+	state := GameStatePayload{You: you}
+	for i := rng.Intn(20); i > 0; i-- {
+		state.Others = append(state.Others, OtherPlayerState{
+			ID: randomString(rng), Handle: uint16(rng.Intn(1 << 16)), X: randomCoord(rng), Y: randomCoord(rng), VelX: rng.NormFloat64(), VelY: rng.NormFloat64(),
+			Rotation: rng.Float64() * 6, Size: rng.Float64() * 300, PowerupActive: rng.Intn(2) == 0,
+		})
+	}
+	for i := rng.Intn(100); i > 0; i-- {
+		state.Food = append(state.Food, FoodState{ID: rng.Uint64(), X: randomCoord(rng), Y: randomCoord(rng), R: RandomFloat(MinFoodSize, MaxFoodSize)})
+	}
+	for i := rng.Intn(MaxPowerupCount + 1); i > 0; i-- {
+		state.Powerups = append(state.Powerups, PowerupState{ID: rng.Uint64(), X: randomCoord(rng), Y: randomCoord(rng), R: PowerupSize})
+	}
+	return state
+}
+
+// TestServerMessageRoundTripRandom checks encode→decode on randomly generated payloads
+func TestServerMessageRoundTripRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 500; i++ {
+		var entries []LeaderboardEntry
+		for j := rng.Intn(11); j > 0; j-- {
+			entries = append(entries, LeaderboardEntry{Name: randomString(rng), Score: rng.Intn(1 << 30)})
+		}
+		var positions []PlayerPosition
+		for j := rng.Intn(30); j > 0; j-- {
+			positions = append(positions, PlayerPosition{ID: randomString(rng), X: randomCoord(rng), Y: randomCoord(rng)})
+		}
+
+		messages := []ServerMessage{
+			{Type: "state", Payload: randomState(rng)},
+			{Type: "leaderboard", Payload: entries},
+			{Type: "allPlayers", Payload: AllPlayersPayload{Players: positions}},
+			{Type: "welcome", Payload: WelcomePayload{ID: randomString(rng), Name: randomString(rng), Model: randomString(rng), WorldWidth: rng.Float64() * 1e4, WorldHeight: rng.Float64() * 1e4, MetaToken: randomString(rng)}},
+			{Type: "playerInfo", Payload: PlayerInfoPayload{ID: randomString(rng), Name: randomString(rng), Model: randomString(rng), Handle: uint16(rng.Intn(1 << 16))}},
+			{Type: "event", Payload: GameEvent{
+				Type: GameEventType(1 + rng.Intn(int(EventNewLeader))), PlayerID: randomString(rng), PlayerName: randomString(rng),
+				TargetID: randomString(rng), TargetName: randomString(rng), Score: rng.Intn(1 << 30),
+			}},
+		}
+
+		for _, msg := range messages {
+			for version := MinProtocolVersion; version <= CurrentProtocolVersion; version++ {
+				assertRoundTrip(t, msg.Type, msg, version)
+			}
+		}
+	}
+}
+
+func TestDecodeBinaryMessagesSplitsConcatenated(t *testing.T) {
+	samples := sampleMessages()
+	order := []string{"welcome", "state", "pong", "leaderboard", "playerInfo", "allPlayers", "event"}
+
+	var frame []byte
+	for _, name := range order {
+		data, _ := EncodeBinaryMessage(samples[name])
+		frame = append(frame, data...)
+	}
+
+	messages, err := DecodeBinaryMessages(frame, CurrentProtocolVersion)
+	if err != nil {
+		t.Fatalf("DecodeBinaryMessages: %v", err)
+	}
+	if len(messages) != len(order) {
+		t.Fatalf("got %d messages, want %d", len(messages), len(order))
+	}
+	for i, name := range order {
+		if messages[i].Type != samples[name].Type {
+			t.Errorf("message %d: got %s, want %s", i, messages[i].Type, samples[name].Type)
+		}
+	}
+}
+
+func TestDecodeBinaryMessageRejectsTruncated(t *testing.T) {
+	for name, msg := range sampleMessages() {
+		data, _ := EncodeBinaryMessage(msg)
+		for cut := 0; cut < len(data); cut++ {
+			if _, _, err := DecodeBinaryMessage(data[:cut], CurrentProtocolVersion); err == nil {
+				t.Errorf("%s: decoding %d of %d bytes should fail", name, cut, len(data))
+				break
+			}
+		}
+	}
+}
+
+func TestDecodeBinaryMessageRejectsNewTypesInV1(t *testing.T) {
+	data, _ := EncodeBinaryMessage(sampleMessages()["event"])
+	if _, _, err := DecodeBinaryMessage(data, ProtocolV1); err == nil {
+		t.Fatal("event message should not decode as protocol v1")
+	}
+}
+
+func TestClientMessageRoundTrip(t *testing.T) {
+	messages := []ClientMessage{
+		{Type: "input", DirX: 1, DirY: 0, Seq: 1},
+		{Type: "input", DirX: -0.6, DirY: 0.8, Boost: true, Seq: math.MaxUint32},
+		{Type: "input", Seq: 7}, // Stopped
+		{Type: "ping"},
+		{Type: "join", Name: "Bób 🐟", Model: "pufferfish"},
+		{Type: "hello", Version: CurrentProtocolVersion, Capabilities: ServerCapabilities},
+	}
+
+	for _, msg := range messages {
+		data, err := EncodeBinaryClientMessage(msg)
+		if err != nil {
+			t.Fatalf("%s: encode: %v", msg.Type, err)
+		}
+		decoded, err := DecodeBinaryClientMessage(data)
+		if err != nil {
+			t.Fatalf("%s: decode: %v", msg.Type, err)
+		}
+
+		// The direction angle is quantised to 1/65536 of a turn
+		if math.Abs(decoded.DirX-msg.DirX) > 1e-4 || math.Abs(decoded.DirY-msg.DirY) > 1e-4 {
+			t.Errorf("%s: direction (%v, %v) came back as (%v, %v)", msg.Type, msg.DirX, msg.DirY, decoded.DirX, decoded.DirY)
+		}
+		decoded.DirX, decoded.DirY = msg.DirX, msg.DirY
+		if decoded != msg {
+			t.Errorf("%s: round trip mismatch\n got: %+v\nwant: %+v", msg.Type, decoded, msg)
+		}
+	}
+}
+
+func TestBinaryJoinLongNameMatchesJSON(t *testing.T) {
+	name := strings.Repeat("🐟", MaxPlayerNameLen) + " and friends"
+
+	data, err := EncodeBinaryClientMessage(ClientMessage{Type: "join", Name: name, Model: "shark"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary, err := DecodeBinaryClientMessage(data)
+	if err != nil {
+		t.Fatalf("a long binary name should be read, not rejected: %v", err)
+	}
+
+	var text ClientMessage
+	json.Unmarshal([]byte(`{"type":"join","name":"`+name+`","model":"shark"}`), &text)
+
+	fromBinary, _ := SanitizeName(binary.Name)
+	fromJSON, _ := SanitizeName(text.Name)
+	if fromBinary != fromJSON || fromBinary != strings.Repeat("🐟", MaxPlayerNameLen) {
+		t.Errorf("binary join gave %q, JSON join gave %q", fromBinary, fromJSON)
+	}
+}
+
+// goldenFixture describes one file in testdata/protocol. The TypeScript client
+// tests load manifest.json and check their decoder against the same bytes.
+type goldenFixture struct {
+	Name    string        `json:"name"`
+	Version uint16        `json:"version"`
+	File    string        `json:"file"`
+	Message ServerMessage `json:"message"` // Decoded form (float32 precision)
+}
+
+const goldenDir = "testdata/protocol"
+
+// TestGoldenFixtures pins the wire layout. Run `go test -run TestGoldenFixtures -update`
+// after an intentional protocol change and commit the new fixtures.
+func TestGoldenFixtures(t *testing.T) {
+	samples := sampleMessages()
+	var manifest []goldenFixture
+
+	names := make([]string, 0, len(samples))
+	for name := range samples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for version := MinProtocolVersion; version <= CurrentProtocolVersion; version++ {
+			msg := samples[name]
+			data, err := EncodeBinaryMessageVersion(msg, version)
+			if err != nil {
+				t.Fatalf("%s: encode: %v", name, err)
+			}
+			if data == nil {
+				continue
+			}
+
+			fixture := goldenFixture{
+				Name:    name,
+				Version: version,
+				File:    fmt.Sprintf("%s_v%d.bin", name, version),
+				Message: wireForm(msg, version),
+			}
+			manifest = append(manifest, fixture)
+			path := filepath.Join(goldenDir, fixture.File)
+
+			if *updateGolden {
+				if err := os.MkdirAll(goldenDir, 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, data, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+
+			golden, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%s: missing fixture (run with -update): %v", fixture.File, err)
+			}
+			if !bytes.Equal(data, golden) {
+				t.Errorf("%s: encoding changed from golden fixture (run with -update if intentional)", fixture.File)
+			}
+
+			decoded, _, err := DecodeBinaryMessage(golden, version)
+			if err != nil {
+				t.Errorf("%s: decode golden: %v", fixture.File, err)
+			} else if !reflect.DeepEqual(decoded, fixture.Message) {
+				t.Errorf("%s: golden decodes to %+v, want %+v", fixture.File, decoded, fixture.Message)
+			}
+		}
+	}
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifestJSON = append(manifestJSON, '\n')
+	manifestPath := filepath.Join(goldenDir, "manifest.json")
+
+	if *updateGolden {
+		if err := os.WriteFile(manifestPath, manifestJSON, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	golden, err := os.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatalf("missing manifest (run with -update): %v", err)
+	}
+	if !bytes.Equal(manifestJSON, golden) {
+		t.Error("manifest.json is out of date (run with -update)")
+	}
+}
+
+func FuzzDecodeBinaryMessage(f *testing.F) {
+	for _, msg := range sampleMessages() {
+		data, _ := EncodeBinaryMessage(msg)
+		f.Add(data, CurrentProtocolVersion)
+		if v1, _ := EncodeBinaryMessageVersion(msg, ProtocolV1); v1 != nil {
+			f.Add(v1, ProtocolV1)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, version uint16) {
+		if version < MinProtocolVersion || version > CurrentProtocolVersion {
+			return
+		}
+
+		msg, n, err := DecodeBinaryMessage(data, version)
+		if err != nil {
+			return
+		}
+		if n <= 0 || n > len(data) {
+			t.Fatalf("consumed %d of %d bytes", n, len(data))
+		}
+
+		// Whatever decodes must re-encode to a stable form
+		first, err := EncodeBinaryMessageVersion(msg, version)
+		if err != nil || first == nil {
+			t.Fatalf("decoded %s message does not re-encode: %v", msg.Type, err)
+		}
+		again, _, err := DecodeBinaryMessage(first, version)
+		if err != nil {
+			t.Fatalf("re-encoded %s message does not decode: %v", msg.Type, err)
+		}
+		second, _ := EncodeBinaryMessageVersion(again, version)
+		if !bytes.Equal(first, second) {
+			t.Fatalf("%s encoding is not stable", msg.Type)
+		}
+	})
+}
+
+func FuzzDecodeBinaryClientMessage(f *testing.F) {
+	for _, msg := range []ClientMessage{
+		{Type: "input", DirX: 1, Seq: 3, Boost: true},
+		{Type: "ping"},
+		{Type: "join", Name: "Bob", Model: "shark"},
+		{Type: "hello", Version: CurrentProtocolVersion, Capabilities: CapFramed},
+	} {
+		data, _ := EncodeBinaryClientMessage(msg)
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := DecodeBinaryClientMessage(data)
+		if err != nil {
+			return
+		}
+
+		encoded, err := EncodeBinaryClientMessage(msg)
+		if err != nil {
+			t.Fatalf("decoded %s message does not re-encode: %v", msg.Type, err)
+		}
+		again, err := DecodeBinaryClientMessage(encoded)
+		if err != nil {
+			t.Fatalf("re-encoded %s message does not decode: %v", msg.Type, err)
+		}
+		if math.Abs(again.DirX-msg.DirX) > 1e-4 || math.Abs(again.DirY-msg.DirY) > 1e-4 {
+			t.Fatalf("direction drifted: (%v, %v) -> (%v, %v)", msg.DirX, msg.DirY, again.DirX, again.DirY)
+		}
+	})
+}
+
+// benchmarkState is a busy view: 20 fish with UUID-length IDs, 150 pellets and the powerups
+func benchmarkState() GameStatePayload {
+	rng := rand.New(rand.NewSource(2))
+	state := GameStatePayload{
+		You: PlayerState{X: 2000, Y: 2000, VelX: 120, VelY: -40, Rotation: 0.3, Size: 48, Score: 900, Alive: true, Seq: 4000},
+	}
+	for i := 0; i < 20; i++ {
+		state.Others = append(state.Others, OtherPlayerState{
+			ID: "3f2b8c1e-9d4a-4e6b-8f1a-" + fmt.Sprintf("%012d", i), Handle: uint16(i + 1),
+			X: 2000 + rng.Float64()*1200 - 600, Y: 2000 + rng.Float64()*1200 - 600,
+			VelX: rng.NormFloat64() * 150, VelY: rng.NormFloat64() * 150, Rotation: rng.Float64() * 6, Size: 20 + rng.Float64()*80,
+		})
+	}
+	for i := 0; i < 150; i++ {
+		state.Food = append(state.Food, FoodState{
+			ID: uint64(25000 + i*7), X: 2000 + rng.Float64()*1200 - 600, Y: 2000 + rng.Float64()*1200 - 600,
+			R: RandomFloat(MinFoodSize, MaxFoodSize),
+		})
+	}
+	for i := 0; i < MaxPowerupCount; i++ {
+		state.Powerups = append(state.Powerups, PowerupState{ID: uint64(40 + i), X: rng.Float64() * WorldWidth, Y: rng.Float64() * WorldHeight, R: PowerupSize})
+	}
+	return state
+}
+
+// BenchmarkStateEncoding compares the state message across protocol versions; see the
+// bytes/msg metric for the size of each encoding
+func BenchmarkStateEncoding(b *testing.B) {
+	msg := ServerMessage{Type: "state", Payload: benchmarkState()}
+
+	for version := MinProtocolVersion; version <= CurrentProtocolVersion; version++ {
+		b.Run(fmt.Sprintf("v%d", version), func(b *testing.B) {
+			var data []byte
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				data, _ = EncodeBinaryMessageVersion(msg, version)
+			}
+			b.ReportMetric(float64(len(data)), "bytes/msg")
+		})
+	}
+}
+
+func TestCompactStateIsSmaller(t *testing.T) {
+	msg := ServerMessage{Type: "state", Payload: benchmarkState()}
+	legacy, _ := EncodeBinaryMessageVersion(msg, ProtocolV2)
+	compact, _ := EncodeBinaryMessageVersion(msg, ProtocolV3)
+	if len(compact)*2 > len(legacy) {
+		t.Errorf("compact state is %d bytes, want under half of the v2 %d bytes", len(compact), len(legacy))
+	}
+}
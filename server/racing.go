@@ -2,19 +2,31 @@ package main
 
 import (
 	"log"
-	"math"
-	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/gorilla/websocket"
 )
 
 // Racing-specific constants
 const (
-	CyclesPerRace     = 50      // 50 cycles × 2% = 100% to finish
+	CyclesPerRace     = 50      // 50 cycles × 2% = 100% to finish (default distance format)
 	CycleProgress     = 0.02    // Each cycle is 2% progress
 	RaceMaxPlayers    = 8       // Maximum players per race
-	RaceLobbyWaitTime = 10      // Seconds to wait for more players before starting
+	RaceLobbyWaitTime = 10      // Seconds the lobby timer runs once a second player joins
+	RaceLobbyJoinExtension = 5  // A join leaves at least this many seconds on the lobby timer
+	RaceLobbyMaxWait  = 30      // Joins never push the start past this long after the timer began
+	RaceMinPlayers    = 2       // Players needed for the lobby timer to start a race
+	RaceLobbyIdleTime = 60      // Seconds an unready player may sit in a public lobby
+	RaceMaxDuration   = 180     // Seconds before a race ends with everyone left marked DNF
+	RaceStallTimeout  = 30      // Seconds without a state update before a racer is marked DNF
+	MaxCyclesPerUpdate = 20     // Most new cycles one state update can credit; the rest wait for the next
+	RaceResultsKeepTime = 60    // Seconds a finished race is kept before it is reaped
 	RaceCountdownTime = 3       // Seconds of countdown before race starts
+	RaceTickInterval  = 100 * time.Millisecond // How often a race updates and broadcasts
+	LobbyCodeLength   = 6       // Characters in a private lobby code
+	LobbyExpiryTime   = 60      // Seconds an empty private lobby is kept for its players to return
 )
 
 // RaceState represents the current state of a race
@@ -27,23 +39,93 @@ const (
 	RaceStateFinished
 )
 
+// String returns the state as sent to clients
+func (s RaceState) String() string {
+	switch s {
+	case RaceStateLobby:
+		return "lobby"
+	case RaceStateCountdown:
+		return "countdown"
+	case RaceStateRacing:
+		return "racing"
+	case RaceStateFinished:
+		return "finished"
+	default:
+		return "unknown"
+	}
+}
+
 // RacingWorld manages fish racing game sessions
 type RacingWorld struct {
 	Races      map[string]*Race // Map of race ID to race
+	Lobbies    map[string]*Race // Private lobbies by code
 	WaitingLobby *Race          // Current lobby waiting for players
+	Ghosts     *GhostStore      // Finished runs for ghost racing
+	Ratings    *RatingStore     // Skill ratings, updated after each race
+	Queue      *MatchQueue      // Players waiting for a rated match
+	Results    *ResultsStore    // Recent finished races, for the results endpoint
+	Clients    map[string]*RacingClient // Every open connection, racing or not
+	draining   atomic.Bool      // Server is shutting down: no joins or new races
 	mu         sync.RWMutex
 }
 
-// Race represents a single race session
+// Race represents a single race session. Its state is owned by the race goroutine
+// (see racing_actor.go): other goroutines change it with commands and read it through
+// Snapshot.
 type Race struct {
 	ID              string
+	Code            string       // Private lobby join code; empty for public races
+	World           *RacingWorld // Reference to parent world
+	// Owned by the race goroutine
 	State           RaceState
 	Players         map[string]*RacingPlayer
 	StartTime       time.Time
+	EndTime         time.Time
 	CountdownStart  time.Time
+	LobbyTimerStart time.Time // When the lobby timer began; zero while it isn't running
+	LobbyDeadline   time.Time // When the lobby timer starts the race
 	FinishedPlayers []RaceResult
-	World           *RacingWorld // Reference to parent world
-	mu              sync.RWMutex
+	HostID          string          // Private lobbies: player who can kick and start
+	Kicked          map[string]bool // Private lobbies: client IDs the host removed
+	EmptySince      time.Time       // When the last player left, for expiry
+	MaxPlayers      int
+	Format          RaceFormat
+	Lap             int // Elimination: current lap, from 1
+	TotalLaps       int // Elimination: one per racer knocked out
+	StartingRacers  int
+	Ghost           *GhostRun // Solo races: the run being raced against
+	Spectators      map[string]*RacingClient // Watching, not racing
+	lastBroadcast   time.Time
+	// Actor plumbing
+	commands        chan func()
+	stop            chan struct{}
+	stopOnce        sync.Once
+	done            chan struct{} // Closed when the race goroutine exits
+	snapshot        atomic.Pointer[RaceSnapshot]
+}
+
+// RaceSnapshot is a read-only copy of a race, published by the race goroutine after
+// every command and tick
+type RaceSnapshot struct {
+	ID         string
+	Code       string
+	HostID     string
+	State      RaceState
+	MaxPlayers int
+	Format     RaceFormat
+	Players    []RacePlayerSnapshot // Sorted by ID
+	Results    []RaceResult
+	StartTime  time.Time
+	EndTime    time.Time
+	EmptySince time.Time
+	Spectators []*RacingClient
+}
+
+// RacePlayerSnapshot is a read-only copy of a racer
+type RacePlayerSnapshot struct {
+	RacePlayerState
+	MouthCycles int
+	Client      *RacingClient // nil once disconnected
 }
 
 // RacingPlayer represents a player in a race
@@ -56,8 +138,26 @@ type RacingPlayer struct {
 	Progress      float64   // Progress from 0.0 to 1.0 (0% to 100%)
 	FinishTime    float64   // Time taken to finish (in seconds)
 	Finished      bool
+	DNF           bool      // Did not finish: disconnected, stalled or out of time
+	Eliminated    bool      // Knocked out of an elimination race
+	EliminatedLap int
 	Ready         bool      // Player has clicked ready
 	LastUpdate    time.Time // Last time we received a state update
+	JoinedAt      time.Time // For passing on the host role in join order
+	Trace         []GhostSample // Progress over time, saved as a ghost on finishing
+	CycleLog      []CycleSample // Cycles over time, for result statistics
+	Splits        []float64     // Seconds at each SplitInterval of progress
+	Distance      float64   // Distance races: cycles credited, after items
+	MouthOpen     bool
+	MouthOpenSince time.Time
+	// Items (see racing_items.go)
+	Item          string    // Held item, empty if none
+	BubbleCycles  int       // Cycles left that count double
+	SlowCycles    int       // Cycles left that count half
+	NextPickup    int       // Index into ItemPickups
+	NextJellyfish int       // Index into JellyfishPositions
+	Blocked       bool      // Stopped at a jellyfish
+	BlockedAt     time.Time
 }
 
 // RaceResult stores the final result for a player
@@ -68,6 +168,19 @@ type RaceResult struct {
 	FinishTime      float64 `json:"finishTime"`
 	MouthActionsPerMinute float64 `json:"mouthActionsPerMinute"` // Similar to WPM
 	Rank            int     `json:"rank"`
+	Progress        float64 `json:"progress"`      // 1.0 for finishers
+	DNF             bool    `json:"dnf,omitempty"` // Ranked after every finisher, by progress
+	Format          string  `json:"format"`
+	Cycles          int     `json:"cycles"`                  // Time trial score
+	EliminatedLap   int     `json:"eliminatedLap,omitempty"` // Elimination: lap knocked out on; 0 for the winner
+	Rating          float64 `json:"rating,omitempty"`        // Skill rating after this race, if it was rated
+	RatingChange    float64 `json:"ratingChange,omitempty"`
+	// Statistics (see racing_results.go)
+	Splits          []float64 `json:"splits,omitempty"` // Seconds to each 10% of progress; none in time trials
+	PeakRate        float64 `json:"peakRate"`         // Best cycles per minute over 5 seconds
+	AverageRate     float64 `json:"averageRate"`      // Cycles per minute
+	LongestPause    float64 `json:"longestPause"`     // Seconds without a cycle
+	Consistency     float64 `json:"consistency"`      // 0-100, how evenly cycles were spread
 }
 
 // RacingClientMessage represents incoming messages from racing clients
@@ -80,6 +193,12 @@ type RacingClientMessage struct {
 	Ready      bool      `json:"ready,omitempty"`
 	Seq        uint32    `json:"seq,omitempty"`
 	FishState  FishState `json:"fishState,omitempty"`
+	Code       string    `json:"code,omitempty"`       // joinLobby
+	MaxPlayers int       `json:"maxPlayers,omitempty"` // createLobby
+	PlayerID   string    `json:"playerId,omitempty"`   // kick
+	RaceID     string    `json:"raceId,omitempty"`     // spectate
+	Format     RaceFormat `json:"format,omitempty"`    // createLobby, setFormat
+	Ghost      string    `json:"ghost,omitempty"`      // join: "best" or "record" for a solo ghost race
 }
 
 // FishState represents the current state of a fish in racing
@@ -100,16 +219,31 @@ type RaceWelcomePayload struct {
 	Name       string    `json:"name"`
 	Model      string    `json:"model"`
 	RaceState  string    `json:"raceState"`
+	LobbyCode  string    `json:"lobbyCode,omitempty"`
+	HostID     string    `json:"hostId,omitempty"`
+	MaxPlayers int       `json:"maxPlayers"`
+	Format     RaceFormat `json:"format"`
+	Ghost      *GhostInfo `json:"ghost,omitempty"` // Solo ghost races, if a ghost was found
 }
 
 // RaceStatePayload contains the current race state
 type RaceStatePayload struct {
 	RaceState    string              `json:"raceState"`
-	TimeRemaining float64            `json:"timeRemaining,omitempty"` // For countdown/lobby
+	TimeRemaining float64            `json:"timeRemaining,omitempty"` // For countdown, lobby while its timer runs, and time trials
+	Format       RaceFormat          `json:"format"`
+	Lap          int                 `json:"lap,omitempty"`       // Elimination: current lap
+	TotalLaps    int                 `json:"totalLaps,omitempty"` // Elimination
+	Pickups      []float64           `json:"pickups,omitempty"`   // Items: where items are collected, as progress
+	Jellyfish    []float64           `json:"jellyfish,omitempty"` // Items: where jellyfish block the way
 	Players      []RacePlayerState   `json:"players"`
 	YourProgress RacePlayerState     `json:"yourProgress"`
 	ReadyCount   int                 `json:"readyCount"`
 	TotalPlayers int                 `json:"totalPlayers"`
+	MaxPlayers   int                 `json:"maxPlayers"`
+	LobbyCode    string              `json:"lobbyCode,omitempty"`
+	HostID       string              `json:"hostId,omitempty"`
+	Spectators   int                 `json:"spectators,omitempty"`
+	Spectator    bool                `json:"spectator,omitempty"` // Sent to a spectator; yourProgress is empty
 }
 
 // RacePlayerState represents a player's state in the race
@@ -117,20 +251,35 @@ type RacePlayerState struct {
 	ID       string  `json:"id"`
 	Name     string  `json:"name"`
 	Model    string  `json:"model"`
-	Progress float64 `json:"progress"` // 0.0 to 1.0
+	Progress float64 `json:"progress"` // 0.0 to 1.0; relative to the leader in a time trial
+	Cycles   int     `json:"cycles"`
 	Finished bool    `json:"finished"`
 	Ready    bool    `json:"ready"`
+	DNF      bool    `json:"dnf,omitempty"`
+	Eliminated bool  `json:"eliminated,omitempty"`
+	Ghost    bool    `json:"ghost,omitempty"` // A replayed run, not a connected player
+	Item     string  `json:"item,omitempty"`    // Held item
+	Boosted  bool    `json:"boosted,omitempty"` // Bubble cycles left
+	Slowed   bool    `json:"slowed,omitempty"`  // Bitten: slow cycles left
+	Blocked  bool    `json:"blocked,omitempty"` // Stuck at a jellyfish
 }
 
 // RaceResultsPayload contains final race results
 type RaceResultsPayload struct {
+	Format  RaceFormat   `json:"format"`
 	Results []RaceResult `json:"results"`
 }
 
 // NewRacingWorld creates a new racing world
 func NewRacingWorld() *RacingWorld {
 	world := &RacingWorld{
-		Races: make(map[string]*Race),
+		Races:   make(map[string]*Race),
+		Lobbies: make(map[string]*Race),
+		Ghosts:  NewGhostStore(),
+		Ratings: NewRatingStore(),
+		Queue:   &MatchQueue{},
+		Results: NewResultsStore(),
+		Clients: make(map[string]*RacingClient),
 	}
 	
 	// Create initial lobby
@@ -139,468 +288,265 @@ func NewRacingWorld() *RacingWorld {
 	return world
 }
 
-// CreateRace creates a new race session
+// CreateRace creates a new public race session and starts its goroutine. Caller holds rw.mu.
 func (rw *RacingWorld) CreateRace() *Race {
+	return rw.createRace("", RaceMaxPlayers, "", DefaultRaceFormat())
+}
+
+// createRace creates a race and starts its goroutine. Caller holds rw.mu.
+func (rw *RacingWorld) createRace(code string, maxPlayers int, hostID string, format RaceFormat) *Race {
 	race := &Race{
-		ID:      generateClientID(),
-		State:   RaceStateLobby,
-		Players: make(map[string]*RacingPlayer),
-		World:   rw,
+		ID:         NewRaceID(),
+		Code:       code,
+		World:      rw,
+		State:      RaceStateLobby,
+		Players:    make(map[string]*RacingPlayer),
+		HostID:     hostID,
+		Kicked:     make(map[string]bool),
+		Spectators: make(map[string]*RacingClient),
+		MaxPlayers: maxPlayers,
+		Format:     format,
+		commands:   make(chan func()),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
 	}
+	race.publish()
+	go race.run()
 	
 	rw.Races[race.ID] = race
 	return race
 }
 
-// JoinRace adds a player to the waiting lobby
-func (rw *RacingWorld) JoinRace(client *RacingClient, playerName, model string) *Race {
-	rw.mu.Lock()
-	
-	race := rw.WaitingLobby
-	race.mu.Lock()
-	
-	// Create player
-	player := &RacingPlayer{
-		ID:     client.ID,
-		Name:   playerName,
-		Model:  model,
-		Client: client,
-	}
-	
-	race.Players[client.ID] = player
-	
-	log.Printf("Player %s joined race %s (%d/%d players)", playerName, race.ID, len(race.Players), RaceMaxPlayers)
-	
-	// Unlock before broadcasting to avoid deadlock
-	race.mu.Unlock()
-	rw.mu.Unlock()
-	
-	// Broadcast state to all players so they see the new player
-	race.BroadcastState()
-	
-	return race
+// GetRace returns the race with the given ID, or nil
+func (rw *RacingWorld) GetRace(id string) *Race {
+	rw.mu.RLock()
+	defer rw.mu.RUnlock()
+	return rw.Races[id]
 }
 
-// StartLobbyCountdown waits for more players or starts the race
-func (r *Race) StartLobbyCountdown() {
-	time.Sleep(time.Duration(RaceLobbyWaitTime) * time.Second)
-	
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	
-	// Only start if we're still in lobby and have players
-	if r.State == RaceStateLobby && len(r.Players) > 0 {
-		r.StartRaceCountdown()
-	}
-}
+// ListRaces returns a snapshot of all races
+func (rw *RacingWorld) ListRaces() []*Race {
+	rw.mu.RLock()
+	defer rw.mu.RUnlock()
 
-// HandlePlayerReady marks a player as ready and starts countdown if all ready
-func (r *Race) HandlePlayerReady(playerID string) {
-	r.mu.Lock()
-	
-	// Only handle ready in lobby state
-	if r.State != RaceStateLobby {
-		r.mu.Unlock()
-		return
-	}
-	
-	player, exists := r.Players[playerID]
-	if !exists {
-		r.mu.Unlock()
-		return
-	}
-	
-	player.Ready = true
-	log.Printf("Player %s is ready (%s)", player.Name, r.ID)
-	
-	// Check if all players are ready
-	allReady := true
-	readyCount := 0
-	for _, p := range r.Players {
-		if p.Ready {
-			readyCount++
-		} else {
-			allReady = false
-		}
-	}
-	
-	log.Printf("Race %s: %d/%d players ready", r.ID, readyCount, len(r.Players))
-	
-	// Start race if all players are ready (minimum 1 player)
-	if allReady && len(r.Players) > 0 {
-		log.Printf("All players ready! Starting race %s", r.ID)
-		r.mu.Unlock() // Unlock before starting countdown to avoid deadlock
-		r.StartRaceCountdown()
-	} else {
-		r.mu.Unlock() // Unlock before broadcasting
-		// Broadcast updated state to show ready status
-		r.BroadcastState()
+	races := make([]*Race, 0, len(rw.Races))
+	for _, race := range rw.Races {
+		races = append(races, race)
 	}
+	return races
 }
 
-// StartRaceCountdown begins the countdown before the race starts
-func (r *Race) StartRaceCountdown() {
-	r.State = RaceStateCountdown
-	r.CountdownStart = time.Now()
-	
-	log.Printf("Race %s starting countdown with %d players", r.ID, len(r.Players))
-	
-	// Create a new waiting lobby for future joiners
-	if r.World != nil {
-		go func() {
-			r.World.mu.Lock()
-			r.World.WaitingLobby = r.World.CreateRace()
-			log.Printf("Created new waiting lobby: %s (old race %s starting countdown)", r.World.WaitingLobby.ID, r.ID)
-			r.World.mu.Unlock()
-		}()
-	}
-	
-	// Broadcast countdown state to all players
-	r.BroadcastState()
-	
-	go func() {
-		// Broadcast updates every second during countdown
-		ticker := time.NewTicker(time.Second)
-		defer ticker.Stop()
-		
-		for i := 0; i < RaceCountdownTime; i++ {
-			<-ticker.C
-			// Broadcast updated countdown
-			if r.State == RaceStateCountdown {
-				r.BroadcastState()
-			}
-		}
-		
-		r.StartRace()
-	}()
+// AddClient registers a new connection, before it has joined or spectated a race
+func (rw *RacingWorld) AddClient(client *RacingClient) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.Clients[client.ID] = client
 }
 
-// StartRace begins the actual race
-func (r *Race) StartRace() {
-	r.mu.Lock()
-	r.State = RaceStateRacing
-	r.StartTime = time.Now()
-	r.mu.Unlock()
-	
-	log.Printf("Race %s started!", r.ID)
-	
-	// Broadcast racing state to all players
-	r.BroadcastState()
-	
-	// Start race update loop
-	go r.RaceLoop()
+// RemoveClient forgets a closed connection
+func (rw *RacingWorld) RemoveClient(client *RacingClient) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	delete(rw.Clients, client.ID)
 }
 
-// RaceLoop updates race state and broadcasts at regular intervals
-func (r *Race) RaceLoop() {
-	ticker := time.NewTicker(100 * time.Millisecond) // Broadcast every 100ms
-	defer ticker.Stop()
-	
-	for {
-		<-ticker.C
-		
-		// Check if race should end
-		r.mu.Lock()
-		if r.State != RaceStateRacing {
-			r.mu.Unlock()
-			break
-		}
-        
-		// Auto-finish players who stall near the end
-		for _, player := range r.Players {
-			if !player.Finished && player.Progress >= 0.96 && !player.LastUpdate.IsZero() {
-				if time.Since(player.LastUpdate) > 3*time.Second {
-					player.Finished = true
-					player.FinishTime = time.Since(r.StartTime).Seconds()
-					log.Printf("Auto-finishing player %s at %.0f%% after stall", player.ID, player.Progress*100)
-					r.FinishedPlayers = append(r.FinishedPlayers, RaceResult{
-						PlayerID:   player.ID,
-						Name:       player.Name,
-						Model:      player.Model,
-						FinishTime: player.FinishTime,
-						MouthActionsPerMinute: (float64(player.MouthCycles*2) / player.FinishTime) * 60.0,
-					})
-				}
-			}
-		}
+// FindClient returns the connection with the given ID, whether it is racing,
+// spectating or hasn't joined a race yet
+func (rw *RacingWorld) FindClient(id string) *RacingClient {
+	rw.mu.RLock()
+	client := rw.Clients[id]
+	rw.mu.RUnlock()
+	if client != nil {
+		return client
+	}
 
-		// Check if all players finished
-		allFinished := true
-		for _, player := range r.Players {
-			if !player.Finished {
-				allFinished = false
-				break
+	for _, race := range rw.ListRaces() {
+		snap := race.Snapshot()
+		for _, player := range snap.Players {
+			if player.ID == id && player.Client != nil {
+				return player.Client
 			}
 		}
-		r.mu.Unlock()
-		
-		if allFinished && r.HasPlayers() {
-			r.EndRace()
-			break
+		for _, spectator := range snap.Spectators {
+			if spectator.ID == id {
+				return spectator
+			}
 		}
-		
-		r.BroadcastState()
 	}
+	return nil
 }
 
-// HasPlayers checks if race has any players
-func (r *Race) HasPlayers() bool {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-	return len(r.Players) > 0
-}
+// ClientsFrom returns every open connection from ip
+func (rw *RacingWorld) ClientsFrom(ip string) []*RacingClient {
+	rw.mu.RLock()
+	defer rw.mu.RUnlock()
 
-// UpdateRace is deprecated - use RaceLoop instead
-func (r *Race) UpdateRace(dt float64) {
-	// This function is no longer used
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	
-	// Check if all players finished (progress is now updated by HandleFishStateUpdate)
-	allFinished := true
-	for _, player := range r.Players {
-		if !player.Finished {
-			allFinished = false
-			break
+	var clients []*RacingClient
+	for _, client := range rw.Clients {
+		if client.IP == ip {
+			clients = append(clients, client)
 		}
 	}
-	
-	// If all players finished, end race
-	if allFinished && len(r.Players) > 0 {
-		r.EndRace()
+	return clients
+}
+
+// RenameMuted renames muted racers in every race, returning how many were renamed
+func (rw *RacingWorld) RenameMuted() int {
+	renamed := 0
+	for _, race := range rw.ListRaces() {
+		renamed += race.RenameMuted()
 	}
-	
-	// Broadcast state to all players
-	r.BroadcastState()
+	return renamed
 }
 
-// EndRace finalizes the race and sends results
-func (r *Race) EndRace() {
-	r.State = RaceStateFinished
-	
-	// Sort results by finish time
-	sort.Slice(r.FinishedPlayers, func(i, j int) bool {
-		return r.FinishedPlayers[i].FinishTime < r.FinishedPlayers[j].FinishTime
+// BroadcastAnnouncement sends a server announcement to every racer
+func (rw *RacingWorld) BroadcastAnnouncement(message string) {
+	rw.broadcastAll(RacingServerMessage{
+		Type:    "announcement",
+		Payload: AnnouncementPayload{Message: message},
 	})
-	
-	// Update ranks
-	for i := range r.FinishedPlayers {
-		r.FinishedPlayers[i].Rank = i + 1
-	}
-	
-	log.Printf("Race %s finished!", r.ID)
-	
-	// Broadcast results
-	r.BroadcastResults()
 }
 
-// BroadcastState sends current race state to all players
-func (r *Race) BroadcastState() {
-	r.mu.RLock()
-	
-	// Collect data while holding the read lock
-	raceState := r.StateString()
-	countdownStart := r.CountdownStart
-	state := r.State
-	players := make([]*RacingPlayer, 0, len(r.Players))
-	
-	// Collect players in sorted order by ID for consistency
-	for _, p := range r.Players {
-		players = append(players, p)
-	}
-	sort.Slice(players, func(i, j int) bool {
-		return players[i].ID < players[j].ID
+// BroadcastShutdown warns every racer that the server is going away
+func (rw *RacingWorld) BroadcastShutdown(payload ShutdownPayload) {
+	rw.broadcastAll(RacingServerMessage{
+		Type:    "serverShutdown",
+		Payload: payload,
 	})
-	
-	// Build player states in sorted order
-	playersData := make([]RacePlayerState, 0, len(players))
-	for _, p := range players {
-		playersData = append(playersData, RacePlayerState{
-			ID:       p.ID,
-			Name:     p.Name,
-			Model:    p.Model,
-			Progress: p.Progress,
-			Finished: p.Finished,
-			Ready:    p.Ready,
-		})
-	}
-	
-	r.mu.RUnlock()
-	
-	// Send messages outside the lock
-	for i, player := range players {
-		if player.Client == nil {
-			continue
-		}
-		
-		// Calculate time remaining for countdown
-		var timeRemaining float64
-		if state == RaceStateCountdown {
-			elapsed := time.Since(countdownStart).Seconds()
-			timeRemaining = math.Max(0, float64(RaceCountdownTime)-elapsed)
-		} else if state == RaceStateLobby {
-			timeRemaining = float64(RaceLobbyWaitTime)
-		}
-		
-		// Count ready players
-		readyCount := 0
-		for _, p := range playersData {
-			if p.Ready {
-				readyCount++
+}
+
+// broadcastAll sends msg to every racer in every race
+func (rw *RacingWorld) broadcastAll(msg RacingServerMessage) {
+	for _, race := range rw.ListRaces() {
+		snap := race.Snapshot()
+		for _, player := range snap.Players {
+			if player.Client != nil {
+				player.Client.SendMessage(msg)
 			}
 		}
-		
-		payload := RaceStatePayload{
-			RaceState:     raceState,
-			TimeRemaining: timeRemaining,
-			Players:       playersData,
-			YourProgress:  playersData[i],
-			ReadyCount:    readyCount,
-			TotalPlayers:  len(playersData),
+		for _, spectator := range snap.Spectators {
+			spectator.SendMessage(msg)
 		}
-		
-		player.Client.SendMessage(RacingServerMessage{
-			Type:    "raceState",
-			Payload: payload,
-		})
 	}
 }
 
-// BroadcastResults sends final results to all players
-func (r *Race) BroadcastResults() {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-	
-	payload := RaceResultsPayload{
-		Results: r.FinishedPlayers,
-	}
-	
-	for _, player := range r.Players {
-		if player.Client != nil {
-			player.Client.SendMessage(RacingServerMessage{
-				Type:    "raceResults",
-				Payload: payload,
-			})
-		}
-	}
+// Drain stops players joining and lobbies starting new races
+func (rw *RacingWorld) Drain() {
+	rw.draining.Store(true)
 }
 
-// StateString returns the race state as a string
-func (r *Race) StateString() string {
-	switch r.State {
-	case RaceStateLobby:
-		return "lobby"
-	case RaceStateCountdown:
-		return "countdown"
-	case RaceStateRacing:
-		return "racing"
-	case RaceStateFinished:
-		return "finished"
-	default:
-		return "unknown"
-	}
+// IsDraining reports whether the server is shutting down. Lock-free, so race
+// goroutines can call it.
+func (rw *RacingWorld) IsDraining() bool {
+	return rw != nil && rw.draining.Load()
 }
 
-// HandleFishStateUpdate processes a fish state update from a client
-func (r *Race) HandleFishStateUpdate(playerID string, state FishState) {
-	defer func() {
-		if err := recover(); err != nil {
-			log.Printf("PANIC in HandleFishStateUpdate: %v", err)
+// RunningRaces returns how many races are counting down or racing
+func (rw *RacingWorld) RunningRaces() int {
+	running := 0
+	for _, race := range rw.ListRaces() {
+		if state := race.Snapshot().State; state == RaceStateCountdown || state == RaceStateRacing {
+			running++
 		}
-	}()
-
-	log.Printf("HandleFishStateUpdate START for player %s", playerID)
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	log.Printf("HandleFishStateUpdate: acquired lock")
-
-	// Ignore updates on finished races
-	if r.State == RaceStateFinished {
-		log.Printf("Ignoring state update for player %s - race %s is finished", playerID, r.ID)
-		return
 	}
+	return running
+}
 
-	player, ok := r.Players[playerID]
-	if !ok {
-		log.Printf("Player %s not found in race %s", playerID, r.ID)
-		return
+// ReapRaces removes races nobody needs any more and stops their goroutines: finished
+// races once everyone has left or RaceResultsKeepTime after they end, and public
+// lobbies that were replaced as the waiting lobby and have since emptied. Returns how
+// many were removed.
+func (rw *RacingWorld) ReapRaces(now time.Time) int {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	reaped := 0
+	for _, race := range rw.Races {
+		snap := race.Snapshot()
+		finished := snap.State == RaceStateFinished &&
+			(snap.Connected() == 0 || now.Sub(snap.EndTime) >= RaceResultsKeepTime*time.Second)
+		abandoned := snap.State == RaceStateLobby && snap.Code == "" && race != rw.WaitingLobby && len(snap.Players) == 0
+
+		if finished || abandoned {
+			rw.removeRace(race)
+			reaped++
+		}
 	}
+	if reaped > 0 {
+		log.Printf("Reaped %d races (%d remaining)", reaped, len(rw.Races))
+	}
+	return reaped
+}
 
-	log.Printf("HandleFishStateUpdate: found player, current cycles=%d, new cycles=%d", player.MouthCycles, state.MouthCycles)
-	prevCycles := player.MouthCycles
-	
-	// Update mouth cycles from client
-	player.MouthCycles = state.MouthCycles
-	player.LastUpdate = time.Now()
-	log.Printf("Received state update for %s: cycles=%d (was %d), race state: %s", playerID, state.MouthCycles, prevCycles, r.StateString())
-
-	// Calculate progress: each cycle is 2%
-	player.Progress = float64(player.MouthCycles) * CycleProgress
+// ForceEndRunning ends every race that is still counting down or racing
+func (rw *RacingWorld) ForceEndRunning() {
+	for _, race := range rw.ListRaces() {
+		race.ForceEnd()
+	}
+}
 
-	// If cycles exceed target, clamp to finish
-	if player.MouthCycles >= CyclesPerRace {
-		player.Progress = 1.0
+// CloseAll closes every racer's connection with the given close code and returns
+// how many were closed
+func (rw *RacingWorld) CloseAll(code int, reason string) int {
+	conns := make([]*websocket.Conn, 0)
+	for _, race := range rw.ListRaces() {
+		snap := race.Snapshot()
+		for _, player := range snap.Players {
+			if player.Client != nil {
+				conns = append(conns, player.Client.Conn)
+			}
+		}
+		for _, spectator := range snap.Spectators {
+			conns = append(conns, spectator.Conn)
+		}
 	}
 
-	// Cap progress at 100%
-	if player.Progress > 1.0 {
-		player.Progress = 1.0
+	for _, conn := range conns {
+		closeWithCode(conn, code, reason)
 	}
+	return len(conns)
+}
 
-	log.Printf("HandleFishStateUpdate: calculated progress=%.2f%%", player.Progress*100)
-
-	// Check if player just finished
-	if player.Progress >= 1.0 && !player.Finished {
-		player.Finished = true
-		player.FinishTime = time.Since(r.StartTime).Seconds()
-		log.Printf("Player %s finished! Time: %.2fs, Cycles: %d", playerID, player.FinishTime, player.MouthCycles)
-
-		// Add to results
-		r.FinishedPlayers = append(r.FinishedPlayers, RaceResult{
-			PlayerID:   playerID,
-			Name:       player.Name,
-			Model:      player.Model,
-			FinishTime: player.FinishTime,
-			MouthActionsPerMinute: (float64(player.MouthCycles*2) / player.FinishTime) * 60.0,
-		})
+// JoinRace adds a player to the waiting lobby, numbering duplicate names. A full
+// lobby is left to start on its own and a new one is opened.
+func (rw *RacingWorld) JoinRace(client *RacingClient, playerName, model string) (*Race, RaceWelcomePayload) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
 
-		// Check if all players finished
-		allFinished := true
-		for _, p := range r.Players {
-			if !p.Finished {
-				allFinished = false
-				break
+	for {
+		race := rw.WaitingLobby
+		var welcome RaceWelcomePayload
+		joined := false
+		race.call(func() {
+			if race.State != RaceStateLobby || len(race.Players) >= race.MaxPlayers {
+				return
 			}
+			player := race.addPlayer(client, playerName, model)
+			race.extendLobbyTimer(time.Now())
+			log.Printf("Player %s joined race %s (%d/%d players)", player.Name, race.ID, len(race.Players), race.MaxPlayers)
+
+			// Broadcast state to all players so they see the new player
+			race.BroadcastState()
+			welcome = race.welcome(player)
+			joined = true
+		})
+		if joined {
+			return race, welcome
 		}
 
-		if allFinished {
-			// Do not mutate state here; RaceLoop will finalize
-			log.Printf("All players finished! Signaling RaceLoop to finalize race %s", r.ID)
-		}
+		// Full or already starting: open a new lobby
+		rw.WaitingLobby = rw.CreateRace()
+		log.Printf("Lobby %s unavailable, created new waiting lobby: %s", race.ID, rw.WaitingLobby.ID)
 	}
+}
 
-	log.Printf("HandleFishStateUpdate: releasing lock")
-}// DisconnectPlayer removes a player from the race
-func (r *Race) DisconnectPlayer(playerID string) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	
-	if player, exists := r.Players[playerID]; exists {
-		log.Printf("Player %s disconnected from race %s (state: %s)", player.Name, r.ID, r.StateString())
-		delete(r.Players, playerID)
-		
-		// Clean up finished or empty races
-		if len(r.Players) == 0 {
-			if r.State == RaceStateFinished {
-				log.Printf("Race %s finished and empty - cleaning up", r.ID)
-				if r.World != nil {
-					r.World.mu.Lock()
-					delete(r.World.Races, r.ID)
-					r.World.mu.Unlock()
-				}
-			} else if r.State == RaceStateLobby || r.State == RaceStateCountdown {
-				log.Printf("Race %s empty in %s state - will clean up", r.ID, r.StateString())
-			}
-		}
+// replaceWaitingLobby opens a new waiting lobby if race still is the waiting lobby
+func (rw *RacingWorld) replaceWaitingLobby(race *Race) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.WaitingLobby == race {
+		rw.WaitingLobby = rw.CreateRace()
+		log.Printf("Created new waiting lobby: %s (old race %s starting countdown)", rw.WaitingLobby.ID, race.ID)
 	}
 }
@@ -0,0 +1,693 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+// Each race is driven by one goroutine, run, which owns every field below the "Owned
+// by the race goroutine" line in Race. Other goroutines never touch that state
+// directly: they send commands with call, which runs a function on the race goroutine
+// and waits for it, and read the latest Snapshot. Timers (the lobby timer, countdown
+// and race updates) run from the same goroutine's ticker, so nothing in a race needs
+// a lock.
+//
+// The race goroutine must never block on the racing world's lock, since JoinRace
+// holds it while calling into a race; anything that needs it runs in a new goroutine.
+
+// run is the race goroutine. It exits when Stop is called.
+func (r *Race) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(RaceTickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case command := <-r.commands:
+			r.safely(command)
+		case now := <-ticker.C:
+			r.safely(func() { r.tick(now) })
+		}
+		r.publish()
+	}
+}
+
+// call runs fn on the race goroutine and waits for it to finish. Returns false
+// without running fn if the race has been stopped.
+func (r *Race) call(fn func()) bool {
+	finished := make(chan struct{})
+	command := func() {
+		defer close(finished)
+		fn()
+	}
+
+	select {
+	case r.commands <- command:
+		<-finished
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+// Stop ends the race goroutine. Commands sent afterwards are ignored.
+func (r *Race) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
+}
+
+// safely runs fn, logging rather than crashing the server if it panics
+func (r *Race) safely(fn func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("PANIC in race %s: %v", r.ID, err)
+		}
+	}()
+	fn()
+}
+
+// Snapshot returns the race as of its last command or tick. Safe from any goroutine.
+func (r *Race) Snapshot() *RaceSnapshot {
+	return r.snapshot.Load()
+}
+
+// Connected returns how many racers still have a connection
+func (s *RaceSnapshot) Connected() int {
+	connected := 0
+	for _, player := range s.Players {
+		if player.Client != nil {
+			connected++
+		}
+	}
+	return connected
+}
+
+// publish stores a fresh snapshot for other goroutines
+func (r *Race) publish() {
+	snap := &RaceSnapshot{
+		ID:         r.ID,
+		Code:       r.Code,
+		HostID:     r.HostID,
+		State:      r.State,
+		MaxPlayers: r.MaxPlayers,
+		Format:     r.Format,
+		Players:    make([]RacePlayerSnapshot, 0, len(r.Players)),
+		Results:    append([]RaceResult(nil), r.FinishedPlayers...),
+		StartTime:  r.StartTime,
+		EndTime:    r.EndTime,
+		EmptySince: r.EmptySince,
+		Spectators: make([]*RacingClient, 0, len(r.Spectators)),
+	}
+	for _, spectator := range r.Spectators {
+		snap.Spectators = append(snap.Spectators, spectator)
+	}
+	for _, player := range r.sortedPlayers() {
+		snap.Players = append(snap.Players, RacePlayerSnapshot{
+			RacePlayerState: player.state(),
+			MouthCycles:     player.MouthCycles,
+			Client:          player.Client,
+		})
+	}
+	r.snapshot.Store(snap)
+}
+
+// sortedPlayers returns the racers in ID order, for consistent broadcasts
+func (r *Race) sortedPlayers() []*RacingPlayer {
+	players := make([]*RacingPlayer, 0, len(r.Players))
+	for _, p := range r.Players {
+		players = append(players, p)
+	}
+	sort.Slice(players, func(i, j int) bool {
+		return players[i].ID < players[j].ID
+	})
+	return players
+}
+
+// state returns what clients see of the racer
+func (p *RacingPlayer) state() RacePlayerState {
+	return RacePlayerState{
+		ID:         p.ID,
+		Name:       p.Name,
+		Model:      p.Model,
+		Progress:   p.Progress,
+		Cycles:     p.MouthCycles,
+		Finished:   p.Finished,
+		Ready:      p.Ready,
+		DNF:        p.DNF,
+		Eliminated: p.Eliminated,
+		Item:       p.Item,
+		Boosted:    p.BubbleCycles > 0,
+		Slowed:     p.SlowCycles > 0,
+		Blocked:    p.Blocked,
+	}
+}
+
+// tick advances whichever timer the race's state has running
+func (r *Race) tick(now time.Time) {
+	switch r.State {
+	case RaceStateLobby:
+		r.lobbyTick(now)
+	case RaceStateCountdown:
+		r.countdownTick(now)
+	case RaceStateRacing:
+		r.raceTick(now)
+	}
+}
+
+// addPlayer adds client to the race under a unique name
+func (r *Race) addPlayer(client *RacingClient, playerName, model string) *RacingPlayer {
+	playerName = UniqueName(playerName, func(skeleton string) bool {
+		for _, other := range r.Players {
+			if NameSkeleton(other.Name) == skeleton {
+				return true
+			}
+		}
+		return false
+	})
+
+	player := &RacingPlayer{
+		ID:       client.ID,
+		Name:     playerName,
+		Model:    model,
+		Client:   client,
+		JoinedAt: time.Now(),
+	}
+	r.Players[client.ID] = player
+	r.EmptySince = time.Time{}
+	return player
+}
+
+// welcome builds the welcome message for a player who just joined
+func (r *Race) welcome(player *RacingPlayer) RaceWelcomePayload {
+	return RaceWelcomePayload{
+		PlayerID:   player.ID,
+		RaceID:     r.ID,
+		Name:       player.Name,
+		Model:      player.Model,
+		RaceState:  r.State.String(),
+		LobbyCode:  r.Code,
+		HostID:     r.HostID,
+		MaxPlayers: r.MaxPlayers,
+		Format:     r.Format,
+	}
+}
+
+// extendLobbyTimer starts the lobby timer when a second player joins, and otherwise
+// leaves at least RaceLobbyJoinExtension on it for players who just arrived, never
+// past RaceLobbyMaxWait
+func (r *Race) extendLobbyTimer(now time.Time) {
+	if r.State != RaceStateLobby || r.Code != "" || len(r.Players) < 2 {
+		return
+	}
+
+	if r.LobbyTimerStart.IsZero() {
+		r.LobbyTimerStart = now
+		r.LobbyDeadline = now.Add(RaceLobbyWaitTime * time.Second)
+		log.Printf("Lobby timer started for race %s", r.ID)
+		return
+	}
+
+	deadline := now.Add(RaceLobbyJoinExtension * time.Second)
+	if latest := r.LobbyTimerStart.Add(RaceLobbyMaxWait * time.Second); deadline.After(latest) {
+		deadline = latest
+	}
+	if deadline.After(r.LobbyDeadline) {
+		r.LobbyDeadline = deadline
+	}
+}
+
+// lobbyTick runs a public lobby's timer: it removes players who have sat unready for
+// RaceLobbyIdleTime, stops the timer if fewer than two players remain, and starts the
+// race with whoever is present once the deadline passes with at least RaceMinPlayers.
+func (r *Race) lobbyTick(now time.Time) {
+	if r.Code != "" {
+		return
+	}
+
+	idle := 0
+	for id, player := range r.Players {
+		if !player.Ready && now.Sub(player.JoinedAt) >= RaceLobbyIdleTime*time.Second {
+			delete(r.Players, id)
+			idle++
+			log.Printf("Removed idle player %s from lobby %s", player.Name, r.ID)
+			if player.Client != nil {
+				player.Client.SendMessage(RacingServerMessage{
+					Type:    "kicked",
+					Payload: KickedPayload{RaceID: r.ID, Reason: "idle"},
+				})
+			}
+		}
+	}
+	if idle > 0 && len(r.Players) == 0 {
+		r.EmptySince = now
+	}
+
+	if len(r.Players) < 2 {
+		r.LobbyTimerStart = time.Time{}
+		r.LobbyDeadline = time.Time{}
+	}
+
+	switch {
+	case !r.LobbyDeadline.IsZero() && !now.Before(r.LobbyDeadline) &&
+		len(r.Players) >= RaceMinPlayers && !r.World.IsDraining():
+		log.Printf("Lobby timer expired, starting race %s", r.ID)
+		r.startCountdown(now)
+	case idle > 0 || (!r.LobbyDeadline.IsZero() && now.Sub(r.lastBroadcast) >= time.Second):
+		// Keep the visible countdown moving
+		r.BroadcastState()
+	}
+}
+
+// HandlePlayerReady marks a player as ready and starts countdown if all ready
+func (r *Race) HandlePlayerReady(playerID string) {
+	r.call(func() {
+		// Only handle ready in lobby state
+		if r.State != RaceStateLobby {
+			return
+		}
+
+		player, exists := r.Players[playerID]
+		if !exists {
+			return
+		}
+
+		player.Ready = true
+		log.Printf("Player %s is ready (%s)", player.Name, r.ID)
+
+		// Check if all players are ready
+		allReady := true
+		readyCount := 0
+		for _, p := range r.Players {
+			if p.Ready {
+				readyCount++
+			} else {
+				allReady = false
+			}
+		}
+
+		log.Printf("Race %s: %d/%d players ready", r.ID, readyCount, len(r.Players))
+
+		// Start race if all players are ready (minimum 1 player), unless the server is shutting
+		// down. Private lobbies wait for their host.
+		if allReady && len(r.Players) > 0 && r.Code == "" && !r.World.IsDraining() {
+			log.Printf("All players ready! Starting race %s", r.ID)
+			r.startCountdown(time.Now())
+		} else {
+			// Broadcast updated state to show ready status
+			r.BroadcastState()
+		}
+	})
+}
+
+// startCountdown begins the countdown before the race starts
+func (r *Race) startCountdown(now time.Time) {
+	r.State = RaceStateCountdown
+	r.CountdownStart = now
+	r.LobbyTimerStart = time.Time{}
+	r.LobbyDeadline = time.Time{}
+
+	log.Printf("Race %s starting countdown with %d players", r.ID, len(r.Players))
+
+	// Create a new waiting lobby for future joiners. That needs the world lock, which
+	// JoinRace may hold while waiting on this goroutine.
+	if r.World != nil && r.Code == "" {
+		go r.World.replaceWaitingLobby(r)
+	}
+
+	// Broadcast countdown state to all players
+	r.BroadcastState()
+}
+
+// countdownTick starts the race once the countdown is over, broadcasting each second
+func (r *Race) countdownTick(now time.Time) {
+	if now.Sub(r.CountdownStart) >= RaceCountdownTime*time.Second {
+		r.startRace(now)
+	} else if now.Sub(r.lastBroadcast) >= time.Second {
+		r.BroadcastState()
+	}
+}
+
+// startRace begins the actual race
+func (r *Race) startRace(now time.Time) {
+	r.State = RaceStateRacing
+	r.StartTime = now
+	r.StartingRacers = len(r.Players)
+	if r.Format.Type == RaceFormatElimination {
+		r.Lap = 1
+		r.TotalLaps = max(1, r.StartingRacers-1)
+	}
+
+	log.Printf("Race %s started: %s", r.ID, r.Format)
+
+	// Broadcast racing state to all players
+	r.BroadcastState()
+}
+
+// raceTick updates a running race and broadcasts it. It ends the race once every
+// racer has finished or dropped out, after RaceMaxDuration, or when a time trial's
+// clock runs out.
+func (r *Race) raceTick(now time.Time) {
+	elapsed := now.Sub(r.StartTime)
+
+	if r.Format.Type == RaceFormatTimeTrial {
+		// Everyone races the clock, however long they pause
+		if elapsed >= time.Duration(r.Format.Duration)*time.Second {
+			log.Printf("Race %s time trial is over", r.ID)
+			r.endRace(now)
+		} else {
+			r.BroadcastState()
+		}
+		return
+	}
+
+	for _, player := range r.activeRacers() {
+		if r.Format.Items {
+			r.checkJellyfish(player, now)
+		}
+
+		// Auto-finish players who stall near the end of a distance race
+		if r.Format.Type == RaceFormatDistance && player.Progress >= 0.96 && !player.LastUpdate.IsZero() {
+			if now.Sub(player.LastUpdate) > 3*time.Second {
+				log.Printf("Auto-finishing player %s at %.0f%% after stall", player.ID, player.Progress*100)
+				r.finishRacer(player, elapsed.Seconds())
+			}
+			continue
+		}
+
+		// Anyone else who has gone quiet is out
+		lastActive := player.LastUpdate
+		if lastActive.IsZero() {
+			lastActive = r.StartTime
+		}
+		if now.Sub(lastActive) > RaceStallTimeout*time.Second {
+			player.DNF = true
+			log.Printf("Player %s stalled at %.0f%% in race %s - DNF", player.ID, player.Progress*100, r.ID)
+		}
+	}
+
+	// Racers dropping out can end an elimination lap
+	if r.Format.Type == RaceFormatElimination {
+		r.checkEliminations(elapsed.Seconds())
+	}
+
+	allDone := len(r.activeRacers()) == 0
+
+	if elapsed >= RaceMaxDuration*time.Second {
+		log.Printf("Race %s reached the %ds limit", r.ID, RaceMaxDuration)
+		r.endRace(now)
+	} else if allDone {
+		r.endRace(now)
+	} else {
+		r.BroadcastState()
+	}
+}
+
+// newRaceResult builds a player's result after elapsed seconds of racing
+func newRaceResult(player *RacingPlayer, format RaceFormat, elapsed float64) RaceResult {
+	result := RaceResult{
+		PlayerID:      player.ID,
+		Name:          player.Name,
+		Model:         player.Model,
+		Progress:      player.Progress,
+		DNF:           player.DNF,
+		Format:        format.Type,
+		Cycles:        player.MouthCycles,
+		EliminatedLap: player.EliminatedLap,
+	}
+	if player.Finished {
+		result.FinishTime = player.FinishTime
+	}
+	if elapsed > 0 {
+		result.MouthActionsPerMinute = (float64(player.MouthCycles*2) / elapsed) * 60.0
+	}
+	result.addStats(player, elapsed)
+	return result
+}
+
+// endRace finalizes the race, marks everyone who hasn't finished as DNF and sends
+// results
+func (r *Race) endRace(now time.Time) {
+	r.State = RaceStateFinished
+	r.EndTime = now
+
+	var elapsed float64
+	if !r.StartTime.IsZero() {
+		elapsed = r.EndTime.Sub(r.StartTime).Seconds()
+	}
+	for _, player := range r.sortedPlayers() {
+		switch {
+		case player.Finished || player.Eliminated:
+			continue
+		case r.Format.Type == RaceFormatTimeTrial && !player.DNF:
+			// Everyone still connected when the clock stops has finished
+			r.finishRacer(player, elapsed)
+		default:
+			player.DNF = true
+			r.FinishedPlayers = append(r.FinishedPlayers, newRaceResult(player, r.Format, elapsed))
+		}
+	}
+
+	rankResults(r.Format, r.FinishedPlayers)
+	if r.World != nil {
+		r.World.Ratings.Update(r.FinishedPlayers)
+	}
+	r.saveResults()
+
+	log.Printf("Race %s finished!", r.ID)
+
+	// Broadcast results
+	r.BroadcastResults()
+}
+
+// EndRace ends the race now, whatever state it is in. Has no effect on a finished race.
+func (r *Race) EndRace() {
+	r.call(func() {
+		if r.State != RaceStateFinished {
+			r.endRace(time.Now())
+		}
+	})
+}
+
+// ForceEnd ends a race in countdown or racing state immediately, with everyone who
+// hasn't finished marked DNF. Returns false if the race wasn't running.
+func (r *Race) ForceEnd() bool {
+	ended := false
+	r.call(func() {
+		if r.State == RaceStateCountdown || r.State == RaceStateRacing {
+			r.endRace(time.Now())
+			ended = true
+		}
+	})
+	return ended
+}
+
+// RenameMuted gives muted racers the default name and returns how many were renamed
+func (r *Race) RenameMuted() int {
+	renamed := 0
+	r.call(func() {
+		for _, player := range r.Players {
+			if moderation.IsMuted(player.Name) {
+				player.Name = DefaultPlayerName
+				renamed++
+			}
+		}
+		if renamed > 0 {
+			r.BroadcastState()
+		}
+	})
+	return renamed
+}
+
+// BroadcastState sends current race state to all players
+func (r *Race) BroadcastState() {
+	r.lastBroadcast = time.Now()
+
+	// Calculate time remaining for countdown
+	var timeRemaining float64
+	if r.State == RaceStateCountdown {
+		elapsed := time.Since(r.CountdownStart).Seconds()
+		timeRemaining = math.Max(0, float64(RaceCountdownTime)-elapsed)
+	} else if r.State == RaceStateLobby && !r.LobbyDeadline.IsZero() {
+		timeRemaining = math.Max(0, time.Until(r.LobbyDeadline).Seconds())
+	} else if r.State == RaceStateRacing && r.Format.Type == RaceFormatTimeTrial {
+		timeRemaining = math.Max(0, float64(r.Format.Duration)-time.Since(r.StartTime).Seconds())
+	}
+
+	// Build player states in sorted order
+	players := r.sortedPlayers()
+	playersData := make([]RacePlayerState, 0, len(players)+1)
+	readyCount := 0
+	for _, p := range players {
+		playersData = append(playersData, p.state())
+		if p.Ready {
+			readyCount++
+		}
+	}
+
+	// A solo race's ghost runs alongside, after the real players
+	if r.Ghost != nil {
+		var elapsed float64
+		switch r.State {
+		case RaceStateRacing:
+			elapsed = time.Since(r.StartTime).Seconds()
+		case RaceStateFinished:
+			elapsed = r.EndTime.Sub(r.StartTime).Seconds()
+		}
+		playersData = append(playersData, r.Ghost.state(elapsed))
+	}
+
+	pickups, jellyfish := r.trackLayout()
+	payload := RaceStatePayload{
+		RaceState:     r.State.String(),
+		TimeRemaining: timeRemaining,
+		Format:        r.Format,
+		Lap:           r.Lap,
+		TotalLaps:     r.TotalLaps,
+		Pickups:       pickups,
+		Jellyfish:     jellyfish,
+		Players:       playersData,
+		ReadyCount:    readyCount,
+		TotalPlayers:  len(players),
+		MaxPlayers:    r.MaxPlayers,
+		LobbyCode:     r.Code,
+		HostID:        r.HostID,
+		Spectators:    len(r.Spectators),
+	}
+
+	for i, player := range players {
+		if player.Client == nil {
+			continue
+		}
+
+		payload.YourProgress = playersData[i]
+		player.Client.SendMessage(RacingServerMessage{
+			Type:    "raceState",
+			Payload: payload,
+		})
+	}
+
+	payload.YourProgress = RacePlayerState{}
+	payload.Spectator = true
+	for _, spectator := range r.Spectators {
+		spectator.SendMessage(RacingServerMessage{
+			Type:    "raceState",
+			Payload: payload,
+		})
+	}
+}
+
+// BroadcastResults sends final results to all players and spectators
+func (r *Race) BroadcastResults() {
+	r.sendAll(RacingServerMessage{
+		Type: "raceResults",
+		Payload: RaceResultsPayload{
+			Format:  r.Format,
+			Results: r.FinishedPlayers,
+		},
+	})
+}
+
+// StateString returns the race state as a string. Safe from any goroutine.
+func (r *Race) StateString() string {
+	return r.Snapshot().State.String()
+}
+
+// HandleFishStateUpdate processes a fish state update from a client. Updates outside
+// a running race are ignored.
+func (r *Race) HandleFishStateUpdate(playerID string, state FishState) {
+	r.call(func() {
+		if r.State != RaceStateRacing {
+			log.Printf("Ignoring state update for player %s - race %s is %s", playerID, r.ID, r.State)
+			return
+		}
+
+		player, ok := r.Players[playerID]
+		if !ok {
+			log.Printf("Player %s not found in race %s", playerID, r.ID)
+			return
+		}
+		if player.DNF || player.Eliminated || player.Finished {
+			return
+		}
+
+		// Any update shows the racer is still there, but only a higher count is
+		// credited; a lower one is stale or replayed
+		now := time.Now()
+		player.LastUpdate = now
+		delta := state.MouthCycles - player.MouthCycles
+		if delta <= 0 {
+			return
+		}
+		if delta > MaxCyclesPerUpdate {
+			log.Printf("Capping state update for %s: %d new cycles, crediting %d", playerID, delta, MaxCyclesPerUpdate)
+			delta = MaxCyclesPerUpdate
+		}
+		log.Printf("Received state update for %s: cycles=%d (was %d)", playerID, player.MouthCycles+delta, player.MouthCycles)
+		player.MouthCycles += delta
+
+		// Credit the new cycles, through any power-ups and obstacles
+		if r.Format.Type == RaceFormatDistance {
+			if r.Format.Items {
+				r.advanceWithItems(player, delta, now)
+			} else {
+				player.Distance = float64(player.MouthCycles)
+			}
+		}
+		r.updateProgress()
+
+		elapsed := now.Sub(r.StartTime).Seconds()
+		player.logCycles(elapsed)
+		if r.Format.Type != RaceFormatTimeTrial {
+			player.recordSplits(elapsed)
+		}
+
+		// Check if player just finished, or an elimination lap is over. The next tick
+		// ends the race if everyone is done.
+		switch r.Format.Type {
+		case RaceFormatDistance:
+			player.recordSample(elapsed)
+			if player.Distance >= float64(r.Format.Cycles) {
+				r.finishRacer(player, elapsed)
+			}
+		case RaceFormatElimination:
+			r.checkEliminations(elapsed)
+		}
+	})
+}
+
+// DisconnectPlayer removes a player from the race. Mid-race leavers stay in the
+// results as DNF.
+func (r *Race) DisconnectPlayer(playerID string) {
+	r.call(func() {
+		player, exists := r.Players[playerID]
+		if !exists {
+			return
+		}
+
+		log.Printf("Player %s disconnected from race %s (state: %s)", player.Name, r.ID, r.State)
+
+		if r.State == RaceStateCountdown || r.State == RaceStateRacing {
+			player.Client = nil
+			if !player.Finished {
+				player.DNF = true
+			}
+			return
+		}
+
+		delete(r.Players, playerID)
+		if len(r.Players) == 0 {
+			// Empty lobbies and finished races are removed by ReapRaces and ExpireLobbies
+			r.EmptySince = time.Now()
+		}
+
+		// Let the lobby know who left, and who the new host is
+		r.passHost(playerID)
+		if r.State == RaceStateLobby {
+			r.BroadcastState()
+		}
+	})
+}
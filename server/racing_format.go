@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+)
+
+// Race formats a private lobby can choose. Public races are always RaceFormatDistance
+// over CyclesPerRace.
+const (
+	RaceFormatDistance    = "distance"    // First to a number of cycles
+	RaceFormatTimeTrial   = "timeTrial"   // Most cycles in a fixed time
+	RaceFormatElimination = "elimination" // The last racer to finish each lap is knocked out
+)
+
+// Format limits and defaults
+const (
+	MinRaceCycles        = 10 // Shortest distance race
+	MaxRaceCycles        = 500
+	DefaultTimeTrialTime = 30 // Seconds
+	MinTimeTrialTime     = 10
+	MaxTimeTrialTime     = 120
+	DefaultLapCycles     = 10 // Cycles per elimination lap
+	MinLapCycles         = 5
+	MaxLapCycles         = 50
+)
+
+// ErrInvalidFormat is sent in lobbyError when a requested format can't be used
+var ErrInvalidFormat = errors.New("invalid race format")
+
+// RaceFormat describes how a race is run and scored. Only the fields for its Type are
+// set.
+type RaceFormat struct {
+	Type      string `json:"type"`
+	Cycles    int    `json:"cycles,omitempty"`    // Distance: cycles to finish
+	Duration  int    `json:"duration,omitempty"`  // Time trial: seconds
+	LapCycles int    `json:"lapCycles,omitempty"` // Elimination: cycles per lap
+	Items     bool   `json:"items,omitempty"`     // Distance: power-ups and obstacles
+}
+
+// DefaultRaceFormat is the classic race of CyclesPerRace cycles
+func DefaultRaceFormat() RaceFormat {
+	return RaceFormat{Type: RaceFormatDistance, Cycles: CyclesPerRace}
+}
+
+// Normalize fills in defaults for a requested format and checks its limits. An empty
+// format is the default distance race.
+func (f RaceFormat) Normalize() (RaceFormat, error) {
+	if f.Items && f.Type != "" && f.Type != RaceFormatDistance {
+		return f, fmt.Errorf("%w: items are only available in distance races", ErrInvalidFormat)
+	}
+
+	switch f.Type {
+	case "", RaceFormatDistance:
+		if f.Cycles == 0 {
+			f.Cycles = CyclesPerRace
+		}
+		if f.Cycles < MinRaceCycles || f.Cycles > MaxRaceCycles {
+			return f, fmt.Errorf("%w: distance must be %d-%d cycles", ErrInvalidFormat, MinRaceCycles, MaxRaceCycles)
+		}
+		return RaceFormat{Type: RaceFormatDistance, Cycles: f.Cycles, Items: f.Items}, nil
+
+	case RaceFormatTimeTrial:
+		if f.Duration == 0 {
+			f.Duration = DefaultTimeTrialTime
+		}
+		if f.Duration < MinTimeTrialTime || f.Duration > MaxTimeTrialTime {
+			return f, fmt.Errorf("%w: time trials last %d-%d seconds", ErrInvalidFormat, MinTimeTrialTime, MaxTimeTrialTime)
+		}
+		return RaceFormat{Type: RaceFormatTimeTrial, Duration: f.Duration}, nil
+
+	case RaceFormatElimination:
+		if f.LapCycles == 0 {
+			f.LapCycles = DefaultLapCycles
+		}
+		if f.LapCycles < MinLapCycles || f.LapCycles > MaxLapCycles {
+			return f, fmt.Errorf("%w: laps must be %d-%d cycles", ErrInvalidFormat, MinLapCycles, MaxLapCycles)
+		}
+		return RaceFormat{Type: RaceFormatElimination, LapCycles: f.LapCycles}, nil
+
+	default:
+		return f, fmt.Errorf("%w: unknown type %q", ErrInvalidFormat, f.Type)
+	}
+}
+
+// String describes the format for logs
+func (f RaceFormat) String() string {
+	switch f.Type {
+	case RaceFormatTimeTrial:
+		return fmt.Sprintf("%ds time trial", f.Duration)
+	case RaceFormatElimination:
+		return fmt.Sprintf("elimination (%d cycles/lap)", f.LapCycles)
+	default:
+		if f.Items {
+			return fmt.Sprintf("%d-cycle race with items", f.Cycles)
+		}
+		return fmt.Sprintf("%d-cycle race", f.Cycles)
+	}
+}
+
+// updateProgress recalculates every racer's progress for the race's format. Time trial
+// progress is relative to the leader, since there is no finish line.
+func (r *Race) updateProgress() {
+	switch r.Format.Type {
+	case RaceFormatTimeTrial:
+		leader := 0
+		for _, player := range r.Players {
+			if player.MouthCycles > leader {
+				leader = player.MouthCycles
+			}
+		}
+		for _, player := range r.Players {
+			if leader > 0 {
+				player.Progress = float64(player.MouthCycles) / float64(leader)
+			}
+		}
+
+	case RaceFormatElimination:
+		total := float64(r.TotalLaps * r.Format.LapCycles)
+		for _, player := range r.Players {
+			if !player.Finished {
+				player.Progress = math.Min(float64(player.MouthCycles)/total, 1.0)
+			}
+		}
+
+	default:
+		for _, player := range r.Players {
+			player.Progress = math.Min(player.Distance/float64(r.Format.Cycles), 1.0)
+		}
+	}
+}
+
+// activeRacers returns racers still in the race, in ID order
+func (r *Race) activeRacers() []*RacingPlayer {
+	active := make([]*RacingPlayer, 0, len(r.Players))
+	for _, player := range r.sortedPlayers() {
+		if !player.Finished && !player.DNF && !player.Eliminated {
+			active = append(active, player)
+		}
+	}
+	return active
+}
+
+// checkEliminations runs an elimination race: once every active racer but one has
+// completed the current lap, that one is knocked out and the next lap begins. The last
+// racer standing wins. A lone racer just has to complete the laps.
+func (r *Race) checkEliminations(elapsed float64) {
+	for {
+		active := r.activeRacers()
+		if len(active) == 0 {
+			return
+		}
+
+		threshold := r.Lap * r.Format.LapCycles
+		if len(active) == 1 {
+			if r.StartingRacers > 1 || active[0].MouthCycles >= threshold {
+				r.finishRacer(active[0], elapsed)
+			}
+			return
+		}
+
+		var last *RacingPlayer
+		for _, player := range active {
+			if player.MouthCycles >= threshold {
+				continue
+			}
+			if last != nil {
+				return // More than one racer still on this lap
+			}
+			last = player
+		}
+		if last == nil {
+			// Everyone crossed in the same update; the fewest cycles goes out
+			last = active[0]
+			for _, player := range active[1:] {
+				if player.MouthCycles < last.MouthCycles {
+					last = player
+				}
+			}
+		}
+
+		last.Eliminated = true
+		last.EliminatedLap = r.Lap
+		log.Printf("Player %s eliminated on lap %d of race %s", last.Name, r.Lap, r.ID)
+		r.FinishedPlayers = append(r.FinishedPlayers, newRaceResult(last, r.Format, elapsed))
+		r.Lap++
+	}
+}
+
+// finishRacer marks a racer as finished after elapsed seconds and records their result
+func (r *Race) finishRacer(player *RacingPlayer, elapsed float64) {
+	player.Finished = true
+	player.FinishTime = elapsed
+	if r.Format.Type == RaceFormatElimination {
+		player.Progress = 1.0
+	}
+	log.Printf("Player %s finished! Time: %.2fs, Cycles: %d", player.ID, player.FinishTime, player.MouthCycles)
+	r.FinishedPlayers = append(r.FinishedPlayers, newRaceResult(player, r.Format, elapsed))
+	r.saveGhost(player)
+}
+
+// rankResults orders results for the race's format and numbers them. DNFs always come
+// last, by how far they got.
+func rankResults(format RaceFormat, results []RaceResult) {
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.DNF != b.DNF {
+			return !a.DNF
+		}
+		if a.DNF {
+			return a.Progress > b.Progress
+		}
+
+		switch format.Type {
+		case RaceFormatTimeTrial:
+			return a.Cycles > b.Cycles
+		case RaceFormatElimination:
+			// The winner, then whoever survived the most laps
+			if a.EliminatedLap == 0 || b.EliminatedLap == 0 {
+				return a.EliminatedLap == 0 && b.EliminatedLap != 0
+			}
+			return a.EliminatedLap > b.EliminatedLap
+		default:
+			return a.FinishTime < b.FinishTime
+		}
+	})
+
+	for i := range results {
+		results[i].Rank = i + 1
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRaceFormatNormalize(t *testing.T) {
+	cases := []struct {
+		in   RaceFormat
+		want RaceFormat
+		ok   bool
+	}{
+		{RaceFormat{}, DefaultRaceFormat(), true},
+		{RaceFormat{Type: RaceFormatDistance, Cycles: 100, Duration: 5}, RaceFormat{Type: RaceFormatDistance, Cycles: 100}, true},
+		{RaceFormat{Type: RaceFormatTimeTrial}, RaceFormat{Type: RaceFormatTimeTrial, Duration: DefaultTimeTrialTime}, true},
+		{RaceFormat{Type: RaceFormatElimination}, RaceFormat{Type: RaceFormatElimination, LapCycles: DefaultLapCycles}, true},
+		{RaceFormat{Type: RaceFormatDistance, Cycles: 1}, RaceFormat{}, false},
+		{RaceFormat{Type: RaceFormatTimeTrial, Duration: MaxTimeTrialTime + 1}, RaceFormat{}, false},
+		{RaceFormat{Type: "relay"}, RaceFormat{}, false},
+	}
+	for _, c := range cases {
+		got, err := c.in.Normalize()
+		if c.ok && (err != nil || got != c.want) {
+			t.Errorf("%+v: got %+v, %v; want %+v", c.in, got, err, c.want)
+		}
+		if !c.ok && !errors.Is(err, ErrInvalidFormat) {
+			t.Errorf("%+v should be rejected, got %v", c.in, err)
+		}
+	}
+}
+
+func TestDistanceFormat(t *testing.T) {
+	world := NewRacingWorld()
+	race, clients := formatRace(world, RaceFormat{Type: RaceFormatDistance, Cycles: 20}, time.Second, "Short")
+
+	race.HandleFishStateUpdate(clients[0].ID, FishState{MouthCycles: 10})
+	if p := race.Snapshot().Players[0].Progress; p != 0.5 {
+		t.Errorf("progress = %v, want 0.5 halfway through a 20-cycle race", p)
+	}
+	race.HandleFishStateUpdate(clients[0].ID, FishState{MouthCycles: 20})
+	if !race.Snapshot().Players[0].Finished {
+		t.Error("racer should finish at the format's distance")
+	}
+}
+
+func TestTimeTrialFormat(t *testing.T) {
+	world := NewRacingWorld()
+	format := RaceFormat{Type: RaceFormatTimeTrial, Duration: MinTimeTrialTime}
+	race, clients := formatRace(world, format, MinTimeTrialTime*time.Second-300*time.Millisecond, "Slow", "Fast", "Gone")
+
+	sendCycles(race, clients[0].ID, 30)
+	sendCycles(race, clients[1].ID, 40)
+	sendCycles(race, clients[2].ID, 90)
+	race.DisconnectPlayer(clients[2].ID)
+
+	// Passing CyclesPerRace doesn't finish anyone in a time trial
+	for _, player := range race.Snapshot().Players {
+		if player.Finished {
+			t.Fatalf("%s finished before the clock ran out", player.Name)
+		}
+	}
+
+	waitForState(t, race, RaceStateFinished)
+	results := race.Snapshot().Results
+	want := []struct {
+		name   string
+		cycles int
+		dnf    bool
+	}{{"Fast", 40, false}, {"Slow", 30, false}, {"Gone", 90, true}}
+	for i, w := range want {
+		got := results[i]
+		if got.Name != w.name || got.Cycles != w.cycles || got.DNF != w.dnf || got.Format != RaceFormatTimeTrial {
+			t.Errorf("result %d = %+v, want %s with %d cycles (dnf %v)", i, got, w.name, w.cycles, w.dnf)
+		}
+	}
+}
+
+func TestEliminationFormat(t *testing.T) {
+	world := NewRacingWorld()
+	format := RaceFormat{Type: RaceFormatElimination, LapCycles: 10}
+	race, clients := formatRace(world, format, time.Second, "A", "B", "C")
+	var totalLaps int
+	race.call(func() { totalLaps = race.TotalLaps })
+	if totalLaps != 2 {
+		t.Fatalf("three racers should race %d laps, got %d", 2, totalLaps)
+	}
+
+	race.HandleFishStateUpdate(clients[0].ID, FishState{MouthCycles: 10})
+	race.HandleFishStateUpdate(clients[2].ID, FishState{MouthCycles: 9})
+	race.HandleFishStateUpdate(clients[1].ID, FishState{MouthCycles: 12})
+
+	eliminated := func(name string) bool {
+		for _, player := range race.Snapshot().Players {
+			if player.Name == name {
+				return player.Eliminated
+			}
+		}
+		return false
+	}
+	if !eliminated("C") || eliminated("A") || eliminated("B") {
+		t.Fatal("C should be knocked out as the last racer on lap 1")
+	}
+
+	// Updates from an eliminated racer don't bring them back
+	sendCycles(race, clients[2].ID, 30)
+	race.HandleFishStateUpdate(clients[1].ID, FishState{MouthCycles: 20})
+	if !eliminated("A") {
+		t.Fatal("A should be knocked out on lap 2")
+	}
+
+	race.EndRace()
+	results := race.Snapshot().Results
+	want := []struct {
+		name string
+		lap  int
+	}{{"B", 0}, {"A", 2}, {"C", 1}}
+	for i, w := range want {
+		got := results[i]
+		if got.Name != w.name || got.EliminatedLap != w.lap || got.DNF {
+			t.Errorf("result %d = %+v, want %s knocked out on lap %d", i, got, w.name, w.lap)
+		}
+	}
+}
+
+func TestSetFormat(t *testing.T) {
+	world := NewRacingWorld()
+	host := newTestRacer(world)
+	race, _ := world.CreateLobby(host, "Host", "shark", 0, DefaultRaceFormat())
+	guest := newTestRacer(world)
+	world.JoinLobby(guest, race.Code, "Guest", "shark")
+
+	trial := RaceFormat{Type: RaceFormatTimeTrial, Duration: 30}
+	if err := race.SetFormat(guest.ID, trial); !errors.Is(err, ErrNotHost) {
+		t.Errorf("guest setting the format: got %v", err)
+	}
+	if err := race.SetFormat(host.ID, trial); err != nil {
+		t.Fatal(err)
+	}
+	if race.Snapshot().Format != trial {
+		t.Errorf("format = %+v, want %+v", race.Snapshot().Format, trial)
+	}
+
+	race.HostStart(host.ID)
+	if err := race.SetFormat(host.ID, DefaultRaceFormat()); !errors.Is(err, ErrLobbyStarted) {
+		t.Errorf("changing format after start: got %v", err)
+	}
+}
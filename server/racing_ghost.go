@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Ghost racing: every finished distance race leaves a timestamped progress trace, and
+// a player joining a solo race can race the ghost of their own best or of the record
+// holder at that distance. Ghosts are kept in memory, keyed by the racer's name
+// skeleton, so they last until the server restarts.
+
+// Ghost kinds a join may ask for
+const (
+	GhostPersonalBest = "best"   // The joining player's own fastest run
+	GhostRecord       = "record" // The fastest run by anyone
+)
+
+// Ghost limits
+const (
+	GhostID         = "ghost" // Player ID of the ghost in raceState
+	MaxGhostSamples = 1000    // Trace samples kept per run
+	MaxStoredGhosts = 1000    // Personal bests kept; the oldest is dropped beyond this
+)
+
+// ErrUnknownGhost is sent in lobbyError when a join asks for a ghost kind we don't have
+var ErrUnknownGhost = errors.New(`ghost must be "best" or "record"`)
+
+// GhostSample is a racer's progress T seconds into a race
+type GhostSample struct {
+	T        float64 `json:"t"`
+	Progress float64 `json:"progress"`
+}
+
+// GhostRun is a finished racer's run, replayed as a ghost. Never modified once stored.
+type GhostRun struct {
+	Name       string
+	Model      string
+	Cycles     int // Race distance
+	FinishTime float64
+	Trace      []GhostSample
+	SavedAt    time.Time
+}
+
+// GhostInfo describes the ghost in a solo race's welcome
+type GhostInfo struct {
+	Kind       string  `json:"kind"`
+	Name       string  `json:"name"`
+	Model      string  `json:"model"`
+	FinishTime float64 `json:"finishTime"`
+}
+
+// ghostKey identifies a racer's personal best at one distance
+type ghostKey struct {
+	Skeleton string
+	Cycles   int
+}
+
+// GhostStore keeps personal bests and records. It has its own lock, so race
+// goroutines can save runs without touching the racing world's lock.
+type GhostStore struct {
+	best    map[ghostKey]*GhostRun
+	records map[int]*GhostRun // By distance
+	mu      sync.Mutex
+}
+
+// NewGhostStore creates an empty ghost store
+func NewGhostStore() *GhostStore {
+	return &GhostStore{
+		best:    make(map[ghostKey]*GhostRun),
+		records: make(map[int]*GhostRun),
+	}
+}
+
+// Save records a finished run if it is the racer's best or the record at its distance
+func (s *GhostStore) Save(run *GhostRun) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record := s.records[run.Cycles]; record == nil || run.FinishTime < record.FinishTime {
+		s.records[run.Cycles] = run
+		log.Printf("New %d-cycle record: %s in %.2fs", run.Cycles, run.Name, run.FinishTime)
+	}
+
+	key := ghostKey{Skeleton: NameSkeleton(run.Name), Cycles: run.Cycles}
+	if best := s.best[key]; best != nil && best.FinishTime <= run.FinishTime {
+		return
+	}
+	if _, exists := s.best[key]; !exists && len(s.best) >= MaxStoredGhosts {
+		s.dropOldest()
+	}
+	s.best[key] = run
+}
+
+// dropOldest forgets the personal best saved longest ago. Caller holds s.mu.
+func (s *GhostStore) dropOldest() {
+	var oldest ghostKey
+	var oldestAt time.Time
+	for key, run := range s.best {
+		if oldestAt.IsZero() || run.SavedAt.Before(oldestAt) {
+			oldest, oldestAt = key, run.SavedAt
+		}
+	}
+	delete(s.best, oldest)
+}
+
+// Find returns the ghost of the given kind for a racer called name at a distance, or nil
+func (s *GhostStore) Find(kind, name string, cycles int) *GhostRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if kind == GhostRecord {
+		return s.records[cycles]
+	}
+	return s.best[ghostKey{Skeleton: NameSkeleton(name), Cycles: cycles}]
+}
+
+// ProgressAt returns how far the ghost had got t seconds into its run, interpolating
+// between samples
+func (g *GhostRun) ProgressAt(t float64) float64 {
+	if t >= g.FinishTime {
+		return 1.0
+	}
+
+	prev := GhostSample{}
+	for _, sample := range g.Trace {
+		if sample.T >= t {
+			if sample.T == prev.T {
+				return sample.Progress
+			}
+			return prev.Progress + (sample.Progress-prev.Progress)*(t-prev.T)/(sample.T-prev.T)
+		}
+		prev = sample
+	}
+	return prev.Progress
+}
+
+// state returns the ghost as raceState shows it, elapsed seconds into the race
+func (g *GhostRun) state(elapsed float64) RacePlayerState {
+	progress := g.ProgressAt(elapsed)
+	return RacePlayerState{
+		ID:       GhostID,
+		Name:     g.Name,
+		Model:    g.Model,
+		Progress: progress,
+		Finished: progress >= 1.0,
+		Ready:    true,
+		Ghost:    true,
+	}
+}
+
+// recordSample adds the racer's current progress to their trace
+func (p *RacingPlayer) recordSample(elapsed float64) {
+	if len(p.Trace) >= MaxGhostSamples {
+		return
+	}
+	if n := len(p.Trace); n > 0 && p.Trace[n-1].Progress == p.Progress {
+		return
+	}
+	p.Trace = append(p.Trace, GhostSample{T: elapsed, Progress: p.Progress})
+}
+
+// saveGhost stores a finished distance racer's run for ghost racing. Runs from items
+// races aren't kept, since bubbles and bites would put them out of reach of a solo race.
+func (r *Race) saveGhost(player *RacingPlayer) {
+	if r.World == nil || r.Format.Type != RaceFormatDistance || r.Format.Items {
+		return
+	}
+
+	trace := append([]GhostSample(nil), player.Trace...)
+	if n := len(trace); n == 0 || trace[n-1].Progress < 1.0 {
+		trace = append(trace, GhostSample{T: player.FinishTime, Progress: 1.0})
+	}
+	r.World.Ghosts.Save(&GhostRun{
+		Name:       player.Name,
+		Model:      player.Model,
+		Cycles:     r.Format.Cycles,
+		FinishTime: player.FinishTime,
+		Trace:      trace,
+		SavedAt:    time.Now(),
+	})
+}
+
+// JoinSoloRace opens a race just for client, with the ghost of the given kind if one
+// exists. The player starts it by readying up.
+func (rw *RacingWorld) JoinSoloRace(client *RacingClient, playerName, model, kind string) (*Race, RaceWelcomePayload) {
+	format := DefaultRaceFormat()
+	ghost := rw.Ghosts.Find(kind, playerName, format.Cycles)
+
+	// Held until the player is in, so the reaper can't take the empty race
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	race := rw.createRace("", 1, "", format)
+
+	var welcome RaceWelcomePayload
+	race.call(func() {
+		race.Ghost = ghost
+		player := race.addPlayer(client, playerName, model)
+		welcome = race.welcome(player)
+		if ghost != nil {
+			welcome.Ghost = &GhostInfo{Kind: kind, Name: ghost.Name, Model: ghost.Model, FinishTime: ghost.FinishTime}
+			log.Printf("Player %s racing the %s ghost of %s (%.2fs) in race %s", player.Name, kind, ghost.Name, ghost.FinishTime, race.ID)
+		} else {
+			log.Printf("Player %s started solo race %s; no %s ghost yet", player.Name, race.ID, kind)
+		}
+		race.BroadcastState()
+	})
+	return race, welcome
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestGhostProgressAt(t *testing.T) {
+	ghost := &GhostRun{
+		FinishTime: 10,
+		Trace:      []GhostSample{{T: 2, Progress: 0.2}, {T: 6, Progress: 0.6}, {T: 10, Progress: 1}},
+	}
+	cases := map[float64]float64{0: 0, 1: 0.1, 4: 0.4, 6: 0.6, 9: 0.9, 12: 1}
+	for at, want := range cases {
+		if got := ghost.ProgressAt(at); math.Abs(got-want) > 1e-9 {
+			t.Errorf("ProgressAt(%v) = %v, want %v", at, got, want)
+		}
+	}
+}
+
+func TestGhostStoreKeepsBests(t *testing.T) {
+	store := NewGhostStore()
+	store.Save(&GhostRun{Name: "Nemo", Cycles: 50, FinishTime: 20})
+	store.Save(&GhostRun{Name: "NEMO", Cycles: 50, FinishTime: 25})
+	store.Save(&GhostRun{Name: "Dory", Cycles: 50, FinishTime: 15})
+
+	if best := store.Find(GhostPersonalBest, "nemo", 50); best == nil || best.FinishTime != 20 {
+		t.Errorf("a slower run must not replace a personal best: %+v", best)
+	}
+	if record := store.Find(GhostRecord, "Nemo", 50); record == nil || record.Name != "Dory" {
+		t.Errorf("record = %+v, want Dory's run", record)
+	}
+	if store.Find(GhostPersonalBest, "Nemo", 100) != nil {
+		t.Error("bests are kept per distance")
+	}
+}
+
+func TestGhostRace(t *testing.T) {
+	world := NewRacingWorld()
+
+	// A finished run is saved as a ghost
+	race, clients := startedRace(world, 10*time.Second, "Nemo")
+	for _, cycles := range []int{20, 40, CyclesPerRace} {
+		race.HandleFishStateUpdate(clients[0].ID, FishState{MouthCycles: cycles})
+	}
+	best := world.Ghosts.Find(GhostPersonalBest, "Nemo", CyclesPerRace)
+	if best == nil || len(best.Trace) != 3 {
+		t.Fatalf("finished run should be saved with its trace: %+v", best)
+	}
+
+	racer := newTestRacer(world)
+	solo, welcome := world.JoinSoloRace(racer, "Nemo", "shark", GhostPersonalBest)
+	if solo == world.WaitingLobby || welcome.Ghost == nil || welcome.Ghost.FinishTime != best.FinishTime {
+		t.Fatalf("solo race should have Nemo's ghost: %+v", welcome.Ghost)
+	}
+
+	// The ghost streams with the players, and doesn't count as one
+	solo.call(func() {
+		solo.startRace(time.Now().Add(-time.Duration(best.FinishTime * float64(time.Second) / 2)))
+		solo.BroadcastState()
+	})
+	var state RaceStatePayload
+	json.Unmarshal(lastMessage(racer, "raceState"), &state)
+	if len(state.Players) != 2 || state.TotalPlayers != 1 {
+		t.Fatalf("raceState should list the player and the ghost: %+v", state)
+	}
+	ghost := state.Players[1]
+	if !ghost.Ghost || ghost.ID != GhostID || ghost.Progress <= 0 || ghost.Progress >= 1 {
+		t.Errorf("ghost halfway through its run: %+v", ghost)
+	}
+
+	// Finishing isn't held up waiting for the ghost
+	sendCycles(solo, racer.ID, CyclesPerRace)
+	waitForState(t, solo, RaceStateFinished)
+
+	// No ghost yet for a newcomer, but the solo race still runs
+	_, welcome = world.JoinSoloRace(newTestRacer(world), "Dory", "shark", GhostPersonalBest)
+	if welcome.Ghost != nil {
+		t.Errorf("Dory has no runs to race: %+v", welcome.Ghost)
+	}
+	_, welcome = world.JoinSoloRace(newTestRacer(world), "Dory", "shark", GhostRecord)
+	if welcome.Ghost == nil || welcome.Ghost.Name != "Nemo" {
+		t.Errorf("record ghost should be the fastest run: %+v", welcome.Ghost)
+	}
+}
+
+func TestItemsRunsAreNotGhosts(t *testing.T) {
+	world := NewRacingWorld()
+	race, clients := formatRace(world, itemsFormat, 10*time.Second, "Nemo")
+
+	// A bubble-boosted run mustn't become the record solo racers chase
+	race.call(func() {
+		player := race.Players[clients[0].ID]
+		player.FinishTime = 5
+		race.saveGhost(player)
+	})
+	if ghost := world.Ghosts.Find(GhostRecord, "Nemo", itemsFormat.Cycles); ghost != nil {
+		t.Errorf("items race run was saved as a ghost: %+v", ghost)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Power-ups and obstacles for distance races with items turned on. Racers pick up an
+// item at fixed points on the track and use it with a useItem message:
+//   - a bubble makes their next BubbleCycles cycles count double;
+//   - a bite makes the racer directly ahead's next BiteSlowCycles cycles count half.
+// Jellyfish sit further along; a racer who reaches one stops there until they hold
+// their mouth open for JellyfishHoldTime, and cycles made meanwhile are lost.
+
+// Item kinds
+const (
+	ItemBubble = "bubble"
+	ItemBite   = "bite"
+)
+
+// Item tuning
+const (
+	BubbleCycles      = 5 // Cycles a bubble doubles
+	BiteSlowCycles    = 5 // Cycles a bite halves for its victim
+	JellyfishHoldTime = 1500 * time.Millisecond
+)
+
+// Track layout, as fractions of the race distance
+var (
+	ItemPickups        = []float64{0.2, 0.45, 0.7}
+	JellyfishPositions = []float64{0.35, 0.8}
+)
+
+// Reasons a useItem is refused
+var (
+	ErrNoItem        = errors.New("no item to use")
+	ErrNoTarget      = errors.New("nobody ahead to bite")
+	ErrItemsDisabled = errors.New("items are off in this race")
+)
+
+// ItemUsedPayload tells every racer that an item was used, and on whom
+type ItemUsedPayload struct {
+	PlayerID string `json:"playerId"`
+	Item     string `json:"item"`
+	TargetID string `json:"targetId,omitempty"` // Bites
+}
+
+// advanceWithItems credits a racer with delta new cycles, applying their bubble and
+// slow effects and stopping them at jellyfish and collecting items on the way
+func (r *Race) advanceWithItems(player *RacingPlayer, delta int, now time.Time) {
+	for ; delta > 0 && player.Distance < float64(r.Format.Cycles); delta-- {
+		r.checkJellyfish(player, now)
+		if player.Blocked {
+			continue // Chomping at a jellyfish gets you nowhere
+		}
+
+		gain := 1.0
+		if player.BubbleCycles > 0 {
+			gain *= 2
+			player.BubbleCycles--
+		}
+		if player.SlowCycles > 0 {
+			gain *= 0.5
+			player.SlowCycles--
+		}
+		player.Distance = math.Min(player.Distance+gain, float64(r.Format.Cycles))
+
+		for player.NextPickup < len(ItemPickups) && player.Distance >= ItemPickups[player.NextPickup]*float64(r.Format.Cycles) {
+			player.NextPickup++
+			if player.Item == "" {
+				player.Item = []string{ItemBubble, ItemBite}[rand.Intn(2)]
+				log.Printf("Player %s picked up a %s in race %s", player.Name, player.Item, r.ID)
+			}
+		}
+
+		if player.NextJellyfish < len(JellyfishPositions) {
+			if at := JellyfishPositions[player.NextJellyfish] * float64(r.Format.Cycles); player.Distance >= at {
+				player.Distance = at
+				player.Blocked = true
+				player.BlockedAt = now
+				log.Printf("Player %s reached a jellyfish in race %s", player.Name, r.ID)
+			}
+		}
+	}
+	r.checkJellyfish(player, now)
+}
+
+// checkJellyfish lets a blocked racer past once they've held their mouth open for
+// JellyfishHoldTime since reaching the jellyfish
+func (r *Race) checkJellyfish(player *RacingPlayer, now time.Time) {
+	if !player.Blocked || !player.MouthOpen {
+		return
+	}
+
+	since := player.MouthOpenSince
+	if player.BlockedAt.After(since) {
+		since = player.BlockedAt
+	}
+	if now.Sub(since) >= JellyfishHoldTime {
+		player.Blocked = false
+		player.NextJellyfish++
+		log.Printf("Player %s got past a jellyfish in race %s", player.Name, r.ID)
+	}
+}
+
+// setMouthOpen records the racer's mouth state, timing how long it has been open
+func (p *RacingPlayer) setMouthOpen(open bool, now time.Time) {
+	if open && !p.MouthOpen {
+		p.MouthOpenSince = now
+	}
+	p.MouthOpen = open
+}
+
+// HandleMouthInput records a racer opening or closing their mouth, which is how they
+// get past jellyfish
+func (r *Race) HandleMouthInput(playerID string, open bool) {
+	r.call(func() {
+		player, ok := r.Players[playerID]
+		if !ok || r.State != RaceStateRacing {
+			return
+		}
+		now := time.Now()
+		player.setMouthOpen(open, now)
+		if player.Blocked && r.Format.Items {
+			r.checkJellyfish(player, now)
+		}
+	})
+}
+
+// UseItem spends the racer's held item
+func (r *Race) UseItem(playerID string) error {
+	err := ErrNoItem
+	r.call(func() {
+		player, ok := r.Players[playerID]
+		switch {
+		case !r.Format.Items:
+			err = ErrItemsDisabled
+		case !ok || r.State != RaceStateRacing || player.Item == "" || player.Finished || player.DNF:
+			err = ErrNoItem
+		case player.Item == ItemBubble:
+			err = nil
+			player.BubbleCycles += BubbleCycles
+			r.itemUsed(player, nil)
+		case player.Item == ItemBite:
+			target := r.racerAhead(player)
+			if target == nil {
+				err = ErrNoTarget
+				return
+			}
+			err = nil
+			target.SlowCycles += BiteSlowCycles
+			r.itemUsed(player, target)
+		}
+	})
+	return err
+}
+
+// itemUsed spends the racer's item and tells everyone
+func (r *Race) itemUsed(player, target *RacingPlayer) {
+	payload := ItemUsedPayload{PlayerID: player.ID, Item: player.Item}
+	if target != nil {
+		payload.TargetID = target.ID
+		log.Printf("Player %s bit %s in race %s", player.Name, target.Name, r.ID)
+	} else {
+		log.Printf("Player %s used a %s in race %s", player.Name, player.Item, r.ID)
+	}
+	player.Item = ""
+
+	r.sendAll(RacingServerMessage{Type: "itemUsed", Payload: payload})
+	r.BroadcastState()
+}
+
+// racerAhead returns the still-racing player closest ahead of player, or nil
+func (r *Race) racerAhead(player *RacingPlayer) *RacingPlayer {
+	var ahead *RacingPlayer
+	for _, other := range r.activeRacers() {
+		if other != player && other.Distance > player.Distance && (ahead == nil || other.Distance < ahead.Distance) {
+			ahead = other
+		}
+	}
+	return ahead
+}
+
+// trackLayout returns the pickup and jellyfish positions for raceState, or nils if the
+// race has no items
+func (r *Race) trackLayout() ([]float64, []float64) {
+	if !r.Format.Items {
+		return nil, nil
+	}
+	return ItemPickups, JellyfishPositions
+}
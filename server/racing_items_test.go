@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+var itemsFormat = RaceFormat{Type: RaceFormatDistance, Cycles: 50, Items: true}
+
+// progressOf returns a racer's progress from the race's snapshot
+func progressOf(race *Race, id string) RacePlayerState {
+	for _, player := range race.Snapshot().Players {
+		if player.ID == id {
+			return player.RacePlayerState
+		}
+	}
+	return RacePlayerState{}
+}
+
+// giveItem hands a racer an item as if they'd picked it up
+func giveItem(race *Race, id, item string) {
+	race.call(func() { race.Players[id].Item = item })
+}
+
+func TestBubbleDoublesCycles(t *testing.T) {
+	world := NewRacingWorld()
+	race, clients := formatRace(world, itemsFormat, time.Second, "Nemo")
+	id := clients[0].ID
+
+	giveItem(race, id, ItemBubble)
+	if err := race.UseItem(id); err != nil {
+		t.Fatal(err)
+	}
+	if !progressOf(race, id).Boosted {
+		t.Error("racer should show as boosted")
+	}
+	race.HandleFishStateUpdate(id, FishState{MouthCycles: 4})
+	if p := progressOf(race, id).Progress; p != 8.0/50 {
+		t.Errorf("progress = %v, want 4 cycles doubled", p)
+	}
+	if err := race.UseItem(id); !errors.Is(err, ErrNoItem) {
+		t.Errorf("using a spent item: got %v", err)
+	}
+
+	// One more doubled cycle takes them to the first pickup
+	race.HandleFishStateUpdate(id, FishState{MouthCycles: 5})
+	if state := progressOf(race, id); state.Progress != 0.2 || state.Item == "" || state.Boosted {
+		t.Errorf("racer should have collected an item at 20%%: %+v", state)
+	}
+}
+
+func TestReplayedCyclesNotCredited(t *testing.T) {
+	world := NewRacingWorld()
+	race, clients := formatRace(world, itemsFormat, time.Second, "Nemo")
+	id := clients[0].ID
+
+	// Dropping the count and climbing back mustn't credit the same cycles again
+	for _, cycles := range []int{5, 0, 5, 0, 5} {
+		race.HandleFishStateUpdate(id, FishState{MouthCycles: cycles})
+	}
+	if p := progressOf(race, id).Progress; p != 5.0/50 {
+		t.Errorf("progress = %v, want 5 cycles", p)
+	}
+}
+
+func TestHugeCycleJumpIsCapped(t *testing.T) {
+	world := NewRacingWorld()
+	race, clients := formatRace(world, itemsFormat, time.Second, "Nemo")
+	id := clients[0].ID
+
+	done := make(chan struct{})
+	go func() {
+		race.HandleFishStateUpdate(id, FishState{MouthCycles: math.MaxInt})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a huge cycle count should be handled at once")
+	}
+
+	var cycles int
+	race.call(func() { cycles = race.Players[id].MouthCycles })
+	if cycles != MaxCyclesPerUpdate || progressOf(race, id).Progress > float64(MaxCyclesPerUpdate)/50 {
+		t.Errorf("credited %d cycles, want at most %d", cycles, MaxCyclesPerUpdate)
+	}
+}
+
+func TestBiteSlowsRacerAhead(t *testing.T) {
+	world := NewRacingWorld()
+	race, clients := formatRace(world, itemsFormat, time.Second, "Leader", "Chaser")
+	leader, chaser := clients[0].ID, clients[1].ID
+
+	race.HandleFishStateUpdate(leader, FishState{MouthCycles: 6})
+	race.HandleFishStateUpdate(chaser, FishState{MouthCycles: 2})
+
+	giveItem(race, leader, ItemBite)
+	if err := race.UseItem(leader); !errors.Is(err, ErrNoTarget) {
+		t.Errorf("biting with nobody ahead: got %v", err)
+	}
+
+	giveItem(race, chaser, ItemBite)
+	if err := race.UseItem(chaser); err != nil {
+		t.Fatal(err)
+	}
+	if lastMessage(clients[0], "itemUsed") == nil {
+		t.Error("racers should be told about the bite")
+	}
+
+	race.HandleFishStateUpdate(leader, FishState{MouthCycles: 8})
+	if state := progressOf(race, leader); state.Progress != 7.0/50 || !state.Slowed {
+		t.Errorf("bitten racer's cycles should count half: %+v", state)
+	}
+}
+
+func TestJellyfishNeedsHeldMouth(t *testing.T) {
+	world := NewRacingWorld()
+	race, clients := formatRace(world, itemsFormat, time.Second, "Nemo")
+	id := clients[0].ID
+
+	race.HandleFishStateUpdate(id, FishState{MouthCycles: 25})
+	state := progressOf(race, id)
+	if state.Progress != JellyfishPositions[0] || !state.Blocked {
+		t.Fatalf("racer should stop at the jellyfish: %+v", state)
+	}
+
+	// Chomping doesn't help, and a quick open isn't enough
+	race.HandleFishStateUpdate(id, FishState{MouthCycles: 30})
+	race.HandleMouthInput(id, true)
+	if state := progressOf(race, id); state.Progress != JellyfishPositions[0] || !state.Blocked {
+		t.Fatalf("racer should still be stuck: %+v", state)
+	}
+
+	race.call(func() { race.checkJellyfish(race.Players[id], time.Now().Add(JellyfishHoldTime)) })
+	race.HandleMouthInput(id, false)
+	race.HandleFishStateUpdate(id, FishState{MouthCycles: 31})
+	if state := progressOf(race, id); state.Blocked || state.Progress != JellyfishPositions[0]+1.0/50 {
+		t.Errorf("holding the mouth open should get past: %+v", state)
+	}
+}
+
+func TestItemsOnlyWhenEnabled(t *testing.T) {
+	world := NewRacingWorld()
+	race, clients := startedRace(world, time.Second, "Nemo")
+	if err := race.UseItem(clients[0].ID); !errors.Is(err, ErrItemsDisabled) {
+		t.Errorf("using an item in a plain race: got %v", err)
+	}
+
+	if _, err := (RaceFormat{Type: RaceFormatTimeTrial, Items: true}).Normalize(); !errors.Is(err, ErrInvalidFormat) {
+		t.Errorf("items in a time trial: got %v", err)
+	}
+}
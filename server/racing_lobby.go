@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+// Reasons a private lobby request is refused, sent to the client in lobbyError
+var (
+	ErrLobbyNotFound = errors.New("no lobby with that code")
+	ErrLobbyFull     = errors.New("lobby is full")
+	ErrLobbyStarted  = errors.New("race has already started")
+	ErrNotHost       = errors.New("only the host can do that")
+	ErrKicked        = errors.New("you were removed from this lobby")
+)
+
+// LobbyErrorPayload explains why a createLobby, joinLobby, kick or startRace failed
+type LobbyErrorPayload struct {
+	Reason string `json:"reason"`
+}
+
+// KickedPayload tells a player they were removed from a lobby, by the host or for
+// sitting idle
+type KickedPayload struct {
+	RaceID string `json:"raceId"`
+	Reason string `json:"reason,omitempty"` // "idle", or empty when kicked by the host
+}
+
+// Start runs lobby expiry, matchmaking and the race reaper once a second until ctx is
+// cancelled. Each race runs its own timers.
+func (rw *RacingWorld) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				rw.ExpireLobbies(now)
+				rw.Matchmake(now)
+				rw.ReapRaces(now)
+			}
+		}
+	}()
+}
+
+// CreateLobby opens a private lobby with client as host, running races in the given
+// normalized format. maxPlayers is clamped to 1..RaceMaxPlayers, with 0 meaning
+// RaceMaxPlayers.
+func (rw *RacingWorld) CreateLobby(client *RacingClient, playerName, model string, maxPlayers int, format RaceFormat) (*Race, RaceWelcomePayload) {
+	if maxPlayers <= 0 || maxPlayers > RaceMaxPlayers {
+		maxPlayers = RaceMaxPlayers
+	}
+
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	code := NewLobbyCode()
+	for rw.Lobbies[code] != nil {
+		code = NewLobbyCode()
+	}
+	race := rw.createRace(code, maxPlayers, client.ID, format)
+	rw.Lobbies[code] = race
+
+	var welcome RaceWelcomePayload
+	race.call(func() {
+		player := race.addPlayer(client, playerName, model)
+		log.Printf("Player %s created private lobby %s (race %s, max %d players, %s)", player.Name, code, race.ID, maxPlayers, format)
+		race.BroadcastState()
+		welcome = race.welcome(player)
+	})
+	return race, welcome
+}
+
+// JoinLobby adds client to the private lobby with the given code. Codes are
+// case-insensitive.
+func (rw *RacingWorld) JoinLobby(client *RacingClient, code, playerName, model string) (*Race, RaceWelcomePayload, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	rw.mu.RLock()
+	race := rw.Lobbies[code]
+	rw.mu.RUnlock()
+	if race == nil {
+		return nil, RaceWelcomePayload{}, ErrLobbyNotFound
+	}
+
+	var welcome RaceWelcomePayload
+	err := ErrLobbyNotFound // If the lobby expired since the lookup
+	race.call(func() {
+		switch {
+		case race.Kicked[client.ID]:
+			err = ErrKicked
+		case race.State != RaceStateLobby:
+			err = ErrLobbyStarted
+		case len(race.Players) >= race.MaxPlayers:
+			err = ErrLobbyFull
+		default:
+			err = nil
+			player := race.addPlayer(client, playerName, model)
+			if race.HostID == "" {
+				race.HostID = client.ID // Everyone had left; the first back hosts
+			}
+			log.Printf("Player %s joined private lobby %s (%d/%d players)", player.Name, code, len(race.Players), race.MaxPlayers)
+			race.BroadcastState()
+			welcome = race.welcome(player)
+		}
+	})
+	if err != nil {
+		return nil, RaceWelcomePayload{}, err
+	}
+	return race, welcome, nil
+}
+
+// KickPlayer lets the host remove a player from a private lobby before the race starts
+func (r *Race) KickPlayer(hostID, playerID string) error {
+	err := ErrLobbyNotFound
+	r.call(func() {
+		player, exists := r.Players[playerID]
+		switch {
+		case r.Code == "" || r.HostID != hostID:
+			err = ErrNotHost
+		case r.State != RaceStateLobby:
+			err = ErrLobbyStarted
+		case !exists || playerID == hostID:
+			err = errors.New("no such player")
+		default:
+			err = nil
+			delete(r.Players, playerID)
+			r.Kicked[playerID] = true
+			log.Printf("Host %s kicked %s from lobby %s", hostID, player.Name, r.Code)
+
+			if player.Client != nil {
+				player.Client.SendMessage(RacingServerMessage{
+					Type:    "kicked",
+					Payload: KickedPayload{RaceID: r.ID},
+				})
+			}
+			r.BroadcastState()
+		}
+	})
+	return err
+}
+
+// HostStart starts a private lobby's countdown at the host's request, whether or not
+// everyone is ready
+func (r *Race) HostStart(hostID string) error {
+	err := ErrLobbyNotFound
+	r.call(func() {
+		switch {
+		case r.Code == "" || r.HostID != hostID:
+			err = ErrNotHost
+		case r.State != RaceStateLobby:
+			err = ErrLobbyStarted
+		case r.World.IsDraining():
+			err = errors.New(shutdownReason)
+		default:
+			err = nil
+			log.Printf("Host %s started lobby %s with %d players", hostID, r.Code, len(r.Players))
+			r.startCountdown(time.Now())
+		}
+	})
+	return err
+}
+
+// SetFormat lets the host change a private lobby's race format before it starts.
+// format must already be normalized.
+func (r *Race) SetFormat(hostID string, format RaceFormat) error {
+	err := ErrLobbyNotFound
+	r.call(func() {
+		switch {
+		case r.Code == "" || r.HostID != hostID:
+			err = ErrNotHost
+		case r.State != RaceStateLobby:
+			err = ErrLobbyStarted
+		default:
+			err = nil
+			r.Format = format
+			log.Printf("Lobby %s format set to %s", r.Code, format)
+			r.BroadcastState()
+		}
+	})
+	return err
+}
+
+// passHost hands the host role to the longest-present player if leaverID was host
+func (r *Race) passHost(leaverID string) {
+	if r.Code == "" || r.HostID != leaverID {
+		return
+	}
+
+	r.HostID = ""
+	var earliest time.Time
+	for id, player := range r.Players {
+		if r.HostID == "" || player.JoinedAt.Before(earliest) {
+			r.HostID = id
+			earliest = player.JoinedAt
+		}
+	}
+	if r.HostID != "" {
+		log.Printf("Lobby %s host passed to %s", r.Code, r.Players[r.HostID].Name)
+	}
+}
+
+// removeRace forgets a race and its lobby code and stops its goroutine. Caller holds rw.mu.
+func (rw *RacingWorld) removeRace(race *Race) {
+	delete(rw.Races, race.ID)
+	if race.Code != "" && rw.Lobbies[race.Code] == race {
+		delete(rw.Lobbies, race.Code)
+	}
+	race.Stop()
+}
+
+// ExpireLobbies removes private lobbies that have been empty for LobbyExpiryTime
+func (rw *RacingWorld) ExpireLobbies(now time.Time) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	for code, race := range rw.Lobbies {
+		snap := race.Snapshot()
+		if len(snap.Players) == 0 && !snap.EmptySince.IsZero() &&
+			now.Sub(snap.EmptySince) >= LobbyExpiryTime*time.Second {
+			log.Printf("Private lobby %s expired", code)
+			rw.removeRace(race)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRacer(world *RacingWorld) *RacingClient {
+	return NewRacingClient(NewClientID(), nil, world)
+}
+
+// lastMessage returns the most recent queued message of msgType, or nil
+func lastMessage(c *RacingClient, msgType string) json.RawMessage {
+	var found json.RawMessage
+	for {
+		select {
+		case data := <-c.Send:
+			var msg struct {
+				Type    string          `json:"type"`
+				Payload json.RawMessage `json:"payload"`
+			}
+			json.Unmarshal(data, &msg)
+			if msg.Type == msgType {
+				found = msg.Payload
+			}
+		default:
+			return found
+		}
+	}
+}
+
+func TestPrivateLobbyJoinByCode(t *testing.T) {
+	world := NewRacingWorld()
+	host := newTestRacer(world)
+	race, _ := world.CreateLobby(host, "Host", "shark", 2, DefaultRaceFormat())
+
+	if len(race.Code) != LobbyCodeLength {
+		t.Fatalf("code %q should be %d characters", race.Code, LobbyCodeLength)
+	}
+	if race == world.WaitingLobby {
+		t.Fatal("a private lobby must not be the public waiting lobby")
+	}
+
+	if _, _, err := world.JoinLobby(newTestRacer(world), "NOPE00", "Guest", "shark"); !errors.Is(err, ErrLobbyNotFound) {
+		t.Errorf("unknown code: got %v", err)
+	}
+
+	guest := newTestRacer(world)
+	joined, _, err := world.JoinLobby(guest, " "+strings.ToLower(race.Code)+" ", "Guest", "shark")
+	if err != nil || joined != race {
+		t.Fatalf("join with lower-case code: race %v, err %v", joined, err)
+	}
+
+	if _, _, err := world.JoinLobby(newTestRacer(world), race.Code, "Third", "shark"); !errors.Is(err, ErrLobbyFull) {
+		t.Errorf("joining a full lobby: got %v", err)
+	}
+
+	// Strangers joining the public lobby never land in the private one
+	public, _ := world.JoinRace(newTestRacer(world), "Stranger", "shark")
+	if public == race {
+		t.Error("public join went into a private lobby")
+	}
+
+	var state RaceStatePayload
+	json.Unmarshal(lastMessage(guest, "raceState"), &state)
+	if state.LobbyCode != race.Code || state.HostID != host.ID || state.MaxPlayers != 2 {
+		t.Errorf("raceState should describe the lobby: %+v", state)
+	}
+}
+
+func TestPrivateLobbyHostControls(t *testing.T) {
+	world := NewRacingWorld()
+	host := newTestRacer(world)
+	race, _ := world.CreateLobby(host, "Host", "shark", 0, DefaultRaceFormat())
+	if max := race.Snapshot().MaxPlayers; max != RaceMaxPlayers {
+		t.Errorf("MaxPlayers = %d, want the default %d", max, RaceMaxPlayers)
+	}
+
+	guest := newTestRacer(world)
+	world.JoinLobby(guest, race.Code, "Guest", "shark")
+
+	if err := race.KickPlayer(guest.ID, host.ID); !errors.Is(err, ErrNotHost) {
+		t.Errorf("guest kicking host: got %v", err)
+	}
+	if err := race.HostStart(guest.ID); !errors.Is(err, ErrNotHost) {
+		t.Errorf("guest starting: got %v", err)
+	}
+
+	// Readiness alone doesn't start a private lobby
+	race.HandlePlayerReady(host.ID)
+	race.HandlePlayerReady(guest.ID)
+	if raceState(race) != RaceStateLobby {
+		t.Fatal("private lobby should wait for the host to start")
+	}
+
+	if err := race.KickPlayer(host.ID, guest.ID); err != nil {
+		t.Fatalf("host kick: %v", err)
+	}
+	if lastMessage(guest, "kicked") == nil {
+		t.Error("kicked player should be told")
+	}
+	if _, _, err := world.JoinLobby(guest, race.Code, "Guest", "shark"); !errors.Is(err, ErrKicked) {
+		t.Errorf("kicked player rejoining: got %v", err)
+	}
+
+	if err := race.HostStart(host.ID); err != nil {
+		t.Fatalf("host start: %v", err)
+	}
+	if state := raceState(race); state != RaceStateCountdown {
+		t.Errorf("state after host start = %v, want countdown", state)
+	}
+	if _, _, err := world.JoinLobby(newTestRacer(world), race.Code, "Late", "shark"); !errors.Is(err, ErrLobbyStarted) {
+		t.Errorf("joining a started lobby: got %v", err)
+	}
+}
+
+func TestPrivateLobbyHostHandoverAndExpiry(t *testing.T) {
+	world := NewRacingWorld()
+	host := newTestRacer(world)
+	race, _ := world.CreateLobby(host, "Host", "shark", 0, DefaultRaceFormat())
+
+	second := newTestRacer(world)
+	world.JoinLobby(second, race.Code, "Second", "shark")
+	time.Sleep(time.Millisecond) // Distinct join times
+	world.JoinLobby(newTestRacer(world), race.Code, "Third", "shark")
+
+	race.DisconnectPlayer(host.ID)
+	if race.Snapshot().HostID != second.ID {
+		t.Errorf("host should pass to the longest-present player")
+	}
+
+	for _, player := range race.Snapshot().Players {
+		race.DisconnectPlayer(player.ID)
+	}
+
+	world.ExpireLobbies(time.Now())
+	if world.GetRace(race.ID) == nil {
+		t.Fatal("an empty lobby should be kept for a while")
+	}
+
+	world.ExpireLobbies(time.Now().Add(LobbyExpiryTime * time.Second))
+	if world.GetRace(race.ID) != nil {
+		t.Fatal("an expired lobby should be removed")
+	}
+	if _, _, err := world.JoinLobby(newTestRacer(world), race.Code, "Late", "shark"); !errors.Is(err, ErrLobbyNotFound) {
+		t.Errorf("joining an expired lobby: got %v", err)
+	}
+}
+
+func raceState(race *Race) RaceState {
+	return race.Snapshot().State
+}
+
+func TestLobbyTimer(t *testing.T) {
+	world := NewRacingWorld()
+	first := newTestRacer(world)
+	race, _ := world.JoinRace(first, "First", "shark")
+	var deadline time.Time
+	race.call(func() { deadline = race.LobbyDeadline })
+	if !deadline.IsZero() {
+		t.Fatal("the timer should wait for a second player")
+	}
+
+	start := time.Now()
+	world.JoinRace(newTestRacer(world), "Second", "shark")
+	race.call(func() { deadline = race.LobbyDeadline })
+	if deadline.Sub(start) < (RaceLobbyWaitTime-1)*time.Second {
+		t.Fatalf("timer should run for RaceLobbyWaitTime, deadline in %s", deadline.Sub(start))
+	}
+
+	var state RaceStatePayload
+	json.Unmarshal(lastMessage(first, "raceState"), &state)
+	if state.TimeRemaining <= 0 || state.TimeRemaining > RaceLobbyWaitTime {
+		t.Errorf("lobby timeRemaining = %v, want the running timer", state.TimeRemaining)
+	}
+
+	// A late join leaves at least RaceLobbyJoinExtension on the clock, never beyond RaceLobbyMaxWait
+	var extended, capped time.Time
+	race.call(func() {
+		race.extendLobbyTimer(deadline.Add(-time.Second))
+		extended = race.LobbyDeadline
+		race.extendLobbyTimer(start.Add(RaceLobbyMaxWait * time.Second))
+		capped = race.LobbyDeadline
+	})
+	if want := deadline.Add((RaceLobbyJoinExtension - 1) * time.Second); !extended.Equal(want) {
+		t.Errorf("extended deadline %s, want %s", extended.Sub(start), want.Sub(start))
+	}
+	if capped.Sub(start) > RaceLobbyMaxWait*time.Second+time.Millisecond*100 {
+		t.Errorf("deadline %s past RaceLobbyMaxWait", capped.Sub(start))
+	}
+
+	race.call(func() { race.lobbyTick(capped.Add(-time.Second)) })
+	if raceState(race) != RaceStateLobby {
+		t.Fatal("race started before the deadline")
+	}
+	race.call(func() { race.lobbyTick(capped) })
+	if raceState(race) != RaceStateCountdown {
+		t.Fatal("race should start with whoever is present once the timer runs out")
+	}
+}
+
+func TestLobbyTimerStopsAndRemovesIdlePlayers(t *testing.T) {
+	world := NewRacingWorld()
+	idle := newTestRacer(world)
+	race, _ := world.JoinRace(idle, "Idle", "shark")
+	other := newTestRacer(world)
+	world.JoinRace(other, "Other", "shark")
+
+	race.DisconnectPlayer(other.ID)
+	running := true
+	race.call(func() {
+		race.lobbyTick(time.Now())
+		running = !race.LobbyDeadline.IsZero()
+	})
+	if running {
+		t.Fatal("the timer should stop when fewer than two players remain")
+	}
+
+	race.call(func() { race.lobbyTick(time.Now().Add(RaceLobbyIdleTime * time.Second)) })
+	if len(race.Snapshot().Players) != 0 {
+		t.Fatal("an unready player should be removed after RaceLobbyIdleTime")
+	}
+
+	var kicked KickedPayload
+	json.Unmarshal(lastMessage(idle, "kicked"), &kicked)
+	if kicked.Reason != "idle" {
+		t.Errorf("idle player should be told why, got %+v", kicked)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Skill ratings and matchmaking. Every racer has an Elo-style rating, keyed by name
+// skeleton like ghosts, updated from their rank after each race with two or more
+// racers. Players who queue are grouped with others near their rating, the allowed gap
+// widening the longer they wait, and the group is put straight into a new race.
+
+// Rating constants
+const (
+	InitialRating          = 1200.0
+	RatingK                = 32.0 // Largest swing per race, spread across every opponent
+	RatingProvisionalK     = 64.0 // Faster movement while a racer's rating settles
+	RatingProvisionalRaces = 10   // Races before a rating counts as settled
+)
+
+// Matchmaking constants
+const (
+	MatchBandStart     = 100.0 // Rating gap allowed as soon as a player queues
+	MatchBandGrowth    = 25.0  // Extra gap allowed per second of waiting
+	MatchBandMax       = 800.0
+	MatchTargetPlayers = 4  // A group this big starts at once
+	MatchGroupWait     = 10 // Seconds before a smaller group of at least RaceMinPlayers starts
+)
+
+// Rating is a racer's skill rating
+type Rating struct {
+	Rating float64
+	Races  int
+}
+
+// RatingStore keeps every racer's rating. There are no accounts, so ratings aren't
+// tied to a player: they're keyed by name skeleton, and anyone racing under a name or
+// a lookalike of it shares that name's rating. It has its own lock, so race goroutines
+// can update it without touching the racing world's lock.
+type RatingStore struct {
+	ratings map[string]*Rating // By name skeleton
+	mu      sync.Mutex
+}
+
+// NewRatingStore creates an empty rating store
+func NewRatingStore() *RatingStore {
+	return &RatingStore{ratings: make(map[string]*Rating)}
+}
+
+// Get returns the rating for a racer called name, InitialRating if they're new
+func (s *RatingStore) Get(name string) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rating, ok := s.ratings[NameSkeleton(name)]; ok {
+		return rating.Rating
+	}
+	return InitialRating
+}
+
+// Update adjusts ratings from a ranked race's results and fills in each result's new
+// rating and change. Each racer is scored against every other as a head-to-head: a
+// win for finishing ahead, a draw for the same rank.
+func (s *RatingStore) Update(results []RaceResult) {
+	if len(results) < 2 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := make([]*Rating, len(results))
+	before := make([]float64, len(results))
+	for i, result := range results {
+		key := NameSkeleton(result.Name)
+		if s.ratings[key] == nil {
+			s.ratings[key] = &Rating{Rating: InitialRating}
+		}
+		entries[i] = s.ratings[key]
+		before[i] = entries[i].Rating
+	}
+
+	for i := range results {
+		var score, expected float64
+		for j := range results {
+			if i == j {
+				continue
+			}
+			expected += 1 / (1 + math.Pow(10, (before[j]-before[i])/400))
+			switch {
+			case results[i].Rank < results[j].Rank:
+				score++
+			case results[i].Rank == results[j].Rank:
+				score += 0.5
+			}
+		}
+
+		k := RatingK
+		if entries[i].Races < RatingProvisionalRaces {
+			k = RatingProvisionalK
+		}
+		change := k * (score - expected) / float64(len(results)-1)
+		entries[i].Rating += change
+		entries[i].Races++
+
+		results[i].Rating = math.Round(entries[i].Rating)
+		results[i].RatingChange = math.Round(change*10) / 10
+	}
+}
+
+// QueueEntry is a player waiting for a match
+type QueueEntry struct {
+	Client *RacingClient
+	Name   string
+	Model  string
+	Rating float64
+	Since  time.Time
+}
+
+// band returns the rating gap the entry accepts after waiting until now
+func (e *QueueEntry) band(now time.Time) float64 {
+	return math.Min(MatchBandStart+MatchBandGrowth*now.Sub(e.Since).Seconds(), MatchBandMax)
+}
+
+// QueuedPayload confirms a player is waiting for a match
+type QueuedPayload struct {
+	Rating  float64 `json:"rating"`
+	Waiting int     `json:"waiting"` // Players in the queue, including you
+}
+
+// MatchQueue holds players waiting for a match, oldest first
+type MatchQueue struct {
+	entries []*QueueEntry
+	mu      sync.Mutex
+}
+
+// Add queues a player, replacing any entry they already have. Returns how many are
+// waiting.
+func (q *MatchQueue) Add(entry *QueueEntry) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.remove(entry.Client.ID)
+	q.entries = append(q.entries, entry)
+	return len(q.entries)
+}
+
+// Remove takes a player out of the queue. Returns false if they weren't in it.
+func (q *MatchQueue) Remove(clientID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.remove(clientID)
+}
+
+// remove is Remove with q.mu held
+func (q *MatchQueue) remove(clientID string) bool {
+	for i, entry := range q.entries {
+		if entry.Client.ID == clientID {
+			q.entries = append(q.entries[:i], q.entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns how many players are waiting
+func (q *MatchQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+// TakeGroups removes and returns every group that can race now. Starting from whoever
+// has waited longest, each group is the players within that player's band, closest
+// rating first, up to RaceMaxPlayers. It goes ahead once it reaches
+// MatchTargetPlayers, or RaceMinPlayers after its oldest player has waited
+// MatchGroupWait.
+func (q *MatchQueue) TakeGroups(now time.Time) [][]*QueueEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	taken := make(map[*QueueEntry]bool)
+	var groups [][]*QueueEntry
+	for _, anchor := range q.entries {
+		if taken[anchor] {
+			continue
+		}
+
+		band := anchor.band(now)
+		group := []*QueueEntry{anchor}
+		for _, other := range q.entries {
+			if other != anchor && !taken[other] && math.Abs(other.Rating-anchor.Rating) <= band {
+				group = append(group, other)
+			}
+		}
+		sort.SliceStable(group[1:], func(i, j int) bool {
+			return math.Abs(group[1+i].Rating-anchor.Rating) < math.Abs(group[1+j].Rating-anchor.Rating)
+		})
+		if len(group) > RaceMaxPlayers {
+			group = group[:RaceMaxPlayers]
+		}
+
+		waited := now.Sub(anchor.Since) >= MatchGroupWait*time.Second
+		if len(group) >= MatchTargetPlayers || (len(group) >= RaceMinPlayers && waited) {
+			for _, entry := range group {
+				taken[entry] = true
+			}
+			groups = append(groups, group)
+		}
+	}
+
+	if len(groups) > 0 {
+		remaining := q.entries[:0]
+		for _, entry := range q.entries {
+			if !taken[entry] {
+				remaining = append(remaining, entry)
+			}
+		}
+		q.entries = remaining
+	}
+	return groups
+}
+
+// Matchmake starts a race for every group the queue can form
+func (rw *RacingWorld) Matchmake(now time.Time) {
+	if rw.IsDraining() {
+		return
+	}
+	for _, group := range rw.Queue.TakeGroups(now) {
+		rw.startMatch(group)
+	}
+}
+
+// startMatch puts a matched group into a new race and starts its countdown
+func (rw *RacingWorld) startMatch(group []*QueueEntry) {
+	welcomes := make([]RaceWelcomePayload, len(group))
+
+	// Held until the players are in, so the reaper can't take the empty race
+	rw.mu.Lock()
+	race := rw.createRace("", len(group), "", DefaultRaceFormat())
+	race.call(func() {
+		for i, entry := range group {
+			welcomes[i] = race.welcome(race.addPlayer(entry.Client, entry.Name, entry.Model))
+		}
+	})
+	rw.mu.Unlock()
+
+	ratings := make([]float64, len(group))
+	for i, entry := range group {
+		ratings[i] = entry.Rating
+		if !entry.Client.enterMatchedRace(race, welcomes[i]) {
+			race.DisconnectPlayer(entry.Client.ID)
+		}
+	}
+	log.Printf("Matched %d players into race %s (ratings %v)", len(group), race.ID, ratings)
+
+	race.call(func() {
+		if len(race.Players) > 0 {
+			race.startCountdown(time.Now())
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRatingUpdate(t *testing.T) {
+	store := NewRatingStore()
+	results := []RaceResult{{Name: "Winner", Rank: 1}, {Name: "Middle", Rank: 2}, {Name: "Last", Rank: 3, DNF: true}}
+	store.Update(results)
+
+	if results[0].RatingChange <= 0 || results[2].RatingChange >= 0 || results[1].RatingChange != 0 {
+		t.Errorf("changes = %v, %v, %v; want the winner up, the middle even, the last down",
+			results[0].RatingChange, results[1].RatingChange, results[2].RatingChange)
+	}
+	if got := store.Get("winner"); got != InitialRating+RatingProvisionalK/2 {
+		t.Errorf("winner's rating = %v, want %v", got, InitialRating+RatingProvisionalK/2)
+	}
+
+	// Beating a much weaker field earns less than beating equals
+	store.Update([]RaceResult{{Name: "Winner", Rank: 1}, {Name: "Last", Rank: 2}})
+	again := []RaceResult{{Name: "Winner", Rank: 1}, {Name: "Last", Rank: 2}}
+	store.Update(again)
+	if again[0].RatingChange >= RatingProvisionalK/2 {
+		t.Errorf("favourite's win moved them %v", again[0].RatingChange)
+	}
+
+	// Ratings belong to the name, so lookalikes share one
+	if store.Get("W1nner") != store.Get("Winner") || store.Get("ＷＩＮＮＥＲ") != store.Get("Winner") {
+		t.Error("lookalike names should share a rating")
+	}
+
+	// Solo races aren't rated
+	solo := []RaceResult{{Name: "Alone", Rank: 1}}
+	store.Update(solo)
+	if solo[0].Rating != 0 || store.Get("Alone") != InitialRating {
+		t.Error("a one-racer race should leave ratings alone")
+	}
+}
+
+func TestMatchQueueWidensBand(t *testing.T) {
+	world := NewRacingWorld()
+	start := time.Now()
+	queue := &MatchQueue{}
+	entry := func(rating float64) *QueueEntry {
+		return &QueueEntry{Client: newTestRacer(world), Rating: rating, Since: start}
+	}
+	low, nearLow, high := entry(1000), entry(1050), entry(1400)
+	queue.Add(low)
+	queue.Add(nearLow)
+	queue.Add(high)
+
+	if groups := queue.TakeGroups(start); len(groups) != 0 {
+		t.Fatalf("a pair shouldn't start before MatchGroupWait: %v", groups)
+	}
+
+	groups := queue.TakeGroups(start.Add(MatchGroupWait * time.Second))
+	if len(groups) != 1 || len(groups[0]) != 2 || groups[0][0] != low || groups[0][1] != nearLow {
+		t.Fatalf("the close pair should be matched, leaving the outlier: %v", groups)
+	}
+	if queue.Len() != 1 {
+		t.Fatalf("matched players should leave the queue, %d left", queue.Len())
+	}
+
+	// Given time, the outlier's band reaches someone far away
+	far := entry(1000)
+	queue.Add(far)
+	if groups := queue.TakeGroups(start.Add(MatchGroupWait * time.Second)); len(groups) != 0 {
+		t.Fatal("a 400 gap is too wide after a short wait")
+	}
+	wait := time.Duration((400-MatchBandStart)/MatchBandGrowth) * time.Second
+	if groups := queue.TakeGroups(start.Add(wait)); len(groups) != 1 {
+		t.Fatalf("the band should widen to 400 after %s", wait)
+	}
+}
+
+func TestMatchmakeStartsRace(t *testing.T) {
+	world := NewRacingWorld()
+	clients := make([]*RacingClient, MatchTargetPlayers)
+	for i := range clients {
+		clients[i] = newTestRacer(world)
+		world.Queue.Add(&QueueEntry{Client: clients[i], Name: "Racer", Model: "shark", Rating: InitialRating, Since: time.Now()})
+	}
+	world.Queue.Remove(clients[0].ID)
+
+	// One more so the group is full without clients[0]
+	extra := newTestRacer(world)
+	world.Queue.Add(&QueueEntry{Client: extra, Name: "Extra", Model: "shark", Rating: InitialRating, Since: time.Now()})
+
+	world.Matchmake(time.Now())
+	race := clients[1].CurrentRace()
+	if race == nil || race == world.WaitingLobby {
+		t.Fatal("queued players should be put in a new race")
+	}
+	if clients[0].CurrentRace() != nil {
+		t.Error("a player who left the queue must not be matched")
+	}
+	if raceState(race) != RaceStateCountdown || len(race.Snapshot().Players) != MatchTargetPlayers {
+		t.Errorf("matched race should count down with everyone: %s, %d players", raceState(race), len(race.Snapshot().Players))
+	}
+
+	var welcome RaceWelcomePayload
+	json.Unmarshal(lastMessage(clients[1], "welcome"), &welcome)
+	if welcome.RaceID != race.ID {
+		t.Errorf("welcome should name the matched race: %+v", welcome)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"log"
+	"math"
 	"net/http"
 	"sync"
 	"time"
@@ -13,10 +14,13 @@ import (
 // RacingClient represents a connected racing WebSocket client
 type RacingClient struct {
 	ID           string
+	IP           string
 	Conn         *websocket.Conn
 	Send         chan []byte
 	RacingWorld  *RacingWorld
-	Race         *Race
+	Race         *Race       // Guarded by mu: the matchmaker may place the client in a race
+	Spectating   *Race       // Race being watched, guarded by mu
+	closed       bool        // Send has been closed, guarded by mu
 	mu           sync.Mutex
 }
 
@@ -33,14 +37,29 @@ func NewRacingClient(id string, conn *websocket.Conn, world *RacingWorld) *Racin
 // HandleRacingWebSocket handles WebSocket connections for racing
 func HandleRacingWebSocket(racingWorld *RacingWorld) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		ip := ClientIP(r)
+		if moderation.IsBanned(ip) {
+			log.Printf("Refusing racing connection from banned IP %s", ip)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if !racingConnLimit.Acquire(ip) {
+			log.Printf("Refusing racing connection from %s: %d connections already open", ip, racingConnLimit.Max)
+			http.Error(w, "Too many connections", http.StatusTooManyRequests)
+			return
+		}
+
 		conn, err := upgrader.Upgrade(w, r, nil)
 		if err != nil {
-			log.Printf("Failed to upgrade racing connection: %v", err)
+			log.Printf("Failed to upgrade racing connection from %s: %v", ip, err)
+			racingConnLimit.Release(ip)
 			return
 		}
 
-		clientID := generateClientID()
+		clientID := NewClientID()
 		client := NewRacingClient(clientID, conn, racingWorld)
+		client.IP = ip
+		racingWorld.AddClient(client)
 
 		log.Printf("Racing client connected: %s", clientID)
 
@@ -54,9 +73,13 @@ func HandleRacingWebSocket(racingWorld *RacingWorld) http.HandlerFunc {
 func (c *RacingClient) ReadPump() {
 	defer func() {
 		c.Disconnect()
+		c.RacingWorld.RemoveClient(c)
 		c.Conn.Close()
+		racingConnLimit.Release(c.IP)
 	}()
 
+	limiter := NewMessageLimiter(RacingRateLimits)
+	c.Conn.SetReadLimit(MaxMessageSize)
 	c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.Conn.SetPongHandler(func(string) error {
 		c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
@@ -81,6 +104,16 @@ func (c *RacingClient) ReadPump() {
 		}
 
 		log.Printf("ReadPump parsed message type: %s", msg.Type)
+
+		if allowed, abusive := limiter.Allow(msg.Type, time.Now()); !allowed {
+			if abusive {
+				log.Printf("Racing client %s (%s) exceeded rate limits, disconnecting", c.ID, c.IP)
+				closeRateLimited(c.Conn)
+				break
+			}
+			continue
+		}
+
 		c.HandleMessage(msg)
 	}
 }
@@ -122,54 +155,159 @@ func (c *RacingClient) WritePump() {
 // HandleMessage processes incoming messages from racing clients
 func (c *RacingClient) HandleMessage(msg RacingClientMessage) {
 	log.Printf("HandleMessage called for client %s with message type: %s", c.ID, msg.Type)
+	race := c.CurrentRace()
 	
 	switch msg.Type {
 	case "join":
 		log.Printf("HandleMessage: Processing join case for client %s", c.ID)
+		if msg.Ghost != "" && msg.Ghost != GhostPersonalBest && msg.Ghost != GhostRecord {
+			c.sendLobbyError(ErrUnknownGhost)
+			break
+		}
+		name, ok := c.checkJoin(msg)
+		if !ok {
+			break
+		}
+
+		// Race a ghost on your own
+		if msg.Ghost != "" {
+			race, welcome := c.RacingWorld.JoinSoloRace(c, name, msg.Model, msg.Ghost)
+			c.enterRace(race, welcome)
+			break
+		}
+
 		// Join the waiting lobby
-		race := c.RacingWorld.JoinRace(c, msg.Name, msg.Model)
+		race, welcome := c.RacingWorld.JoinRace(c, name, msg.Model)
 		log.Printf("HandleMessage: JoinRace returned, race ID: %s", race.ID)
-		c.Race = race
+		c.enterRace(race, welcome)
 
-		// Send welcome message
-		log.Printf("HandleMessage: Creating welcome payload for client %s", c.ID)
-		welcome := RaceWelcomePayload{
-			PlayerID:  c.ID,
-			RaceID:    race.ID,
-			Name:      msg.Name,
-			Model:     msg.Model,
-			RaceState: race.StateString(),
+	case "createLobby":
+		format, err := msg.Format.Normalize()
+		if err != nil {
+			c.sendLobbyError(err)
+			break
+		}
+		name, ok := c.checkJoin(msg)
+		if !ok {
+			break
 		}
+		race, welcome := c.RacingWorld.CreateLobby(c, name, msg.Model, msg.MaxPlayers, format)
+		c.enterRace(race, welcome)
 
-		log.Printf("HandleMessage: Sending welcome message for client %s", c.ID)
-		c.SendMessage(RacingServerMessage{
-			Type:    "welcome",
-			Payload: welcome,
-		})
+	case "joinLobby":
+		name, ok := c.checkJoin(msg)
+		if !ok {
+			break
+		}
+		race, welcome, err := c.RacingWorld.JoinLobby(c, msg.Code, name, msg.Model)
+		if err != nil {
+			log.Printf("Client %s could not join lobby %q: %v", c.ID, msg.Code, err)
+			c.sendLobbyError(err)
+			break
+		}
+		c.enterRace(race, welcome)
 
-		log.Printf("Player %s joined racing as %s, sent welcome to race %s", c.ID, msg.Name, race.ID)
+	case "kick":
+		if race == nil {
+			break
+		}
+		if err := race.KickPlayer(c.ID, msg.PlayerID); err != nil {
+			c.sendLobbyError(err)
+		}
+
+	case "setFormat":
+		if race == nil {
+			break
+		}
+		format, err := msg.Format.Normalize()
+		if err == nil {
+			err = race.SetFormat(c.ID, format)
+		}
+		if err != nil {
+			c.sendLobbyError(err)
+		}
+
+	case "startRace":
+		if race == nil {
+			break
+		}
+		if err := race.HostStart(c.ID); err != nil {
+			c.sendLobbyError(err)
+		}
 
 	case "ready":
 		// Player clicked ready
-		log.Printf("Ready message received from client %s, race is nil: %v", c.ID, c.Race == nil)
-		if c.Race != nil {
+		log.Printf("Ready message received from client %s, race is nil: %v", c.ID, race == nil)
+		if race != nil {
 			log.Printf("Calling HandlePlayerReady for client %s", c.ID)
-			c.Race.HandlePlayerReady(c.ID)
+			race.HandlePlayerReady(c.ID)
 		} else {
 			log.Printf("ERROR: Ready message but client %s has no race!", c.ID)
 		}
 
 	case "stateUpdate":
 		// Handle fish state update from client
-		log.Printf("Received state update from client %s, race is nil: %v", c.ID, c.Race == nil)
-		if c.Race == nil {
+		log.Printf("Received state update from client %s, race is nil: %v", c.ID, race == nil)
+		if race == nil {
 			log.Printf("ERROR: Client %s has no race assigned!", c.ID)
 			break
 		}
-		log.Printf("Calling HandleFishStateUpdate with fishState: %+v, race ID: %s", msg.FishState, c.Race.ID)
-		c.Race.HandleFishStateUpdate(c.ID, msg.FishState)
+		log.Printf("Calling HandleFishStateUpdate with fishState: %+v, race ID: %s", msg.FishState, race.ID)
+		race.HandleFishStateUpdate(c.ID, msg.FishState)
 		log.Printf("HandleFishStateUpdate completed for client %s", c.ID)
 
+	case "queue":
+		name, ok := c.checkJoin(msg)
+		if !ok {
+			break
+		}
+		rating := c.RacingWorld.Ratings.Get(name)
+		waiting := c.RacingWorld.Queue.Add(&QueueEntry{
+			Client: c,
+			Name:   name,
+			Model:  msg.Model,
+			Rating: rating,
+			Since:  time.Now(),
+		})
+		log.Printf("Client %s queued for a match as %s (rating %.0f, %d waiting)", c.ID, name, rating, waiting)
+		c.SendMessage(RacingServerMessage{
+			Type:    "queued",
+			Payload: QueuedPayload{Rating: math.Round(rating), Waiting: waiting},
+		})
+
+	case "leaveQueue":
+		if c.RacingWorld.Queue.Remove(c.ID) {
+			log.Printf("Client %s left the match queue", c.ID)
+		}
+
+	case "mouthInput":
+		if race != nil {
+			race.HandleMouthInput(c.ID, msg.MouthOpen)
+		}
+
+	case "useItem":
+		if race == nil {
+			break
+		}
+		if err := race.UseItem(c.ID); err != nil {
+			c.SendMessage(RacingServerMessage{
+				Type:    "itemError",
+				Payload: LobbyErrorPayload{Reason: err.Error()},
+			})
+		}
+
+	case "listRaces":
+		c.SendMessage(RacingServerMessage{
+			Type:    "raceList",
+			Payload: RaceListPayload{Races: c.RacingWorld.SpectatableRaces()},
+		})
+
+	case "spectate":
+		c.Spectate(msg.RaceID)
+
+	case "stopSpectating":
+		c.stopSpectating()
+
 	case "ping":
 		// Respond with pong
 		c.SendMessage(RacingServerMessage{
@@ -178,10 +316,144 @@ func (c *RacingClient) HandleMessage(msg RacingClientMessage) {
 	}
 }
 
+// checkJoin validates the name in a join, createLobby, joinLobby or queue message and
+// refuses joins while draining, telling the client why. Leaves the match queue and
+// any current race before a new one is joined.
+func (c *RacingClient) checkJoin(msg RacingClientMessage) (string, bool) {
+	name, err := SanitizeName(msg.Name)
+	if err != nil {
+		log.Printf("Rejected racing name %q from %s: %v", msg.Name, c.ID, err)
+		c.SendMessage(RacingServerMessage{
+			Type:    "nameRejected",
+			Payload: NameRejectedPayload{Reason: err.Error()},
+		})
+		return "", false
+	}
+
+	if c.RacingWorld.IsDraining() {
+		log.Printf("Refusing racing join from %s: server shutting down", c.ID)
+		c.SendMessage(RacingServerMessage{
+			Type:    "serverShutdown",
+			Payload: ShutdownPayload{Reason: shutdownReason},
+		})
+		return "", false
+	}
+
+	c.RacingWorld.Queue.Remove(c.ID)
+	c.stopSpectating()
+	if race := c.CurrentRace(); race != nil {
+		race.DisconnectPlayer(c.ID)
+		c.setRace(nil)
+	}
+	return name, true
+}
+
+// CurrentRace returns the race the client is in, or nil
+func (c *RacingClient) CurrentRace() *Race {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Race
+}
+
+// setRace records the client's race
+func (c *RacingClient) setRace(race *Race) {
+	c.mu.Lock()
+	c.Race = race
+	c.mu.Unlock()
+}
+
+// Spectate leaves any race or queue the client is in and starts watching the race
+// with the given ID, telling the client if it can't
+func (c *RacingClient) Spectate(raceID string) {
+	race := c.RacingWorld.GetRace(raceID)
+	if race == nil {
+		c.sendSpectateError(ErrRaceNotFound)
+		return
+	}
+
+	c.RacingWorld.Queue.Remove(c.ID)
+	c.stopSpectating()
+	if current := c.CurrentRace(); current != nil {
+		current.DisconnectPlayer(c.ID)
+		c.setRace(nil)
+	}
+
+	if err := race.AddSpectator(c); err != nil {
+		c.sendSpectateError(err)
+		return
+	}
+	c.mu.Lock()
+	c.Spectating = race
+	c.mu.Unlock()
+}
+
+// stopSpectating unsubscribes the client from the race it is watching, if any
+func (c *RacingClient) stopSpectating() {
+	c.mu.Lock()
+	race := c.Spectating
+	c.Spectating = nil
+	c.mu.Unlock()
+
+	if race != nil {
+		race.RemoveSpectator(c.ID)
+	}
+}
+
+// sendSpectateError tells the client why it can't watch a race
+func (c *RacingClient) sendSpectateError(err error) {
+	c.SendMessage(RacingServerMessage{
+		Type:    "spectateError",
+		Payload: LobbyErrorPayload{Reason: err.Error()},
+	})
+}
+
+// enterMatchedRace places a queued client in the race the matchmaker found them.
+// Returns false if they disconnected or joined another race in the meantime; the
+// caller then takes them back out.
+func (c *RacingClient) enterMatchedRace(race *Race, welcome RaceWelcomePayload) bool {
+	c.mu.Lock()
+	if c.closed || c.Race != nil {
+		c.mu.Unlock()
+		return false
+	}
+	c.Race = race
+	c.mu.Unlock()
+
+	c.SendMessage(RacingServerMessage{
+		Type:    "welcome",
+		Payload: welcome,
+	})
+	return true
+}
+
+// enterRace records the client's race and sends the welcome
+func (c *RacingClient) enterRace(race *Race, welcome RaceWelcomePayload) {
+	c.setRace(race)
+
+	log.Printf("HandleMessage: Sending welcome message for client %s", c.ID)
+	c.SendMessage(RacingServerMessage{
+		Type:    "welcome",
+		Payload: welcome,
+	})
+
+	log.Printf("Player %s joined racing as %s, sent welcome to race %s", c.ID, welcome.Name, race.ID)
+}
+
+// sendLobbyError tells the client why a lobby request failed
+func (c *RacingClient) sendLobbyError(err error) {
+	c.SendMessage(RacingServerMessage{
+		Type:    "lobbyError",
+		Payload: LobbyErrorPayload{Reason: err.Error()},
+	})
+}
+
 // SendMessage sends a message to the racing client
 func (c *RacingClient) SendMessage(msg RacingServerMessage) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
 
 	data, err := json.Marshal(msg)
 	if err != nil {
@@ -199,11 +471,22 @@ func (c *RacingClient) SendMessage(msg RacingServerMessage) {
 	}
 }
 
-// Disconnect removes the client from the race
+// Disconnect removes the client from the race and closes its send channel. Races
+// may still be sending to it, so SendMessage drops messages once it's closed.
 func (c *RacingClient) Disconnect() {
-	if c.Race != nil {
-		c.Race.DisconnectPlayer(c.ID)
+	c.RacingWorld.Queue.Remove(c.ID)
+	c.stopSpectating()
+
+	c.mu.Lock()
+	race := c.Race
+	if !c.closed {
+		c.closed = true
+		close(c.Send)
+	}
+	c.mu.Unlock()
+
+	if race != nil {
+		race.DisconnectPlayer(c.ID)
 	}
-	close(c.Send)
 	log.Printf("Racing client disconnected: %s", c.ID)
 }
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Detailed race results. Each racer's cycle count is logged as state updates arrive,
+// and when their result is recorded it is turned into splits, cycle rates, their
+// longest pause and a consistency score. Finished races are kept in a ResultsStore,
+// separate from RacingWorld.Races since races are reaped, and served as JSON from
+// /racing/results/{raceId}.
+
+// Result statistics tuning
+const (
+	SplitInterval     = 0.1 // Progress between splits
+	PeakRateWindow    = 5.0 // Seconds the peak cycle rate is measured over
+	ConsistencyWindow = 5.0 // Seconds per bucket when scoring consistency
+	MaxStoredResults  = 500 // Past races kept for the results endpoint
+)
+
+// CycleSample is a racer's total cycles T seconds into a race
+type CycleSample struct {
+	T      float64
+	Cycles int
+}
+
+// RaceRecord is a finished race's full results, as served by the results endpoint
+type RaceRecord struct {
+	RaceID    string       `json:"raceId"`
+	Format    RaceFormat   `json:"format"`
+	StartedAt time.Time    `json:"startedAt"`
+	EndedAt   time.Time    `json:"endedAt"`
+	Results   []RaceResult `json:"results"`
+}
+
+// ResultsStore keeps the most recent finished races. It has its own lock, so race
+// goroutines can save results without touching the racing world's lock.
+type ResultsStore struct {
+	records map[string]*RaceRecord
+	order   []string // Race IDs, oldest first
+	mu      sync.Mutex
+}
+
+// NewResultsStore creates an empty results store
+func NewResultsStore() *ResultsStore {
+	return &ResultsStore{records: make(map[string]*RaceRecord)}
+}
+
+// Save keeps a finished race's results, dropping the oldest beyond MaxStoredResults
+func (s *ResultsStore) Save(record *RaceRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.records[record.RaceID]; !exists {
+		s.order = append(s.order, record.RaceID)
+	}
+	s.records[record.RaceID] = record
+
+	for len(s.order) > MaxStoredResults {
+		delete(s.records, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// Get returns the results of the race with the given ID, or nil
+func (s *ResultsStore) Get(raceID string) *RaceRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records[raceID]
+}
+
+// logCycles adds the racer's current cycle count to their log if it has changed
+func (p *RacingPlayer) logCycles(elapsed float64) {
+	if len(p.CycleLog) >= MaxGhostSamples {
+		return
+	}
+	if n := len(p.CycleLog); n > 0 && p.CycleLog[n-1].Cycles == p.MouthCycles {
+		return
+	}
+	p.CycleLog = append(p.CycleLog, CycleSample{T: elapsed, Cycles: p.MouthCycles})
+}
+
+// recordSplits notes the time of every SplitInterval of progress the racer has passed
+func (p *RacingPlayer) recordSplits(elapsed float64) {
+	for len(p.Splits) < int(1/SplitInterval) && p.Progress+1e-9 >= float64(len(p.Splits)+1)*SplitInterval {
+		p.Splits = append(p.Splits, math.Round(elapsed*100)/100)
+	}
+}
+
+// addStats fills in a result's detailed statistics from the racer's cycle log, over
+// elapsed seconds of racing
+func (result *RaceResult) addStats(player *RacingPlayer, elapsed float64) {
+	result.Splits = append([]float64(nil), player.Splits...)
+	if elapsed <= 0 {
+		return
+	}
+
+	samples := append([]CycleSample{{T: 0, Cycles: 0}}, player.CycleLog...)
+	result.AverageRate = round1(float64(player.MouthCycles) / elapsed * 60)
+
+	// Peak: the best rate over any PeakRateWindow, or the average in a shorter race
+	result.PeakRate = result.AverageRate
+	start := 0
+	for _, sample := range samples {
+		for start+1 < len(samples) && sample.T-samples[start+1].T >= PeakRateWindow {
+			start++
+		}
+		if span := sample.T - samples[start].T; span >= PeakRateWindow {
+			rate := float64(sample.Cycles-samples[start].Cycles) / span * 60
+			result.PeakRate = math.Max(result.PeakRate, round1(rate))
+		}
+	}
+
+	// Longest pause: the longest stretch without a new cycle, up to the end
+	last := 0.0
+	for _, sample := range samples[1:] {
+		if sample.Cycles > 0 {
+			result.LongestPause = math.Max(result.LongestPause, sample.T-last)
+			last = sample.T
+		}
+	}
+	result.LongestPause = round1(math.Max(result.LongestPause, elapsed-last))
+
+	result.Consistency = consistency(samples, elapsed)
+}
+
+// consistency scores 0-100 how evenly cycles were spread over the race: 100 minus the
+// coefficient of variation, as a percentage, of cycles per ConsistencyWindow
+func consistency(samples []CycleSample, elapsed float64) float64 {
+	buckets := int(elapsed / ConsistencyWindow)
+	if buckets < 2 {
+		return 100
+	}
+
+	// Cycles done by time t, from the last sample at or before it
+	cyclesAt := func(t float64) int {
+		cycles := 0
+		for _, sample := range samples {
+			if sample.T > t {
+				break
+			}
+			cycles = sample.Cycles
+		}
+		return cycles
+	}
+
+	counts := make([]float64, buckets)
+	var mean float64
+	for i := range counts {
+		from, to := float64(i)*ConsistencyWindow, float64(i+1)*ConsistencyWindow
+		counts[i] = float64(cyclesAt(to) - cyclesAt(from))
+		mean += counts[i]
+	}
+	mean /= float64(buckets)
+	if mean <= 0 {
+		return 0
+	}
+
+	var variance float64
+	for _, count := range counts {
+		variance += (count - mean) * (count - mean)
+	}
+	cv := math.Sqrt(variance/float64(buckets)) / mean
+	return round1(math.Max(0, 100*(1-cv)))
+}
+
+// round1 rounds to one decimal place
+func round1(v float64) float64 {
+	return math.Round(v*10) / 10
+}
+
+// saveResults keeps the finished race's results for the results endpoint
+func (r *Race) saveResults() {
+	if r.World == nil {
+		return
+	}
+	r.World.Results.Save(&RaceRecord{
+		RaceID:    r.ID,
+		Format:    r.Format,
+		StartedAt: r.StartTime,
+		EndedAt:   r.EndTime,
+		Results:   append([]RaceResult(nil), r.FinishedPlayers...),
+	})
+}
+
+// HandleRaceResults serves a past race's full results as JSON from
+// /racing/results/{raceId}
+func HandleRaceResults(racingWorld *RacingWorld) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Let the racing client read results from another origin we accept
+		if origin := r.Header.Get("Origin"); origin != "" && allowedOrigins.Check(r) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Vary", "Origin")
+		}
+
+		raceID := strings.TrimPrefix(r.URL.Path, "/racing/results/")
+		record := racingWorld.Results.Get(raceID)
+		if record == nil {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, record)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestResultStats(t *testing.T) {
+	player := &RacingPlayer{
+		MouthCycles: 50,
+		CycleLog:    []CycleSample{{T: 5, Cycles: 10}, {T: 10, Cycles: 20}, {T: 20, Cycles: 50}},
+	}
+	var result RaceResult
+	result.addStats(player, 20)
+
+	if result.AverageRate != 150 {
+		t.Errorf("average rate = %v, want 150 cycles/min", result.AverageRate)
+	}
+	if result.PeakRate != 180 {
+		t.Errorf("peak rate = %v, want 180 cycles/min over the last 10s", result.PeakRate)
+	}
+	if result.LongestPause != 10 {
+		t.Errorf("longest pause = %v, want 10s", result.LongestPause)
+	}
+	if result.Consistency != 12.8 {
+		t.Errorf("consistency = %v, want 12.8 for 10/10/0/30 cycles per 5s", result.Consistency)
+	}
+
+	steady := &RacingPlayer{
+		MouthCycles: 40,
+		CycleLog:    []CycleSample{{T: 5, Cycles: 10}, {T: 10, Cycles: 20}, {T: 15, Cycles: 30}, {T: 20, Cycles: 40}},
+	}
+	result = RaceResult{}
+	result.addStats(steady, 20)
+	if result.Consistency != 100 || result.PeakRate != result.AverageRate {
+		t.Errorf("a steady racer should score 100 with peak = average: %+v", result)
+	}
+}
+
+func TestRaceResultsEndpoint(t *testing.T) {
+	world := NewRacingWorld()
+	race, clients := startedRace(world, 10*time.Second, "Nemo", "Dory")
+	race.HandleFishStateUpdate(clients[0].ID, FishState{MouthCycles: 5})
+	sendCycles(race, clients[0].ID, CyclesPerRace)
+	race.HandleFishStateUpdate(clients[1].ID, FishState{MouthCycles: 12})
+	race.EndRace()
+
+	// Results outlive the race
+	world.ReapRaces(time.Now().Add(RaceResultsKeepTime * time.Second))
+	if world.GetRace(race.ID) != nil {
+		t.Fatal("finished race should have been reaped")
+	}
+
+	handler := HandleRaceResults(world)
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/racing/results/"+race.ID, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+
+	var record RaceRecord
+	if err := json.Unmarshal(rec.Body.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.RaceID != race.ID || len(record.Results) != 2 {
+		t.Fatalf("record = %+v", record)
+	}
+	if winner := record.Results[0]; winner.Name != "Nemo" || len(winner.Splits) != 10 || winner.AverageRate <= 0 {
+		t.Errorf("winner should have every split and a rate: %+v", winner)
+	}
+	if dnf := record.Results[1]; len(dnf.Splits) != 2 {
+		t.Errorf("a DNF at 24%% should have two splits: %+v", dnf.Splits)
+	}
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/racing/results/nope", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown race: status %d", rec.Code)
+	}
+}
+
+func TestResultsStoreLimit(t *testing.T) {
+	store := NewResultsStore()
+	for i := 0; i <= MaxStoredResults; i++ {
+		store.Save(&RaceRecord{RaceID: NewRaceID()})
+	}
+	if len(store.records) != MaxStoredResults || len(store.order) != MaxStoredResults {
+		t.Errorf("store holds %d races, want %d", len(store.records), MaxStoredResults)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+)
+
+// Spectating: a /ws/racing client can list the public races that are counting down or
+// running and subscribe to one. Spectators get the same raceState, itemUsed and
+// raceResults messages as racers, but are kept apart from Race.Players, so they never
+// show up in the race or hold up its end.
+
+// MaxSpectatorsPerRace caps how many clients can watch one race
+const MaxSpectatorsPerRace = 50
+
+// Reasons a spectate request is refused, sent in spectateError
+var (
+	ErrRaceNotFound    = errors.New("no race with that ID")
+	ErrRaceNotRunning  = errors.New("race isn't running")
+	ErrTooManyWatching = errors.New("too many spectators")
+)
+
+// RaceListing describes a race a spectator can watch
+type RaceListing struct {
+	RaceID     string     `json:"raceId"`
+	RaceState  string     `json:"raceState"`
+	Format     RaceFormat `json:"format"`
+	Players    []string   `json:"players"` // Racer names
+	Elapsed    float64    `json:"elapsed"` // Seconds since the start; 0 during the countdown
+	Spectators int        `json:"spectators"`
+}
+
+// RaceListPayload answers listRaces
+type RaceListPayload struct {
+	Races []RaceListing `json:"races"`
+}
+
+// SpectatingPayload confirms a spectate; raceState messages follow
+type SpectatingPayload struct {
+	RaceID string `json:"raceId"`
+}
+
+// SpectatableRaces lists public races counting down or racing, longest running first.
+// Private lobbies' races aren't listed.
+func (rw *RacingWorld) SpectatableRaces() []RaceListing {
+	now := time.Now()
+	listings := make([]RaceListing, 0)
+	for _, race := range rw.ListRaces() {
+		snap := race.Snapshot()
+		if snap.Code != "" || (snap.State != RaceStateCountdown && snap.State != RaceStateRacing) {
+			continue
+		}
+
+		listing := RaceListing{
+			RaceID:     snap.ID,
+			RaceState:  snap.State.String(),
+			Format:     snap.Format,
+			Players:    make([]string, 0, len(snap.Players)),
+			Spectators: len(snap.Spectators),
+		}
+		for _, player := range snap.Players {
+			listing.Players = append(listing.Players, player.Name)
+		}
+		if snap.State == RaceStateRacing {
+			listing.Elapsed = round1(now.Sub(snap.StartTime).Seconds())
+		}
+		listings = append(listings, listing)
+	}
+
+	sort.Slice(listings, func(i, j int) bool {
+		return listings[i].Elapsed > listings[j].Elapsed
+	})
+	return listings
+}
+
+// AddSpectator subscribes client to the race's updates and sends it the current state
+func (r *Race) AddSpectator(client *RacingClient) error {
+	err := ErrRaceNotFound // If the race was reaped since it was found
+	r.call(func() {
+		switch {
+		case r.Code != "":
+			err = ErrRaceNotFound
+		case r.State != RaceStateCountdown && r.State != RaceStateRacing:
+			err = ErrRaceNotRunning
+		case len(r.Spectators) >= MaxSpectatorsPerRace:
+			err = ErrTooManyWatching
+		default:
+			err = nil
+			r.Spectators[client.ID] = client
+			log.Printf("Client %s is spectating race %s (%d watching)", client.ID, r.ID, len(r.Spectators))
+			client.SendMessage(RacingServerMessage{
+				Type:    "spectating",
+				Payload: SpectatingPayload{RaceID: r.ID},
+			})
+			r.BroadcastState()
+		}
+	})
+	return err
+}
+
+// RemoveSpectator unsubscribes a spectator
+func (r *Race) RemoveSpectator(clientID string) {
+	r.call(func() {
+		if _, ok := r.Spectators[clientID]; ok {
+			delete(r.Spectators, clientID)
+			log.Printf("Client %s stopped spectating race %s", clientID, r.ID)
+		}
+	})
+}
+
+// sendAll sends msg to every connected racer and spectator
+func (r *Race) sendAll(msg RacingServerMessage) {
+	for _, player := range r.Players {
+		if player.Client != nil {
+			player.Client.SendMessage(msg)
+		}
+	}
+	for _, spectator := range r.Spectators {
+		spectator.SendMessage(msg)
+	}
+}
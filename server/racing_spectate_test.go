@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSpectateRace(t *testing.T) {
+	world := NewRacingWorld()
+	race, clients := startedRace(world, time.Second, "Nemo", "Dory")
+
+	// Private lobbies and lobbies that haven't started aren't listed
+	host := newTestRacer(world)
+	lobby, _ := world.CreateLobby(host, "Host", "shark", 0, DefaultRaceFormat())
+	lobby.HostStart(host.ID)
+
+	listings := world.SpectatableRaces()
+	if len(listings) != 1 || listings[0].RaceID != race.ID || len(listings[0].Players) != 2 {
+		t.Fatalf("only the public running race should be listed: %+v", listings)
+	}
+
+	spectator := newTestRacer(world)
+	spectator.Spectate(race.ID)
+	if lastMessage(spectator, "spectating") == nil {
+		t.Fatal("spectator should be told they're watching")
+	}
+
+	race.HandleFishStateUpdate(clients[0].ID, FishState{MouthCycles: 10})
+	race.call(race.BroadcastState)
+
+	var state RaceStatePayload
+	json.Unmarshal(lastMessage(spectator, "raceState"), &state)
+	if !state.Spectator || len(state.Players) != 2 || state.YourProgress.ID != "" {
+		t.Errorf("spectator's raceState should show the racers only: %+v", state)
+	}
+	var racerState RaceStatePayload
+	json.Unmarshal(lastMessage(clients[0], "raceState"), &racerState)
+	if racerState.Spectator || racerState.Spectators != 1 || racerState.TotalPlayers != 2 {
+		t.Errorf("racers should see a spectator count, not a new player: %+v", racerState)
+	}
+
+	// The race ends without waiting on the spectator
+	sendCycles(race, clients[0].ID, CyclesPerRace)
+	sendCycles(race, clients[1].ID, CyclesPerRace)
+	waitForState(t, race, RaceStateFinished)
+	if results := race.Snapshot().Results; len(results) != 2 {
+		t.Errorf("spectator must not appear in the results: %+v", results)
+	}
+	if lastMessage(spectator, "raceResults") == nil {
+		t.Error("spectator should get the results")
+	}
+
+	// Finished races can't be watched, and stopping unsubscribes
+	late := newTestRacer(world)
+	late.Spectate(race.ID)
+	var refused LobbyErrorPayload
+	json.Unmarshal(lastMessage(late, "spectateError"), &refused)
+	if refused.Reason != ErrRaceNotRunning.Error() {
+		t.Errorf("spectating a finished race: got %+v", refused)
+	}
+
+	spectator.stopSpectating()
+	if n := len(race.Snapshot().Spectators); n != 0 {
+		t.Errorf("%d spectators left after stopping", n)
+	}
+}
+
+func TestSpectatorLeavesRaceAndQueue(t *testing.T) {
+	world := NewRacingWorld()
+	running, _ := startedRace(world, time.Second, "Nemo")
+
+	client := newTestRacer(world)
+	lobby, welcome := world.JoinRace(client, "Dory", "shark")
+	client.enterRace(lobby, welcome)
+
+	client.Spectate(running.ID)
+	if client.CurrentRace() != nil || len(lobby.Snapshot().Players) != 0 {
+		t.Error("spectating should leave the lobby")
+	}
+
+	client.Spectate("nope")
+	var refused LobbyErrorPayload
+	json.Unmarshal(lastMessage(client, "spectateError"), &refused)
+	if refused.Reason != ErrRaceNotFound.Error() {
+		t.Errorf("spectating an unknown race: got %+v", refused)
+	}
+
+	client.Disconnect()
+	if n := len(running.Snapshot().Spectators); n != 0 {
+		t.Errorf("disconnecting should stop spectating, %d left", n)
+	}
+}
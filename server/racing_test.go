@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startedRace returns a default-format race in racing state that began ago, with the
+// given racers
+func startedRace(world *RacingWorld, ago time.Duration, names ...string) (*Race, []*RacingClient) {
+	return formatRace(world, DefaultRaceFormat(), ago, names...)
+}
+
+// formatRace returns a race in the given format that started ago, with the given racers
+func formatRace(world *RacingWorld, format RaceFormat, ago time.Duration, names ...string) (*Race, []*RacingClient) {
+	world.mu.Lock()
+	race := world.createRace("", RaceMaxPlayers, "", format)
+	world.mu.Unlock()
+
+	clients := make([]*RacingClient, len(names))
+	race.call(func() {
+		for i, name := range names {
+			clients[i] = newTestRacer(world)
+			race.addPlayer(clients[i], name, "shark")
+		}
+		race.startRace(time.Now().Add(-ago))
+	})
+	return race, clients
+}
+
+// sendCycles reports a racer's count climbing to cycles, in updates no bigger than one
+// can credit, as a client catching up would
+func sendCycles(race *Race, id string, cycles int) {
+	var from int
+	race.call(func() {
+		if player := race.Players[id]; player != nil {
+			from = player.MouthCycles
+		}
+	})
+	for from < cycles {
+		from = min(from+MaxCyclesPerUpdate, cycles)
+		race.HandleFishStateUpdate(id, FishState{MouthCycles: from})
+	}
+}
+
+func TestDisconnectMidRaceIsDNF(t *testing.T) {
+	world := NewRacingWorld()
+	race, clients := startedRace(world, 10*time.Second, "Winner", "Leaver", "Slow")
+
+	race.HandleFishStateUpdate(clients[1].ID, FishState{MouthCycles: 20})
+	race.HandleFishStateUpdate(clients[2].ID, FishState{MouthCycles: 5})
+	race.DisconnectPlayer(clients[1].ID)
+	sendCycles(race, clients[0].ID, CyclesPerRace)
+
+	// Updates from a DNF racer no longer count
+	sendCycles(race, clients[1].ID, CyclesPerRace)
+
+	race.EndRace()
+
+	var results RaceResultsPayload
+	json.Unmarshal(lastMessage(clients[0], "raceResults"), &results)
+	if len(results.Results) != 3 {
+		t.Fatalf("got %d results, want every racer including the leaver: %+v", len(results.Results), results.Results)
+	}
+
+	want := []struct {
+		name string
+		dnf  bool
+	}{{"Winner", false}, {"Leaver", true}, {"Slow", true}}
+	for i, w := range want {
+		got := results.Results[i]
+		if got.Name != w.name || got.DNF != w.dnf || got.Rank != i+1 {
+			t.Errorf("result %d = %+v, want %s (dnf %v)", i, got, w.name, w.dnf)
+		}
+	}
+	if results.Results[1].Progress != 20*CycleProgress {
+		t.Errorf("DNF progress = %v, want where they stopped", results.Results[1].Progress)
+	}
+
+	// Ending again is a no-op
+	race.EndRace()
+	if lastMessage(clients[0], "raceResults") != nil {
+		t.Error("results should only be sent once")
+	}
+}
+
+// waitForState polls the race's snapshot until it reaches state
+func waitForState(t *testing.T, race *Race, state RaceState) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for raceState(race) != state {
+		if time.Now().After(deadline) {
+			t.Fatalf("race stuck in %s, want %s", raceState(race), state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRaceEndsAfterMaxDuration(t *testing.T) {
+	world := NewRacingWorld()
+	race, clients := startedRace(world, RaceMaxDuration*time.Second, "Fast", "Idle")
+	race.HandleFishStateUpdate(clients[0].ID, FishState{MouthCycles: 10})
+
+	// The race goroutine's own ticker ends it
+	waitForState(t, race, RaceStateFinished)
+	for _, result := range race.Snapshot().Results {
+		if !result.DNF {
+			t.Errorf("%s should be DNF when time runs out", result.Name)
+		}
+	}
+}
+
+func TestRaceDNFsStalledRacers(t *testing.T) {
+	world := NewRacingWorld()
+	race, clients := startedRace(world, (RaceStallTimeout+1)*time.Second, "Stalled")
+	race.call(func() {
+		race.Players[clients[0].ID].LastUpdate = time.Now().Add(-(RaceStallTimeout + 1) * time.Second)
+	})
+
+	waitForState(t, race, RaceStateFinished)
+	if results := race.Snapshot().Results; len(results) != 1 || !results[0].DNF {
+		t.Errorf("stalled racer should be DNF: %+v", results)
+	}
+}
+
+func TestReapRaces(t *testing.T) {
+	world := NewRacingWorld()
+	finished, _ := startedRace(world, time.Minute, "A")
+	finished.EndRace()
+
+	world.mu.Lock()
+	abandoned := world.CreateRace()
+	world.mu.Unlock()
+
+	waiting := world.WaitingLobby
+
+	if n := world.ReapRaces(time.Now()); n != 1 || world.GetRace(abandoned.ID) != nil {
+		t.Fatalf("only the abandoned lobby should be reaped right away, reaped %d", n)
+	}
+	if world.GetRace(finished.ID) == nil {
+		t.Fatal("finished races are kept for RaceResultsKeepTime")
+	}
+
+	world.ReapRaces(time.Now().Add(RaceResultsKeepTime * time.Second))
+	if world.GetRace(finished.ID) != nil {
+		t.Error("finished race should be reaped after RaceResultsKeepTime")
+	}
+	if world.GetRace(waiting.ID) == nil {
+		t.Error("the waiting lobby must never be reaped")
+	}
+
+	// Reaped races stop their goroutines
+	select {
+	case <-finished.done:
+	case <-time.After(time.Second):
+		t.Error("a reaped race's goroutine should exit")
+	}
+	if finished.call(func() {}) {
+		t.Error("commands to a stopped race should be refused")
+	}
+}
+
+// TestConcurrentRacers runs many racers joining, readying, racing and leaving at
+// once, alongside the admin and shutdown paths that read every race. Run it with
+// go test -race.
+func TestConcurrentRacers(t *testing.T) {
+	world := NewRacingWorld()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	world.Start(ctx)
+
+	// Drain messages like a write pump would
+	drain := func(client *RacingClient) {
+		go func() {
+			for range client.Send {
+			}
+		}()
+	}
+
+	// Lobby churn: joining, hosting, readying up and leaving all at once
+	const lobbyClients = 32
+	var wg sync.WaitGroup
+	for i := 0; i < lobbyClients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			client := newTestRacer(world)
+			drain(client)
+
+			var race *Race
+			switch i % 3 {
+			case 0:
+				race, _ = world.JoinRace(client, "Racer", "shark")
+			case 1:
+				race, _ = world.CreateLobby(client, "Host", "shark", 4, DefaultRaceFormat())
+				race.HostStart(client.ID)
+			case 2:
+				race, _ = world.JoinRace(client, "Quitter", "shark")
+			}
+			client.setRace(race)
+			race.HandlePlayerReady(client.ID)
+			client.Disconnect()
+		}(i)
+	}
+
+	// Running races: every racer sends updates from their own goroutine while others
+	// drop out and an admin force-ends one race, so progress, finishing, endRace,
+	// ratings and results all run alongside each other
+	const races, perRace = 8, 4
+	running := make([]*Race, races)
+	for r := range running {
+		race, clients := startedRace(world, time.Second, "A", "B", "C", "D")
+		running[r] = race
+		for i, client := range clients {
+			client.setRace(race)
+			drain(client)
+
+			wg.Add(1)
+			go func(r, i int, client *RacingClient) {
+				defer wg.Done()
+				for cycles := 5; cycles <= CyclesPerRace; cycles += 5 {
+					if i == perRace-1 && cycles > CyclesPerRace/2 {
+						client.Disconnect() // DNF mid-race
+						return
+					}
+					race.HandleFishStateUpdate(client.ID, FishState{MouthCycles: cycles})
+					time.Sleep(time.Millisecond)
+				}
+				if r == 0 && i == 0 {
+					race.ForceEnd()
+				}
+			}(r, i, client)
+		}
+	}
+
+	// Readers on other goroutines, as the admin API and shutdown use them
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			world.RunningRaces()
+			world.FindClient("nobody")
+			world.BroadcastAnnouncement("hello")
+			world.SpectatableRaces()
+			for _, race := range world.ListRaces() {
+				race.StateString()
+				world.Results.Get(race.ID)
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(stop)
+	readers.Wait()
+
+	// Every running race ends, with its results ranked and saved
+	for _, race := range running {
+		waitForState(t, race, RaceStateFinished)
+		if results := race.Snapshot().Results; len(results) != perRace {
+			t.Errorf("race %s has %d results, want %d", race.ID, len(results), perRace)
+		}
+		if world.Results.Get(race.ID) == nil {
+			t.Errorf("race %s results weren't saved", race.ID)
+		}
+	}
+
+	// Every lobby racer has left, so no race may be left running forever
+	deadline := time.Now().Add(RaceCountdownTime*time.Second + 2*time.Second)
+	for world.RunningRaces() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d races still running after every racer left", world.RunningRaces())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
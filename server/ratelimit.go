@@ -0,0 +1,163 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TokenBucket allows Rate events per second on average with bursts of up to Burst.
+// Not safe for concurrent use; each read pump owns its buckets.
+type TokenBucket struct {
+	Rate   float64
+	Burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a full bucket
+func NewTokenBucket(rate, burst float64) *TokenBucket {
+	return &TokenBucket{Rate: rate, Burst: burst, tokens: burst}
+}
+
+// Allow takes a token if one is available at time now
+func (b *TokenBucket) Allow(now time.Time) bool {
+	if !b.last.IsZero() {
+		b.tokens = Min(b.Burst, b.tokens+now.Sub(b.last).Seconds()*b.Rate)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// RateLimit is the allowed rate and burst for one message type
+type RateLimit struct {
+	Rate  float64 // Messages per second
+	Burst float64
+}
+
+// Per-message-type limits. The clients send input ~15 times a second and
+// racing state updates on every mouth movement.
+var (
+	GameRateLimits = map[string]RateLimit{
+		"input":    {Rate: 30, Burst: 60},
+		"ping":     {Rate: 2, Burst: 5},
+		"join":     {Rate: 0.5, Burst: 3},
+		"hello":    {Rate: 0.5, Burst: 2},
+		"spectate": {Rate: 5, Burst: 10},
+	}
+	RacingRateLimits = map[string]RateLimit{
+		"stateUpdate": {Rate: 30, Burst: 60},
+		"ping":        {Rate: 2, Burst: 5},
+		"join":        {Rate: 0.5, Burst: 3},
+		"ready":       {Rate: 2, Burst: 5},
+		"createLobby": {Rate: 0.2, Burst: 2},
+		"joinLobby":   {Rate: 0.5, Burst: 3},
+		"kick":        {Rate: 1, Burst: 5},
+		"startRace":   {Rate: 0.5, Burst: 2},
+		"setFormat":   {Rate: 1, Burst: 5},
+		"queue":       {Rate: 0.5, Burst: 3},
+		"mouthInput":  {Rate: 20, Burst: 40},
+		"useItem":     {Rate: 2, Burst: 5},
+		"listRaces":   {Rate: 1, Burst: 5},
+		"spectate":    {Rate: 1, Burst: 5},
+	}
+	// DefaultRateLimit applies to message types without their own limit
+	DefaultRateLimit = RateLimit{Rate: 2, Burst: 5}
+	// ViolationLimit is how many dropped messages a connection may rack up (refilling
+	// at Rate per second) before it is disconnected
+	ViolationLimit = RateLimit{Rate: 5, Burst: 50}
+)
+
+// MessageLimiter applies per-message-type token buckets to one connection
+type MessageLimiter struct {
+	limits     map[string]RateLimit
+	buckets    map[string]*TokenBucket
+	violations *TokenBucket
+}
+
+// NewMessageLimiter creates a limiter with the given per-type limits
+func NewMessageLimiter(limits map[string]RateLimit) *MessageLimiter {
+	return &MessageLimiter{
+		limits:     limits,
+		buckets:    make(map[string]*TokenBucket),
+		violations: NewTokenBucket(ViolationLimit.Rate, ViolationLimit.Burst),
+	}
+}
+
+// Allow reports whether a message of msgType may be handled now. When it may not,
+// abusive is true once the connection has exceeded ViolationLimit and should be closed.
+func (l *MessageLimiter) Allow(msgType string, now time.Time) (allowed, abusive bool) {
+	bucket, ok := l.buckets[msgType]
+	if !ok {
+		limit, known := l.limits[msgType]
+		if !known {
+			// Unknown types share one bucket so random type names can't grow the map
+			msgType = ""
+			limit = DefaultRateLimit
+		}
+		if bucket, ok = l.buckets[msgType]; !ok {
+			bucket = NewTokenBucket(limit.Rate, limit.Burst)
+			l.buckets[msgType] = bucket
+		}
+	}
+
+	if bucket.Allow(now) {
+		return true, false
+	}
+	return false, !l.violations.Allow(now)
+}
+
+// ConnLimiter caps concurrent connections per IP address
+type ConnLimiter struct {
+	Max    int
+	counts map[string]int
+	mu     sync.Mutex
+}
+
+// NewConnLimiter creates a limiter allowing max connections per IP
+func NewConnLimiter(max int) *ConnLimiter {
+	return &ConnLimiter{Max: max, counts: make(map[string]int)}
+}
+
+// Acquire reserves a connection slot for ip, returning false if it has none left
+func (l *ConnLimiter) Acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.counts[ip] >= l.Max {
+		return false
+	}
+	l.counts[ip]++
+	return true
+}
+
+// Release frees a slot reserved by Acquire
+func (l *ConnLimiter) Release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.counts[ip] <= 1 {
+		delete(l.counts, ip)
+		return
+	}
+	l.counts[ip]--
+}
+
+// Per-IP connection caps for the game and racing endpoints
+var (
+	gameConnLimit   = NewConnLimiter(MaxConnectionsPerIP)
+	racingConnLimit = NewConnLimiter(MaxConnectionsPerIP)
+)
+
+// closeRateLimited tells a client it was disconnected for flooding. The caller's
+// read pump then exits and tears the connection down as usual.
+func closeRateLimited(conn *websocket.Conn) {
+	msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "rate limit exceeded")
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := NewTokenBucket(10, 3)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if !b.Allow(now) {
+			t.Fatalf("burst message %d should be allowed", i)
+		}
+	}
+	if b.Allow(now) {
+		t.Fatal("message beyond the burst should be refused")
+	}
+
+	// 10/s refills one token every 100ms, and never beyond the burst
+	if !b.Allow(now.Add(100 * time.Millisecond)) {
+		t.Fatal("a token should have refilled after 100ms")
+	}
+	later := now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !b.Allow(later) {
+			t.Fatalf("refilled burst message %d should be allowed", i)
+		}
+	}
+	if b.Allow(later) {
+		t.Fatal("refill must be capped at the burst")
+	}
+}
+
+func TestMessageLimiterDisconnectsFlooders(t *testing.T) {
+	l := NewMessageLimiter(GameRateLimits)
+	now := time.Now()
+
+	limit := GameRateLimits["input"]
+	for i := 0; i < int(limit.Burst); i++ {
+		if allowed, _ := l.Allow("input", now); !allowed {
+			t.Fatalf("input %d within the burst was refused", i)
+		}
+	}
+
+	// Other types have their own buckets
+	if allowed, _ := l.Allow("ping", now); !allowed {
+		t.Fatal("ping should not share the input bucket")
+	}
+
+	for i := 0; i < int(ViolationLimit.Burst); i++ {
+		allowed, abusive := l.Allow("input", now)
+		if allowed || abusive {
+			t.Fatalf("violation %d: allowed=%v abusive=%v, want dropped but not abusive", i, allowed, abusive)
+		}
+	}
+	if _, abusive := l.Allow("input", now); !abusive {
+		t.Fatal("exceeding the violation limit should mark the connection abusive")
+	}
+}
+
+func TestMessageLimiterSharesUnknownTypes(t *testing.T) {
+	l := NewMessageLimiter(GameRateLimits)
+	now := time.Now()
+
+	for i := 0; i < 1000; i++ {
+		l.Allow(NewClientID(), now)
+	}
+	if len(l.buckets) != 1 {
+		t.Fatalf("unknown message types created %d buckets, want 1", len(l.buckets))
+	}
+}
+
+func TestConnLimiter(t *testing.T) {
+	l := NewConnLimiter(2)
+
+	if !l.Acquire("1.2.3.4") || !l.Acquire("1.2.3.4") {
+		t.Fatal("connections up to the cap should be allowed")
+	}
+	if l.Acquire("1.2.3.4") {
+		t.Fatal("connection over the cap should be refused")
+	}
+	if !l.Acquire("5.6.7.8") {
+		t.Fatal("the cap is per IP")
+	}
+
+	l.Release("1.2.3.4")
+	if !l.Acquire("1.2.3.4") {
+		t.Fatal("a released slot should be available again")
+	}
+
+	l.Release("5.6.7.8")
+	if _, ok := l.counts["5.6.7.8"]; ok {
+		t.Error("IPs with no connections should be forgotten")
+	}
+}
+
+func TestConnLimitIgnoresSpoofedIPs(t *testing.T) {
+	server := httptest.NewServer(HandleRacingWebSocket(NewRacingWorld()))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	// Without a trusted proxy, naming a fresh IP per connection gets nowhere, and
+	// naming someone else's IP doesn't use up their slots
+	var conns []*websocket.Conn
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for i := 0; i <= MaxConnectionsPerIP; i++ {
+		header := http.Header{"Fly-Client-IP": {fmt.Sprintf("198.51.100.%d", i)}}
+		conn, resp, err := websocket.DefaultDialer.Dial(wsURL, header)
+		if i < MaxConnectionsPerIP {
+			if err != nil {
+				t.Fatalf("connection %d: %v", i, err)
+			}
+			conns = append(conns, conn)
+			continue
+		}
+		if err == nil || resp.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("connection over the cap with a spoofed header should get 429, got %v", err)
+		}
+	}
+
+	if !racingConnLimit.Acquire("198.51.100.0") {
+		t.Error("the spoofed IP shouldn't have been charged")
+	}
+	racingConnLimit.Release("198.51.100.0")
+}
+
+func TestConnLimitPerClientBehindProxy(t *testing.T) {
+	defer func(saved *ProxyPolicy) { trustedProxies = saved }(trustedProxies)
+	trustedProxies, _ = NewProxyPolicy([]string{"127.0.0.0/8", "::1"})
+
+	server := httptest.NewServer(HandleRacingWebSocket(NewRacingWorld()))
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	var conns []*websocket.Conn
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	dial := func(clientIP string) (*websocket.Conn, *http.Response, error) {
+		conn, resp, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"Fly-Client-IP": {clientIP}})
+		if err == nil {
+			conns = append(conns, conn)
+		}
+		return conn, resp, err
+	}
+
+	// Every connection comes from the proxy, but each player gets their own slots
+	for i := 0; i <= MaxConnectionsPerIP; i++ {
+		if _, _, err := dial(fmt.Sprintf("198.51.100.%d", i)); err != nil {
+			t.Fatalf("player %d behind the proxy was refused: %v", i, err)
+		}
+	}
+
+	// One player still can't go over the cap
+	for i := 0; i < MaxConnectionsPerIP; i++ {
+		if _, _, err := dial("203.0.113.7"); err != nil {
+			t.Fatalf("connection %d: %v", i, err)
+		}
+	}
+	if _, resp, err := dial("203.0.113.7"); err == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("one player over the cap should get 429, got %v", err)
+	}
+}
+
+func TestInputLatestWins(t *testing.T) {
+	world := NewWorld()
+	flooder := NewPlayer(NewClientID(), "Flood", "shark", nil)
+	victim := NewPlayer(NewClientID(), "Victim", "shark", nil)
+	world.AddPlayer(flooder)
+	world.AddPlayer(victim)
+
+	for seq := uint32(1); seq <= 100000; seq++ {
+		flooder.SetInput(PlayerInput{PlayerID: flooder.ID, Direction: Vec2{X: 1}, Seq: seq})
+	}
+	victim.SetInput(PlayerInput{PlayerID: victim.ID, Direction: Vec2{Y: 1}, Seq: 7})
+
+	world.ProcessInputs()
+
+	if flooder.LastSeq != 100000 {
+		t.Errorf("flooder LastSeq = %d, want the latest input 100000", flooder.LastSeq)
+	}
+	if victim.LastSeq != 7 || victim.InputDirection != (Vec2{Y: 1}) {
+		t.Errorf("victim input was not applied: seq %d direction %v", victim.LastSeq, victim.InputDirection)
+	}
+	if flooder.PendingInput != nil || victim.PendingInput != nil {
+		t.Error("processed inputs should be cleared")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ShutdownPayload warns clients that the server is going away
+type ShutdownPayload struct {
+	SecondsRemaining int    `json:"secondsRemaining"`
+	Reason           string `json:"reason"`
+}
+
+// shutdownReason is shown to players and sent as the WebSocket close reason
+const shutdownReason = "server restarting"
+
+// GracefulShutdown drains the server after SIGTERM:
+//  1. stop accepting connections and race joins
+//  2. count down with serverShutdown messages while running races finish,
+//     for at least ShutdownNoticeTime and at most ShutdownRaceDeadline
+//  3. force-end races that are still running
+//  4. stop the game loops (stopLoops cancels their context)
+//  5. close every socket with CloseGoingAway and flush the event bus
+func GracefulShutdown(server *http.Server, world *World, racingWorld *RacingWorld, stopLoops context.CancelFunc) {
+	start := time.Now()
+	noticeEnd := start.Add(time.Duration(ShutdownNoticeTime) * time.Second)
+	deadline := start.Add(time.Duration(ShutdownRaceDeadline) * time.Second)
+
+	// Stop accepting. Upgraded WebSockets are hijacked, so this doesn't wait for them.
+	listenerCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := server.Shutdown(listenerCtx); err != nil {
+		log.Printf("Error closing listeners: %v", err)
+	}
+	cancel()
+	racingWorld.Drain()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		running := racingWorld.RunningRaces()
+		if running == 0 && !now.Before(noticeEnd) {
+			break
+		}
+		if !now.Before(deadline) {
+			log.Printf("Shutdown deadline reached, force-ending %d races", running)
+			racingWorld.ForceEndRunning()
+			break
+		}
+
+		// Races may end early, so the countdown is an upper bound while any are running
+		end := noticeEnd
+		if running > 0 {
+			end = deadline
+		}
+		seconds := int(end.Sub(now).Round(time.Second).Seconds())
+		log.Printf("Shutting down in %ds (%d races running)", seconds, running)
+
+		payload := ShutdownPayload{SecondsRemaining: seconds, Reason: shutdownReason}
+		world.BroadcastShutdown(payload)
+		racingWorld.BroadcastShutdown(payload)
+
+		<-ticker.C
+	}
+
+	stopLoops()
+
+	closed := world.CloseAll(websocket.CloseGoingAway, shutdownReason)
+	closed += racingWorld.CloseAll(websocket.CloseGoingAway, shutdownReason)
+	log.Printf("Closed %d connections", closed)
+
+	if !world.Events.Close(5 * time.Second) {
+		log.Printf("Timed out flushing game events")
+	}
+
+	log.Printf("Shutdown complete after %s", time.Since(start).Round(time.Millisecond))
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"math"
 	"sort"
@@ -11,12 +13,15 @@ import (
 // World represents the game world state
 type World struct {
 	Players      map[string]*Player
+	Spectators   map[string]*Client // Clients watching without a fish
+	Clients      map[string]*Client // Every open connection, joined or not
 	Food         map[uint64]*Food
 	Powerups     map[uint64]*Powerup
-	InputQueue   chan PlayerInput
 	Quadtree     *Quadtree
 	NextFoodID   uint64
 	NextPowerupID uint64
+	Events       *EventBus
+	LeaderID     string // Current top of the leaderboard, for NewLeader events
 	mu           sync.RWMutex
 }
 
@@ -24,16 +29,18 @@ type World struct {
 func NewWorld() *World {
 	return &World{
 		Players:       make(map[string]*Player),
+		Spectators:    make(map[string]*Client),
+		Clients:       make(map[string]*Client),
 		Food:          make(map[uint64]*Food),
 		Powerups:      make(map[uint64]*Powerup),
-		InputQueue:    make(chan PlayerInput, InputQueueSize),
 		NextFoodID:    1,
 		NextPowerupID: 1,
+		Events:        NewEventBus(),
 	}
 }
 
-// Start begins the game loop
-func (w *World) Start() {
+// Start begins the game loop. The loops stop when ctx is cancelled.
+func (w *World) Start(ctx context.Context) {
 	// Spawn initial food
 	for i := 0; i < MaxFoodCount; i++ {
 		w.SpawnFood()
@@ -44,23 +51,32 @@ func (w *World) Start() {
 		w.SpawnPowerup()
 	}
 
+	// Kill feed: forward gameplay events to clients
+	w.Events.Subscribe(w.BroadcastEvent)
+
 	// Start game loop
-	go w.GameLoop()
-	go w.BroadcastLoop()
+	go w.Events.Run()
+	go w.GameLoop(ctx)
+	go w.BroadcastLoop(ctx)
 }
 
-// GameLoop runs the main game tick at 60Hz
-func (w *World) GameLoop() {
+// GameLoop runs the main game tick at 60Hz until ctx is cancelled
+func (w *World) GameLoop(ctx context.Context) {
 	ticker := time.NewTicker(time.Duration(TickInterval) * time.Millisecond)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		w.Update(float64(TickInterval) / 1000.0)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.Update(float64(TickInterval) / 1000.0)
+		}
 	}
 }
 
-// BroadcastLoop sends state updates to clients at 15Hz
-func (w *World) BroadcastLoop() {
+// BroadcastLoop sends state updates to clients at 15Hz until ctx is cancelled
+func (w *World) BroadcastLoop(ctx context.Context) {
 	stateTicker := time.NewTicker(time.Second / BroadcastRate)
 	leaderboardTicker := time.NewTicker(time.Second) // Leaderboard at 1Hz
 	sharkVisionTicker := time.NewTicker(time.Second / 2) // Shark vision at 0.5Hz
@@ -70,6 +86,8 @@ func (w *World) BroadcastLoop() {
 
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-stateTicker.C:
 			w.BroadcastState() // Send state without leaderboard
 		case <-leaderboardTicker.C:
@@ -106,23 +124,24 @@ func (w *World) Update(dt float64) {
 	// 7. Spawn food and powerups
 	w.SpawnFoodIfNeeded()
 	w.SpawnPowerupIfNeeded()
+
+	// 8. Announce leader changes
+	w.UpdateLeader()
 }
 
-// ProcessInputs drains the input queue and updates player input state
+// ProcessInputs applies each player's latest pending input
 func (w *World) ProcessInputs() {
-	for {
-		select {
-		case input := <-w.InputQueue:
-			if player, exists := w.Players[input.PlayerID]; exists && player.Alive {
-				// Store the input direction and boost state
-				// This persists until the next input update
-				player.InputDirection = input.Direction
-				player.InputBoost = input.Boost
-				player.LastSeq = input.Seq
-			}
-		default:
-			return
+	for _, player := range w.Players {
+		input := player.TakeInput()
+		if input == nil || !player.Alive {
+			continue
 		}
+
+		// Store the input direction and boost state
+		// This persists until the next input update
+		player.InputDirection = input.Direction
+		player.InputBoost = input.Boost
+		player.LastSeq = input.Seq
 	}
 }
 
@@ -289,8 +308,10 @@ func (w *World) DetectCollisions() {
 		if collides {
 			// Skip bouncing if either fish can eat the other
 			// This allows eating at similar sizes without bounce interference
-			canP1EatP2 := p1.Size >= p2.Size*SizeMultiplier
-			canP2EatP1 := p2.Size >= p1.Size*SizeMultiplier
+			// Spawn-protected fish can't eat or be eaten, so they always bounce
+			protected := p1.IsSpawnProtected() || p2.IsSpawnProtected()
+			canP1EatP2 := !protected && p1.Size >= p2.Size*SizeMultiplier
+			canP2EatP1 := !protected && p2.Size >= p1.Size*SizeMultiplier
 			
 			if canP1EatP2 || canP2EatP1 {
 				// Skip bounce - let eating happen instead
@@ -318,6 +339,11 @@ func (w *World) EatPlayer(eater, eaten *Player) {
 		return
 	}
 
+	// Spawn protection - fresh spawns can neither eat nor be eaten
+	if eater.IsSpawnProtected() || eaten.IsSpawnProtected() {
+		return
+	}
+
 	// Transfer size
 	eater.Size += eaten.Size * 0.5
 	if eater.Size > MaxPlayerSize {
@@ -333,6 +359,15 @@ func (w *World) EatPlayer(eater, eaten *Player) {
 	eaten.RespawnTime = RespawnDelay
 
 	log.Printf("Player %s ate player %s", eater.Name, eaten.Name)
+
+	w.Events.Publish(GameEvent{
+		Type:       EventPlayerAte,
+		PlayerID:   eater.ID,
+		PlayerName: eater.Name,
+		TargetID:   eaten.ID,
+		TargetName: eaten.Name,
+		Score:      eater.Score,
+	})
 }
 
 // EatFood handles a player eating food
@@ -387,6 +422,13 @@ func (w *World) CollectPowerup(player *Player, powerup *Powerup) {
 
 	// Remove powerup
 	delete(w.Powerups, powerup.ID)
+
+	w.Events.Publish(GameEvent{
+		Type:       EventPowerupCollected,
+		PlayerID:   player.ID,
+		PlayerName: player.Name,
+		Score:      player.Score,
+	})
 }
 
 // UpdatePowerups updates powerup timers and deactivates expired powerups
@@ -406,24 +448,93 @@ func (w *World) UpdatePowerups(dt float64) {
 				}
 
 				log.Printf("Player %s powerup expired", player.Name)
+
+				w.Events.Publish(GameEvent{
+					Type:       EventPowerupExpired,
+					PlayerID:   player.ID,
+					PlayerName: player.Name,
+					Score:      player.Score,
+				})
 			}
 		}
 	}
 }
 
-// HandleRespawns updates respawn timers and respawns dead players
+// HandleRespawns updates respawn timers, respawns dead players and counts down spawn protection
 func (w *World) HandleRespawns(dt float64) {
 	for _, player := range w.Players {
 		if !player.Alive {
 			player.RespawnTime -= dt
 			if player.RespawnTime <= 0 {
-				player.Respawn()
+				player.Respawn(w.FindSpawnPosition(InitialPlayerSize))
 				log.Printf("Player %s respawned", player.Name)
 			}
+		} else if player.SpawnProtection > 0 {
+			player.SpawnProtection -= dt
+			if player.SpawnProtection < 0 {
+				player.SpawnProtection = 0
+			}
 		}
 	}
 }
 
+// FindSpawnPosition samples candidate spawn points and returns the one furthest
+// from any fish larger than the given size
+func (w *World) FindSpawnPosition(size float64) Vec2 {
+	candidates := make([]Vec2, SpawnCandidates)
+	for i := range candidates {
+		candidates[i] = RandomSpawnPoint()
+	}
+	return w.SafestPoint(candidates, size)
+}
+
+// SafestPoint returns the candidate furthest from any fish larger than the given size,
+// or the first one at least SpawnSafeDistance away
+func (w *World) SafestPoint(candidates []Vec2, size float64) Vec2 {
+	best := RandomSpawnPoint()
+	bestDistance := -1.0
+
+	for _, candidate := range candidates {
+		distance := w.NearestThreatDistance(candidate, size)
+
+		// Nothing dangerous in range - no need to keep looking
+		if distance >= SpawnSafeDistance {
+			return candidate
+		}
+
+		if distance > bestDistance {
+			best = candidate
+			bestDistance = distance
+		}
+	}
+
+	return best
+}
+
+// NearestThreatDistance returns the distance from pos to the closest living fish
+// larger than the given size, capped at SpawnSafeDistance
+func (w *World) NearestThreatDistance(pos Vec2, size float64) float64 {
+	nearest := SpawnSafeDistance
+
+	// The quadtree only exists after the first tick
+	if w.Quadtree == nil {
+		for _, other := range w.Players {
+			if other.Alive && other.Size > size {
+				nearest = math.Min(nearest, Distance(pos, other.Position))
+			}
+		}
+		return nearest
+	}
+
+	for _, entity := range w.Quadtree.QueryCircle(pos, SpawnSafeDistance, nil) {
+		if e, ok := entity.(*PlayerEntity); ok && e.Alive && e.Size > size {
+			nearest = math.Min(nearest, Distance(pos, e.Position))
+		}
+	}
+
+	return nearest
+}
+
 // SpawnFoodIfNeeded spawns food if below target count
 func (w *World) SpawnFoodIfNeeded() {
 	toSpawn := MaxFoodCount - len(w.Food)
@@ -441,6 +552,17 @@ func (w *World) SpawnFood() {
 	w.NextFoodID++
 }
 
+// SpawnFoodAt creates a food item at a specific position (admin tools)
+func (w *World) SpawnFoodAt(position Vec2) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	food := NewFood(w.NextFoodID)
+	food.Position = position
+	w.Food[food.ID] = food
+	w.NextFoodID++
+}
+
 // SpawnPowerupIfNeeded spawns powerups if below target count
 func (w *World) SpawnPowerupIfNeeded() {
 	toSpawn := MaxPowerupCount - len(w.Powerups)
@@ -458,6 +580,17 @@ func (w *World) SpawnPowerup() {
 	w.NextPowerupID++
 }
 
+// SpawnPowerupAt creates a powerup at a specific position (admin tools)
+func (w *World) SpawnPowerupAt(position Vec2) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	powerup := NewPowerup(w.NextPowerupID)
+	powerup.Position = position
+	w.Powerups[powerup.ID] = powerup
+	w.NextPowerupID++
+}
+
 // BroadcastState sends game state without leaderboard
 func (w *World) BroadcastState() {
 	w.mu.RLock()
@@ -477,6 +610,13 @@ func (w *World) BroadcastState() {
 			Payload: state,
 		})
 	}
+
+	for _, spectator := range w.Spectators {
+		spectator.SendMessage(ServerMessage{
+			Type:    "state",
+			Payload: w.BuildStateForSpectator(spectator, nil),
+		})
+	}
 }
 
 // BroadcastLeaderboard sends leaderboard updates separately
@@ -496,6 +636,13 @@ func (w *World) BroadcastLeaderboard() {
 			Payload: leaderboard,
 		})
 	}
+
+	for _, spectator := range w.Spectators {
+		spectator.SendMessage(ServerMessage{
+			Type:    "leaderboard",
+			Payload: leaderboard,
+		})
+	}
 }
 
 // BroadcastSharkVision sends all player positions to sharks with active vision powerup
@@ -531,7 +678,7 @@ func (w *World) BroadcastSharkVision() {
 			continue
 		}
 		
-		if player.Client.MetaConn == nil {
+		if !player.Client.HasMeta() {
 			if player.PowerupActive && player.Model == "shark" {
 				log.Printf("WARNING: Shark %s has vision active but MetaConn is nil!", player.ID)
 			}
@@ -581,6 +728,54 @@ func (w *World) Broadcast() {
 // BuildStateForPlayer creates a game state message for a specific player
 func (w *World) BuildStateForPlayer(player *Player, leaderboard []LeaderboardEntry) GameStatePayload {
 	// Player's own state
+	you := w.BuildPlayerState(player)
+
+	// Everything visible around the player
+	others, food, powerups := w.BuildVisibleState(player.Client, player.Position, player.ID)
+
+	return GameStatePayload{
+		You:         you,
+		Others:      others,
+		Food:        food,
+		Powerups:    powerups,
+		Leaderboard: leaderboard,
+	}
+}
+
+// BuildStateForSpectator creates a game state message around a spectator's viewpoint.
+// When following a player, "you" is the followed player's state; when free-roaming
+// it only carries the camera position.
+func (w *World) BuildStateForSpectator(client *Client, leaderboard []LeaderboardEntry) GameStatePayload {
+	center := client.Camera
+	you := PlayerState{
+		X:     center.X,
+		Y:     center.Y,
+		Alive: true,
+	}
+	excludeID := ""
+
+	// A missing target leaves the camera where it last was, never at the origin:
+	// following starts at the target and Disconnect leaves it at the last position
+	if target, ok := w.Players[client.SpectateTarget]; ok {
+		you = w.BuildPlayerState(target)
+		center = target.Position
+		excludeID = target.ID
+	}
+	you.Spectating = true
+
+	others, food, powerups := w.BuildVisibleState(client, center, excludeID)
+
+	return GameStatePayload{
+		You:         you,
+		Others:      others,
+		Food:        food,
+		Powerups:    powerups,
+		Leaderboard: leaderboard,
+	}
+}
+
+// BuildPlayerState creates the "you" part of a state message for a player
+func (w *World) BuildPlayerState(player *Player) PlayerState {
 	you := PlayerState{
 		ID:       player.ID,
 		Name:     player.Name,
@@ -596,6 +791,7 @@ func (w *World) BuildStateForPlayer(player *Player, leaderboard []LeaderboardEnt
 		Model:    player.Model,
 		PowerupActive: player.PowerupActive,
 		PowerupDuration: player.PowerupDuration,
+		SpawnProtected: player.IsSpawnProtected(),
 	}
 
 	if !player.Alive {
@@ -603,28 +799,42 @@ func (w *World) BuildStateForPlayer(player *Player, leaderboard []LeaderboardEnt
 		you.RespawnIn = &player.RespawnTime
 	}
 
+	return you
+}
+
+// BuildVisibleState collects the players, food and powerups a client can see from
+// the given viewpoint, sending playerInfo for players the client hasn't seen yet
+func (w *World) BuildVisibleState(client *Client, center Vec2, excludeID string) ([]OtherPlayerState, []FoodState, []PowerupState) {
 	// Other players within view distance
 	others := make([]OtherPlayerState, 0)
 	newPlayers := make([]PlayerInfoPayload, 0) // Track new players for this client
+	compact := client.Protocol() >= ProtocolV3
 	
 	for _, other := range w.Players {
-		if other.ID == player.ID || !other.Alive {
+		if other.ID == excludeID || !other.Alive {
 			continue
 		}
 
-		distance := Distance(player.Position, other.Position)
+		distance := Distance(center, other.Position)
 		if distance <= ViewDistance {
 			// Check if this is the first time this client sees this player
-			if !player.Client.SeenPlayers[other.ID] {
-				player.Client.mu.Lock()
-				player.Client.SeenPlayers[other.ID] = true
-				player.Client.mu.Unlock()
-				
+			client.mu.Lock()
+			seen := client.SeenPlayers[other.ID]
+			client.SeenPlayers[other.ID] = true
+			handle := client.PlayerHandles.Get(other.ID)
+			client.mu.Unlock()
+
+			if compact && handle == 0 {
+				continue // Out of handles, can't be referenced in compact state
+			}
+
+			if !seen {
 				// Queue player info message
 				newPlayers = append(newPlayers, PlayerInfoPayload{
-					ID:    other.ID,
-					Name:  other.Name,
-					Model: other.Model,
+					ID:     other.ID,
+					Name:   other.Name,
+					Model:  other.Model,
+					Handle: handle,
 				})
 			}
 			
@@ -637,6 +847,7 @@ func (w *World) BuildStateForPlayer(player *Player, leaderboard []LeaderboardEnt
 				PowerupActive: other.PowerupActive,
 				Rotation: other.Rotation,
 				Size:     other.Size,
+				Handle:   handle,
 				// Name and Model removed - sent once via PlayerInfo
 			})
 		}
@@ -644,7 +855,7 @@ func (w *World) BuildStateForPlayer(player *Player, leaderboard []LeaderboardEnt
 	
 	// Send new player info messages immediately
 	for _, info := range newPlayers {
-		player.Client.SendMessage(ServerMessage{
+		client.SendMessage(ServerMessage{
 			Type:    "playerInfo",
 			Payload: info,
 		})
@@ -653,7 +864,7 @@ func (w *World) BuildStateForPlayer(player *Player, leaderboard []LeaderboardEnt
 	// Food within view distance
 	food := make([]FoodState, 0)
 	for _, f := range w.Food {
-		distance := Distance(player.Position, f.Position)
+		distance := Distance(center, f.Position)
 		if distance <= ViewDistance {
 			food = append(food, FoodState{
 				ID: f.ID,
@@ -675,13 +886,7 @@ func (w *World) BuildStateForPlayer(player *Player, leaderboard []LeaderboardEnt
 		})
 	}
 
-	return GameStatePayload{
-		You:         you,
-		Others:      others,
-		Food:        food,
-		Powerups:    powerups,
-		Leaderboard: leaderboard,
-	}
+	return others, food, powerups
 }
 
 // GetLeaderboard returns the top 10 players by score
@@ -706,13 +911,251 @@ func (w *World) GetLeaderboard() []LeaderboardEntry {
 	return leaderboard
 }
 
-// AddPlayer adds a new player to the world
-func (w *World) AddPlayer(player *Player) {
+// UpdateLeader publishes a NewLeader event when someone takes the top spot
+func (w *World) UpdateLeader() {
+	var leader *Player
+	for _, p := range w.Players {
+		if p.Score <= 0 {
+			continue
+		}
+		// Ties keep the current leader
+		if leader == nil || p.Score > leader.Score || (p.Score == leader.Score && p.ID == w.LeaderID) {
+			leader = p
+		}
+	}
+
+	if leader == nil || leader.ID == w.LeaderID {
+		return
+	}
+
+	w.LeaderID = leader.ID
+	w.Events.Publish(GameEvent{
+		Type:       EventNewLeader,
+		PlayerID:   leader.ID,
+		PlayerName: leader.Name,
+		Score:      leader.Score,
+	})
+}
+
+// BroadcastEvent sends a gameplay event to every client (kill feed)
+func (w *World) BroadcastEvent(event GameEvent) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	for _, player := range w.Players {
+		if player.Client == nil {
+			continue
+		}
+
+		player.Client.SendMessage(ServerMessage{
+			Type:    "event",
+			Payload: event,
+		})
+	}
+
+	for _, spectator := range w.Spectators {
+		spectator.SendMessage(ServerMessage{
+			Type:    "event",
+			Payload: event,
+		})
+	}
+}
+
+// AddPlayer adds a new player to the world. It fails with ErrDuplicateID if a
+// player with the same ID is already in it.
+func (w *World) AddPlayer(player *Player) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	// Never let a second player overwrite an existing one
+	if _, exists := w.Players[player.ID]; exists {
+		return fmt.Errorf("player %s: %w", player.ID, ErrDuplicateID)
+	}
+
+	// Two fish can't share a name (lookalike characters count as the same)
+	player.Name = UniqueName(player.Name, func(skeleton string) bool {
+		for _, other := range w.Players {
+			if NameSkeleton(other.Name) == skeleton {
+				return true
+			}
+		}
+		return false
+	})
+
+	// Place the new fish away from anything that could eat it straight away
+	player.Position = w.FindSpawnPosition(player.Size)
+	player.SpawnProtection = SpawnProtectionTime
+
 	w.Players[player.ID] = player
 	log.Printf("Added player %s to world. Total players: %d", player.ID, len(w.Players))
+
+	w.Events.Publish(GameEvent{
+		Type:       EventPlayerJoined,
+		PlayerID:   player.ID,
+		PlayerName: player.Name,
+	})
+
+	return nil
+}
+
+// BroadcastAnnouncement sends a server announcement to every player and spectator
+func (w *World) BroadcastAnnouncement(message string) {
+	w.broadcastAll(ServerMessage{
+		Type:    "announcement",
+		Payload: AnnouncementPayload{Message: message},
+	})
+}
+
+// BroadcastShutdown warns every player and spectator that the server is going away
+func (w *World) BroadcastShutdown(payload ShutdownPayload) {
+	w.broadcastAll(ServerMessage{
+		Type:    "serverShutdown",
+		Payload: payload,
+	})
+}
+
+// broadcastAll sends msg to every player and spectator
+func (w *World) broadcastAll(msg ServerMessage) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	for _, player := range w.Players {
+		if player.Client != nil {
+			player.Client.SendMessage(msg)
+		}
+	}
+	for _, spectator := range w.Spectators {
+		spectator.SendMessage(msg)
+	}
+}
+
+// CloseAll closes every player and spectator connection (and their metadata
+// sockets) with the given close code, returning how many clients were closed
+func (w *World) CloseAll(code int, reason string) int {
+	w.mu.RLock()
+	clients := make([]*Client, 0, len(w.Players)+len(w.Spectators))
+	for _, player := range w.Players {
+		if player.Client != nil {
+			clients = append(clients, player.Client)
+		}
+	}
+	for _, spectator := range w.Spectators {
+		clients = append(clients, spectator)
+	}
+	w.mu.RUnlock()
+
+	for _, client := range clients {
+		client.mu.Lock()
+		metaConn := client.MetaConn
+		client.mu.Unlock()
+
+		if metaConn != nil {
+			closeWithCode(metaConn, code, reason)
+		}
+		closeWithCode(client.Conn, code, reason)
+	}
+	return len(clients)
+}
+
+// AddClient registers a new connection, before it has joined or started spectating
+func (w *World) AddClient(client *Client) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.Clients[client.ID] = client
+}
+
+// ClientsFrom returns every open connection from ip
+func (w *World) ClientsFrom(ip string) []*Client {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	var clients []*Client
+	for _, client := range w.Clients {
+		if client.IP == ip {
+			clients = append(clients, client)
+		}
+	}
+	return clients
+}
+
+// FindClient returns the connected client with the given ID, whether it is playing,
+// spectating or hasn't joined yet
+func (w *World) FindClient(id string) *Client {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if client, ok := w.Clients[id]; ok {
+		return client
+	}
+	if player, ok := w.Players[id]; ok && player.Client != nil {
+		return player.Client
+	}
+	return w.Spectators[id]
+}
+
+// RenameMuted replaces muted player names with the default name and returns how
+// many players were renamed. Clients are sent fresh playerInfo for them.
+func (w *World) RenameMuted() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	renamed := 0
+	for _, player := range w.Players {
+		if !moderation.IsMuted(player.Name) {
+			continue
+		}
+		player.Name = DefaultPlayerName
+		renamed++
+
+		// Forget we've sent this player's info so it's sent again
+		for _, other := range w.Players {
+			if other.Client != nil {
+				other.Client.mu.Lock()
+				delete(other.Client.SeenPlayers, player.ID)
+				other.Client.mu.Unlock()
+			}
+		}
+		for _, spectator := range w.Spectators {
+			spectator.mu.Lock()
+			delete(spectator.SeenPlayers, player.ID)
+			spectator.mu.Unlock()
+		}
+	}
+
+	return renamed
+}
+
+// SetSpectatorView attaches a client as a spectator (if it isn't one already) and
+// points its camera at a player, or at a free-roam position when target is empty.
+// Returns false if the spectator limit has been reached.
+func (w *World) SetSpectatorView(client *Client, target string, camera Vec2) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, exists := w.Spectators[client.ID]; !exists {
+		if len(w.Spectators) >= MaxSpectators {
+			return false
+		}
+		w.Spectators[client.ID] = client
+		log.Printf("Spectator %s attached. Total spectators: %d", client.ID, len(w.Spectators))
+	}
+
+	client.SpectateTarget = ""
+	client.Camera = Vec2{X: Clamp(camera.X, 0, WorldWidth), Y: Clamp(camera.Y, 0, WorldHeight)}
+	if player, ok := w.Players[target]; ok {
+		// Start from the target so the camera stays near it if it goes away
+		client.SpectateTarget = target
+		client.Camera = player.Position
+	}
+	return true
+}
+
+// RemoveSpectator detaches a spectator (e.g. when it joins as a player)
+func (w *World) RemoveSpectator(client *Client) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.Spectators, client.ID)
 }
 
 // Disconnect removes a player when they disconnect
@@ -721,9 +1164,36 @@ func (w *World) Disconnect(client *Client) {
 	defer w.mu.Unlock()
 
 	if client.Player != nil {
+		if _, exists := w.Players[client.Player.ID]; exists {
+			w.Events.Publish(GameEvent{
+				Type:       EventPlayerLeft,
+				PlayerID:   client.Player.ID,
+				PlayerName: client.Player.Name,
+				Score:      client.Player.Score,
+			})
+		}
 		delete(w.Players, client.Player.ID)
 		log.Printf("Player %s disconnected. Total players: %d", client.Player.ID, len(w.Players))
+
+		// Spectators following this player stay where it left
+		for _, spectator := range w.Spectators {
+			if spectator.SpectateTarget == client.Player.ID {
+				spectator.SpectateTarget = ""
+				spectator.Camera = client.Player.Position
+			}
+			spectator.ForgetPlayer(client.Player.ID)
+		}
+
+		// Free the player's handle on every other client
+		for _, other := range w.Players {
+			if other.Client != nil {
+				other.Client.ForgetPlayer(client.Player.ID)
+			}
+		}
 	}
 
-	close(client.Send)
+	delete(w.Spectators, client.ID)
+	delete(w.Clients, client.ID)
+
+	client.Close()
 }
@@ -0,0 +1,160 @@
+package main
+
+import "testing"
+
+// addFish puts a player of the given size at pos
+func addFish(world *World, id string, pos Vec2, size float64) *Player {
+	player := NewPlayer(id, id, "", nil)
+	player.Position = pos
+	player.Size = size
+	world.Players[id] = player
+	return player
+}
+
+func TestNearestThreatDistance(t *testing.T) {
+	type fish struct {
+		pos   Vec2
+		size  float64
+		alive bool
+	}
+	origin := Vec2{X: 1000, Y: 1000}
+	cases := []struct {
+		name string
+		fish []fish
+		want float64
+	}{
+		{"empty ocean", nil, SpawnSafeDistance},
+		{"larger fish", []fish{{Vec2{X: 1300, Y: 1000}, 180, true}}, 300},
+		{"closest of two", []fish{{Vec2{X: 1500, Y: 1000}, 180, true}, {Vec2{X: 1000, Y: 1200}, 60, true}}, 200},
+		{"smaller fish", []fish{{Vec2{X: 1100, Y: 1000}, 10, true}}, SpawnSafeDistance},
+		{"same size", []fish{{Vec2{X: 1100, Y: 1000}, InitialPlayerSize, true}}, SpawnSafeDistance},
+		{"dead fish", []fish{{Vec2{X: 1100, Y: 1000}, 180, false}}, SpawnSafeDistance},
+		{"out of range", []fish{{Vec2{X: 3000, Y: 3000}, 180, true}}, SpawnSafeDistance},
+	}
+
+	for _, tc := range cases {
+		// Before the first tick there's no quadtree, after it there is
+		for _, indexed := range []bool{false, true} {
+			world := NewWorld()
+			for i, f := range tc.fish {
+				addFish(world, string(rune('a'+i)), f.pos, f.size).Alive = f.alive
+			}
+			if indexed {
+				world.RebuildQuadtree()
+			}
+			if got := world.NearestThreatDistance(origin, InitialPlayerSize); got != tc.want {
+				t.Errorf("%s (quadtree %v): got %v, want %v", tc.name, indexed, got, tc.want)
+			}
+		}
+	}
+}
+
+func TestSafestPoint(t *testing.T) {
+	world := NewWorld()
+	addFish(world, "shark", Vec2{X: 1000, Y: 1000}, 180)
+	addFish(world, "minnow", Vec2{X: 3000, Y: 1000}, 10)
+	world.RebuildQuadtree()
+
+	cases := []struct {
+		name       string
+		candidates []Vec2
+		want       Vec2
+	}{
+		{"furthest from the shark", []Vec2{{X: 1100, Y: 1000}, {X: 1500, Y: 1000}, {X: 1300, Y: 1000}}, Vec2{X: 1500, Y: 1000}},
+		{"smaller fish aren't threats", []Vec2{{X: 1400, Y: 1000}, {X: 3000, Y: 1050}}, Vec2{X: 3000, Y: 1050}},
+		{"first safe candidate", []Vec2{{X: 1200, Y: 1000}, {X: 1000, Y: 2000}, {X: 3000, Y: 3000}}, Vec2{X: 1000, Y: 2000}},
+	}
+	for _, tc := range cases {
+		if got := world.SafestPoint(tc.candidates, InitialPlayerSize); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	// Sampled spawns stay clear of the border
+	for i := 0; i < 20; i++ {
+		pos := world.FindSpawnPosition(InitialPlayerSize)
+		if pos.X < SpawnMargin || pos.X > WorldWidth-SpawnMargin || pos.Y < SpawnMargin || pos.Y > WorldHeight-SpawnMargin {
+			t.Fatalf("spawn %v is inside the border margin", pos)
+		}
+	}
+}
+
+func TestSpawnProtection(t *testing.T) {
+	cases := []struct {
+		name        string
+		eaterFresh  bool
+		targetFresh bool
+		eaten       bool
+	}{
+		{"neither protected", false, false, true},
+		{"protected fish can't be eaten", false, true, false},
+		{"protected fish can't eat", true, false, false},
+	}
+	for _, tc := range cases {
+		world := NewWorld()
+		shark := addFish(world, "shark", Vec2{X: 1000, Y: 1000}, 180)
+		minnow := addFish(world, "minnow", Vec2{X: 1000, Y: 1000}, InitialPlayerSize)
+		if tc.eaterFresh {
+			shark.SpawnProtection = SpawnProtectionTime
+		}
+		if tc.targetFresh {
+			minnow.SpawnProtection = SpawnProtectionTime
+		}
+
+		world.EatPlayer(shark, minnow)
+		if minnow.Alive == tc.eaten {
+			t.Errorf("%s: eaten = %v, want %v", tc.name, !minnow.Alive, tc.eaten)
+		}
+	}
+
+	// Respawning starts the window, and it counts down to zero
+	world := NewWorld()
+	shark := addFish(world, "shark", Vec2{X: 1000, Y: 1000}, 180)
+	minnow := addFish(world, "minnow", Vec2{X: 1000, Y: 1000}, InitialPlayerSize)
+	minnow.Respawn(Vec2{X: 1000, Y: 1000})
+	if !minnow.IsSpawnProtected() {
+		t.Fatal("a respawned fish should be protected")
+	}
+
+	world.HandleRespawns(SpawnProtectionTime / 2)
+	if !minnow.IsSpawnProtected() {
+		t.Fatal("protection shouldn't run out halfway")
+	}
+	world.HandleRespawns(SpawnProtectionTime)
+	if minnow.SpawnProtection != 0 {
+		t.Fatalf("protection should stop at 0, got %v", minnow.SpawnProtection)
+	}
+
+	world.EatPlayer(shark, minnow)
+	if minnow.Alive {
+		t.Error("fish should be edible once protection runs out")
+	}
+}
+
+func TestSpectatorKeepsCameraWhenTargetLeaves(t *testing.T) {
+	world := NewWorld()
+	nemo := addFish(world, "nemo", Vec2{X: 1500, Y: 2500}, InitialPlayerSize)
+	nemo.Client = NewClient(nemo.ID, nil, world)
+	nemo.Client.Player = nemo
+
+	// Following a player without a camera position starts at the player
+	spectator := NewClient("spectator", nil, world)
+	world.SetSpectatorView(spectator, nemo.ID, Vec2{})
+	if you := world.BuildStateForSpectator(spectator, nil).You; you.X != 1500 || you.Y != 2500 {
+		t.Fatalf("spectator should follow nemo, got (%v, %v)", you.X, you.Y)
+	}
+
+	nemo.Position = Vec2{X: 1600, Y: 2600}
+	world.Disconnect(nemo.Client)
+
+	you := world.BuildStateForSpectator(spectator, nil).You
+	if you.X != 1600 || you.Y != 2600 || !you.Spectating || you.ID != "" {
+		t.Errorf("spectator should free-roam where nemo left, got %+v", you)
+	}
+
+	// An unknown target doesn't send the camera to the origin either
+	world.SetSpectatorView(spectator, "gone", Vec2{X: 800, Y: 900})
+	if you := world.BuildStateForSpectator(spectator, nil).You; you.X != 800 || you.Y != 900 {
+		t.Errorf("unknown target should keep the given camera, got (%v, %v)", you.X, you.Y)
+	}
+}